@@ -0,0 +1,91 @@
+package merkle
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+)
+
+// AggregationTree is a Tree whose leaves are the roots of other trees, as in
+// rollups of rollups. The roots are used as leaves without being hashed again.
+type AggregationTree struct {
+	*Tree
+}
+
+// AggregatedProof proves a leaf of a subtree against the root of an
+// AggregationTree
+type AggregatedProof struct {
+	// Position of the subtree root among the aggregated roots
+	SubRootIndex uint
+	// Proof of the leaf against the subtree root
+	SubTreeProof []ProofNode
+	// Proof of the subtree root against the aggregate root
+	AggregationProof []ProofNode
+}
+
+// NewAggregationTree generates the tree aggregating subRoots, which are
+// stored as they are whatever DisableHashLeaves is
+func NewAggregationTree(subRoots [][]byte, h hash.Hash, opts TreeOptions) (*AggregationTree, error) {
+	opts.DisableHashLeaves = true
+	tree := NewTreeWithOpts(h, opts)
+	err := tree.Generate(subRoots, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &AggregationTree{Tree: tree}, nil
+}
+
+// GetAggregatedProof attaches subTreeProof, the proof of a leaf against the
+// subtree root at subRootIndex, to the proof of that root in the aggregation
+func (self *AggregationTree) GetAggregatedProof(subRootIndex uint, subTreeProof []ProofNode) (*AggregatedProof, error) {
+	proof, err := self.GetMerkleProof(subRootIndex)
+	if err != nil {
+		return nil, err
+	}
+	return &AggregatedProof{SubRootIndex: subRootIndex, SubTreeProof: subTreeProof, AggregationProof: proof}, nil
+}
+
+// Verify folds the subtree proof onto the leaf hash, then the aggregation
+// proof onto the resulting subtree root, and compares it with aggregateRoot
+func (self *AggregatedProof) Verify(leafHash []byte, aggregateRoot []byte, h hash.Hash, opts TreeOptions) (bool, error) {
+	subRoot, err := rootFromProof(leafHash, self.SubTreeProof, h, opts)
+	if err != nil {
+		return false, err
+	}
+	root, err := rootFromProof(subRoot, self.AggregationProof, h, opts)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(root, aggregateRoot), nil
+}
+
+// ChainedProof proves a leaf through nested trees, where the root of each
+// tree is a leaf of the next one. Links are ordered from the innermost tree
+// to the outermost one.
+type ChainedProof struct {
+	Links []Proof
+}
+
+// Verify folds every link onto the result of the previous one, starting from
+// the leaf data stored the way a Tree configured with opts stores it, and
+// compares the outermost root with root. Each link must match the
+// path of its leaf index in a tree of its leaf count.
+func (self *ChainedProof) Verify(leafData []byte, root []byte, h hash.Hash, opts TreeOptions) (bool, error) {
+	if len(self.Links) == 0 {
+		return false, errors.New("chained proof has no link")
+	}
+	current, err := leafOf(leafData, h, opts)
+	if err != nil {
+		return false, err
+	}
+	for _, link := range self.Links {
+		if !matchesProofDirections(link.Nodes, uint64(link.LeafIndex), link.LeafCount, opts) {
+			return false, nil
+		}
+		current, err = rootFromProof(current, link.Nodes, h, opts)
+		if err != nil {
+			return false, err
+		}
+	}
+	return bytes.Equal(current, root), nil
+}
@@ -0,0 +1,103 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregationTree(t *testing.T) {
+	h := sha256.New()
+	sizes := []int{3, 8, 1, 5}
+	subTrees := make([]*Tree, len(sizes))
+	subData := make([][][]byte, len(sizes))
+	subRoots := make([][]byte, len(sizes))
+	for i, size := range sizes {
+		subData[i] = createDummyTreeData(size, h.Size(), true)
+		subTrees[i] = NewTree(h)
+		err := subTrees[i].Generate(subData[i], 0)
+		assert.Nil(t, err)
+		subRoots[i] = subTrees[i].RootHash()
+	}
+
+	aggregation, err := NewAggregationTree(subRoots, h, TreeOptions{})
+	assert.Nil(t, err)
+	expected := NewTree(h)
+	err = expected.Generate(subRoots, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, expected.RootHash(), aggregation.RootHash())
+
+	for i, tree := range subTrees {
+		for j, leaf := range subData[i] {
+			subProof, err := tree.GetMerkleProof(uint(j))
+			assert.Nil(t, err)
+			proof, err := aggregation.GetAggregatedProof(uint(i), subProof)
+			assert.Nil(t, err)
+			assert.Equal(t, uint(i), proof.SubRootIndex)
+
+			ok, err := proof.Verify(leaf, aggregation.RootHash(), h, TreeOptions{})
+			assert.Nil(t, err)
+			assert.True(t, ok)
+
+			// the leaf of another subtree does not verify
+			ok, err = proof.Verify(subData[(i+1)%len(sizes)][0], aggregation.RootHash(), h, TreeOptions{})
+			assert.Nil(t, err)
+			assert.False(t, ok)
+		}
+	}
+
+	_, err = aggregation.GetAggregatedProof(uint(len(sizes)), nil)
+	assert.Equal(t, "node index is too big for node count", err.Error())
+	_, err = NewAggregationTree(nil, h, TreeOptions{})
+	assert.Equal(t, "Empty tree", err.Error())
+}
+
+func TestAggregatedProofFailedHash(t *testing.T) {
+	proof := &AggregatedProof{SubTreeProof: []ProofNode{{Hash: []byte{1}}}}
+	_, err := proof.Verify([]byte{0}, []byte{0}, NewFailingHash(), TreeOptions{})
+	assert.Equal(t, "Failed to write hash", err.Error())
+}
+
+func TestChainedProof(t *testing.T) {
+	h := sha256.New()
+	// field trees rolled into document trees, rolled into an anchor tree
+	fields := createDummyTreeData(5, h.Size(), true)
+	fieldTree := NewTree(h)
+	err := fieldTree.Generate(fields, 0)
+	assert.Nil(t, err)
+	documents := createDummyTreeData(3, h.Size(), true)
+	documents[1] = fieldTree.RootHash()
+	documentTree := NewTree(h)
+	err = documentTree.Generate(documents, 0)
+	assert.Nil(t, err)
+	anchors := createDummyTreeData(6, h.Size(), true)
+	anchors[4] = documentTree.RootHash()
+	anchorTree := NewTree(h)
+	err = anchorTree.Generate(anchors, 0)
+	assert.Nil(t, err)
+
+	fieldProof, err := fieldTree.GetProof(3)
+	assert.Nil(t, err)
+	documentProof, err := documentTree.GetProof(1)
+	assert.Nil(t, err)
+	anchorProof, err := anchorTree.GetProof(4)
+	assert.Nil(t, err)
+	proof := &ChainedProof{Links: []Proof{*fieldProof, *documentProof, *anchorProof}}
+
+	ok, err := proof.Verify(fields[3], anchorTree.RootHash(), h, fieldTree.opts)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = proof.Verify(fields[2], anchorTree.RootHash(), h, fieldTree.opts)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// a link claiming another position is rejected
+	proof.Links[1].LeafIndex = 0
+	ok, err = proof.Verify(fields[3], anchorTree.RootHash(), h, fieldTree.opts)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	_, err = (&ChainedProof{}).Verify(fields[3], anchorTree.RootHash(), h, fieldTree.opts)
+	assert.Equal(t, "chained proof has no link", err.Error())
+}
@@ -0,0 +1,145 @@
+package merkle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+	"runtime"
+	"sync"
+)
+
+// ProofItem is a leaf hash with its proof, verified by VerifyProofBatch
+type ProofItem struct {
+	LeafHash []byte
+	Proof    []ProofNode
+}
+
+// VerifyProofBatch verifies many proofs against the same root, for trees
+// built by NewTree, whose leaves are not hashed and can have any length
+func VerifyProofBatch(root []byte, items []ProofItem, h hash.Hash) ([]bool, error) {
+	return VerifyProofBatchWithOpts(root, items, h, TreeOptions{DisableHashLeaves: true})
+}
+
+// VerifyProofBatchWithOpts verifies many proofs against the same root, for
+// binary trees configured with opts, without hash sorting. All items are
+// checked for structural errors, such as hashes of the wrong size when the
+// leaves are hashed, before any hashing, and the hash instance and its
+// buffers are reused across items.
+func VerifyProofBatchWithOpts(root []byte, items []ProofItem, h hash.Hash, opts TreeOptions) ([]bool, error) {
+	err := checkProofItems(items, h.Size(), opts)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]bool, len(items))
+	current := make([]byte, 0, h.Size())
+	for i, item := range items {
+		current, err = foldProofItem(current[:0], item, h)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = bytes.Equal(current, root)
+	}
+	return results, nil
+}
+
+// VerifyProofBatchParallel verifies the items as VerifyProofBatch does, on
+// one goroutine per CPU, each with a hash instance taken from a pool filled
+// by newHash
+func VerifyProofBatchParallel(root []byte, items []ProofItem, newHash func() hash.Hash) ([]bool, error) {
+	return VerifyProofBatchParallelWithOpts(root, items, newHash, TreeOptions{DisableHashLeaves: true})
+}
+
+// VerifyProofBatchParallelWithOpts verifies the items as
+// VerifyProofBatchWithOpts does, on one goroutine per CPU
+func VerifyProofBatchParallelWithOpts(root []byte, items []ProofItem, newHash func() hash.Hash, opts TreeOptions) ([]bool, error) {
+	pool := sync.Pool{New: func() interface{} { return newHash() }}
+	h := pool.Get().(hash.Hash)
+	err := checkProofItems(items, h.Size(), opts)
+	pool.Put(h)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]bool, len(items))
+	errs := make([]error, len(items))
+	workers := runtime.NumCPU()
+	if workers > len(items) {
+		workers = len(items)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			h := pool.Get().(hash.Hash)
+			defer pool.Put(h)
+			var current []byte
+			for i := w; i < len(items); i += workers {
+				current, errs[i] = foldProofItem(current[:0], items[i], h)
+				results[i] = errs[i] == nil && bytes.Equal(current, root)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// Following are non public
+
+// Returns an error if opts describe a tree the items can not be folded for,
+// or, when the leaves are hashed, for the first item with a leaf or a node
+// hash which does not have the hash size. Raw leaves can have any length,
+// and so can the proof nodes, which may be raw leaves.
+func checkProofItems(items []ProofItem, size int, opts TreeOptions) error {
+	if opts.EnableHashSorting {
+		return errors.New("hash sorting is not supported")
+	}
+	if opts.Arity != 0 && opts.Arity != 2 {
+		return errors.New("tree options should have an arity of 2")
+	}
+	if opts.DisableHashLeaves {
+		return nil
+	}
+	for i, item := range items {
+		if len(item.LeafHash) != size {
+			return fmt.Errorf("proof item %d: leaf hash should be %d bytes", i, size)
+		}
+		for j, n := range item.Proof {
+			if len(n.Hash) != size {
+				return fmt.Errorf("proof item %d: hash of node %d should be %d bytes", i, j, size)
+			}
+		}
+	}
+	return nil
+}
+
+// Folds the proof of the item onto its leaf hash, writing the children
+// straight into the hash and summing into buf
+func foldProofItem(buf []byte, item ProofItem, h hash.Hash) ([]byte, error) {
+	buf = append(buf, item.LeafHash...)
+	for _, n := range item.Proof {
+		left, right := buf, n.Hash
+		if n.Left {
+			left, right = n.Hash, buf
+		}
+		h.Reset()
+		_, err := h.Write(left)
+		if err != nil {
+			return nil, err
+		}
+		_, err = h.Write(right)
+		if err != nil {
+			return nil, err
+		}
+		buf = h.Sum(buf[:0])
+	}
+	h.Reset()
+	return buf, nil
+}
@@ -0,0 +1,103 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"hash"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func proofItems(t testing.TB, count int) (*Tree, []ProofItem) {
+	h := sha256.New()
+	data := createDummyTreeData(count, h.Size(), true)
+	tree := NewTree(h)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+	items := make([]ProofItem, count)
+	for i := range data {
+		proof, err := tree.GetMerkleProof(uint(i))
+		assert.Nil(t, err)
+		items[i] = ProofItem{LeafHash: data[i], Proof: proof}
+	}
+	return tree, items
+}
+
+func TestVerifyProofBatch(t *testing.T) {
+	tree, items := proofItems(t, 13)
+	// swap the leaves of two items
+	items[3].LeafHash, items[4].LeafHash = items[4].LeafHash, items[3].LeafHash
+	expected := make([]bool, 13)
+	for i := range expected {
+		expected[i] = i != 3 && i != 4
+	}
+
+	results, err := VerifyProofBatch(tree.RootHash(), items, sha256.New())
+	assert.Nil(t, err)
+	assert.Equal(t, expected, results)
+	results, err = VerifyProofBatchParallel(tree.RootHash(), items, sha256.New)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, results)
+}
+
+func TestVerifyProofBatchRawLeaves(t *testing.T) {
+	// raw leaves of any length, the last one promoted to the third level
+	data := [][]byte{[]byte("a"), []byte("bb"), []byte("ccc"), []byte("dddd"), {}}
+	tree := NewTree(sha256.New())
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+	items := make([]ProofItem, len(data))
+	for i := range data {
+		proof, err := tree.GetMerkleProof(uint(i))
+		assert.Nil(t, err)
+		items[i] = ProofItem{LeafHash: data[i], Proof: proof}
+	}
+
+	expected := []bool{true, true, true, true, true}
+	results, err := VerifyProofBatch(tree.RootHash(), items, sha256.New())
+	assert.Nil(t, err)
+	assert.Equal(t, expected, results)
+	results, err = VerifyProofBatchParallel(tree.RootHash(), items, sha256.New)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, results)
+}
+
+func TestVerifyProofBatchStructuralError(t *testing.T) {
+	tree, items := proofItems(t, 5)
+	items[2].Proof[1].Hash = items[2].Proof[1].Hash[:16]
+	opts := TreeOptions{}
+	_, err := VerifyProofBatchWithOpts(tree.RootHash(), items, sha256.New(), opts)
+	assert.Equal(t, "proof item 2: hash of node 1 should be 32 bytes", err.Error())
+	_, err = VerifyProofBatchParallelWithOpts(tree.RootHash(), items, sha256.New, opts)
+	assert.Equal(t, "proof item 2: hash of node 1 should be 32 bytes", err.Error())
+
+	items[0].LeafHash = nil
+	_, err = VerifyProofBatchWithOpts(tree.RootHash(), items, sha256.New(), opts)
+	assert.Equal(t, "proof item 0: leaf hash should be 32 bytes", err.Error())
+
+	_, err = VerifyProofBatchWithOpts(tree.RootHash(), items, sha256.New(), TreeOptions{EnableHashSorting: true})
+	assert.Equal(t, "hash sorting is not supported", err.Error())
+	_, err = VerifyProofBatchParallelWithOpts(tree.RootHash(), items, sha256.New, TreeOptions{Arity: 4})
+	assert.Equal(t, "tree options should have an arity of 2", err.Error())
+
+	tree, items = proofItems(t, 2)
+	_, err = VerifyProofBatchParallel(tree.RootHash(), items, func() hash.Hash {
+		count := 0
+		return NewHashCountErrorDecorator(sha256.New(), &count, 1)
+	})
+	assert.Equal(t, "Hash error", err.Error())
+}
+
+func BenchmarkVerifyProofBatch_50K(b *testing.B) {
+	tree, items := proofItems(b, 1<<10)
+	for len(items) < 50000 {
+		items = append(items, items...)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := VerifyProofBatch(tree.RootHash(), items, sha256.New())
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
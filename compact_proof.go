@@ -0,0 +1,245 @@
+package merkle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+	"math/bits"
+)
+
+// CompactProof stores the Left flags of a proof as a bitmask, as expected by
+// on-chain verifiers and wire formats. Bit i of PathBits is set when the i-th
+// hash is a left sibling.
+type CompactProof struct {
+	Hashes   [][]byte
+	PathBits uint64
+	Depth    uint8
+}
+
+// NewCompactProof converts a proof of at most 64 nodes to a CompactProof
+func NewCompactProof(proof []ProofNode) (*CompactProof, error) {
+	if len(proof) > 64 {
+		return nil, errors.New("proof is too long for a compact proof")
+	}
+	compact := &CompactProof{Hashes: make([][]byte, len(proof)), Depth: uint8(len(proof))}
+	for i, n := range proof {
+		compact.Hashes[i] = n.Hash
+		if n.Left {
+			compact.PathBits |= 1 << uint(i)
+		}
+	}
+	return compact, nil
+}
+
+// ProofNodes converts the compact proof back to proof nodes
+func (self *CompactProof) ProofNodes() ([]ProofNode, error) {
+	if len(self.Hashes) != int(self.Depth) {
+		return nil, errors.New("compact proof depth does not match its hashes")
+	}
+	if self.Depth < 64 && self.PathBits>>self.Depth != 0 {
+		return nil, errors.New("compact proof has path bits beyond its depth")
+	}
+	proof := make([]ProofNode, len(self.Hashes))
+	for i, hash := range self.Hashes {
+		proof[i] = ProofNode{Hash: hash, Left: self.PathBits&(1<<uint(i)) != 0}
+	}
+	return proof, nil
+}
+
+// CompressedSMTProof is an SMT proof where siblings equal to the root of an
+// empty subtree are replaced by a bit, as the verifier can recompute them
+// from the empty leaf hash
+type CompressedSMTProof struct {
+	// Hashes of the siblings which are not empty subtrees
+	Hashes [][]byte
+	// Bit i is set when sibling i is a left sibling
+	PathBits uint64
+	// Bit i is set when sibling i is the root of an empty subtree
+	EmptyBits uint64
+	Depth     uint8
+}
+
+// GetCompressedMerkleProof returns the proof of a leaf as GetMerkleProof, with
+// the empty subtree siblings elided
+func (self *SMT) GetCompressedMerkleProof(leafNo uint) (*CompressedSMTProof, error) {
+	proof, err := self.GetMerkleProof(leafNo)
+	if err != nil {
+		return nil, err
+	}
+	return compressSMTProof(proof, self.emptyTreeRootHash)
+}
+
+// Decompress rebuilds the full proof, computing the elided empty subtree
+// hashes from the empty leaf hash
+func (self *CompressedSMTProof) Decompress(emptyHash Hash, hashFunc hash.Hash) ([]ProofNode, error) {
+	if self.Depth > 64 {
+		return nil, errors.New("proof is too long for a compact proof")
+	}
+	if self.Depth < 64 && (self.PathBits|self.EmptyBits)>>self.Depth != 0 {
+		return nil, errors.New("compact proof has path bits beyond its depth")
+	}
+	proof := make([]ProofNode, self.Depth)
+	hashes := self.Hashes
+	empty := []byte(emptyHash)
+	for i := range proof {
+		proof[i].Left = self.PathBits&(1<<uint(i)) != 0
+		if self.EmptyBits&(1<<uint(i)) != 0 {
+			proof[i].Hash = empty
+		} else {
+			if len(hashes) == 0 {
+				return nil, errors.New("compact proof depth does not match its hashes")
+			}
+			proof[i].Hash = hashes[0]
+			hashes = hashes[1:]
+		}
+		if i+1 < len(proof) {
+			node, err := NewNode(hashFunc, concatHashes(empty, empty, false))
+			if err != nil {
+				return nil, err
+			}
+			empty = node.Hash
+		}
+	}
+	if len(hashes) != 0 {
+		return nil, errors.New("compact proof depth does not match its hashes")
+	}
+	return proof, nil
+}
+
+// EstimateProofSize returns the largest number of hashes in the compressed
+// proof of a leaf of an SMT of totalLeaves leaves, of which the first
+// nonEmptyLeaves are set. Empty subtree siblings are not counted, so a full
+// tree gives the length of uncompressed proofs. Multiply by the hash size to
+// get a size in bytes.
+func EstimateProofSize(totalLeaves uint64, nonEmptyLeaves int) (int, error) {
+	if !isPowerOfTwo(totalLeaves) {
+		return 0, errors.New("Leaves number of SMT tree should be power of 2")
+	}
+	if nonEmptyLeaves < 0 || uint64(nonEmptyLeaves) > totalLeaves {
+		return 0, errors.New("NonEmptyLeaves is bigger than totalSize")
+	}
+	return maxNonEmptySiblings(logBaseTwo(totalLeaves), 0, uint64(nonEmptyLeaves)), nil
+}
+
+// Following are non public
+
+// Returns the largest number of non empty siblings on the path of a leaf
+// under the node at index of level, counted from the leaves, when the first
+// nonEmpty leaves are set
+func maxNonEmptySiblings(level, index, nonEmpty uint64) int {
+	start, end := index<<level, (index+1)<<level
+	if level == 0 || start >= nonEmpty {
+		return 0
+	}
+	if end <= nonEmpty {
+		return int(level)
+	}
+	// The left child is not empty, the right child only if the tree is set
+	// beyond the middle of the node
+	middle := (2*index + 1) << (level - 1)
+	left := maxNonEmptySiblings(level-1, 2*index, nonEmpty)
+	if middle < nonEmpty {
+		left++
+	}
+	right := maxNonEmptySiblings(level-1, 2*index+1, nonEmpty) + 1
+	if right > left {
+		return right
+	}
+	return left
+}
+
+// Compresses a binary SMT proof given the empty subtree hash of each level,
+// from the leaves up
+func compressSMTProof(proof []ProofNode, emptyTreeRootHash []Hash) (*CompressedSMTProof, error) {
+	if len(proof) > 64 {
+		return nil, errors.New("proof is too long for a compact proof")
+	}
+	compressed := &CompressedSMTProof{Hashes: [][]byte{}, Depth: uint8(len(proof))}
+	for i, n := range proof {
+		if n.Left {
+			compressed.PathBits |= 1 << uint(i)
+		}
+		if i < len(emptyTreeRootHash) && bytes.Equal(n.Hash, emptyTreeRootHash[i]) {
+			compressed.EmptyBits |= 1 << uint(i)
+		} else {
+			compressed.Hashes = append(compressed.Hashes, n.Hash)
+		}
+	}
+	return compressed, nil
+}
+
+// SMTProofHeader describes the structure of an SMT proof, so that a verifier
+// knowing only the hash function can rebuild the empty subtree siblings and
+// reject malformed proofs before hashing
+type SMTProofHeader struct {
+	// Number of levels of the tree, leaves and root included
+	TreeHeight uint8
+	LeafIndex  uint64
+	EmptyLeaf  []byte
+	// Bit i is set when sibling i, from the leaves up, is the root of an
+	// empty subtree
+	EmptySiblings uint64
+}
+
+// HeaderedSMTProof is an SMT proof with its header, holding the hashes of the
+// siblings which are not empty subtrees, from the leaves up
+type HeaderedSMTProof struct {
+	Header SMTProofHeader
+	Hashes [][]byte
+}
+
+// GetMerkleProofWithHeader returns the proof of a leaf, empty or not, with a
+// header describing the tree and the empty subtree siblings
+func (self *SMT) GetMerkleProofWithHeader(leafNo uint) (*HeaderedSMTProof, error) {
+	compressed, err := self.GetCompressedMerkleProof(leafNo)
+	if err != nil {
+		return nil, err
+	}
+	header := SMTProofHeader{TreeHeight: uint8(self.treeHeight), LeafIndex: uint64(leafNo), EmptyLeaf: self.emptyHash, EmptySiblings: compressed.EmptyBits}
+	return &HeaderedSMTProof{Header: header, Hashes: compressed.Hashes}, nil
+}
+
+// VerifyHeaderedSMTProof checks that leaf is at the index of the proof in the
+// SMT with the given root, a nil leaf standing for emptyHash. The structure
+// of the proof is checked first, its empty leaf must be emptyHash.
+func VerifyHeaderedSMTProof(root, leaf []byte, proof *HeaderedSMTProof, emptyHash Hash, hashFunc hash.Hash) (bool, error) {
+	header := proof.Header
+	if !bytes.Equal(header.EmptyLeaf, emptyHash) {
+		return false, errors.New("proof empty leaf does not match the empty leaf of the tree")
+	}
+	if header.TreeHeight == 0 || header.TreeHeight > 65 {
+		return false, errors.New("proof tree height is invalid")
+	}
+	depth := uint(header.TreeHeight - 1)
+	if depth < 64 && header.LeafIndex>>depth != 0 {
+		return false, errors.New("Leaf index is out of bounds")
+	}
+	if depth < 64 && header.EmptySiblings>>depth != 0 {
+		return false, errors.New("proof has empty siblings beyond its depth")
+	}
+	if len(proof.Hashes) != int(depth)-bits.OnesCount64(header.EmptySiblings) {
+		return false, errors.New("proof hashes do not match its empty siblings")
+	}
+	// Siblings above the leaves are node hashes
+	hashes := proof.Hashes
+	for i := uint(0); i < depth; i++ {
+		if header.EmptySiblings&(1<<i) != 0 {
+			continue
+		}
+		if i > 0 && len(hashes[0]) != hashFunc.Size() {
+			return false, fmt.Errorf("proof hashes should be %d bytes", hashFunc.Size())
+		}
+		hashes = hashes[1:]
+	}
+
+	compressed := &CompressedSMTProof{Hashes: proof.Hashes, PathBits: header.LeafIndex, EmptyBits: header.EmptySiblings, Depth: uint8(depth)}
+	nodes, err := compressed.Decompress(emptyHash, hashFunc)
+	if err != nil {
+		return false, err
+	}
+	if leaf == nil {
+		leaf = emptyHash
+	}
+	return VerifyProof(leaf, nodes, root, hashFunc, TreeOptions{})
+}
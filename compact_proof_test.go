@@ -0,0 +1,230 @@
+package merkle
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompactProof(t *testing.T) {
+	h := sha256.New()
+	data := createDummyTreeData(11, h.Size(), true)
+	tree := NewTree(h)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+
+	for i := range data {
+		proof, err := tree.GetMerkleProof(uint(i))
+		assert.Nil(t, err)
+		compact, err := NewCompactProof(proof)
+		assert.Nil(t, err)
+		assert.Equal(t, uint8(len(proof)), compact.Depth)
+		for j, n := range proof {
+			assert.Equal(t, n.Left, compact.PathBits>>uint(j)&1 == 1)
+		}
+
+		decoded, err := compact.ProofNodes()
+		assert.Nil(t, err)
+		assert.Equal(t, proof, decoded)
+	}
+
+	// the path of leaf 5 alternates between right and left children
+	proof, err := tree.GetMerkleProof(5)
+	assert.Nil(t, err)
+	compact, err := NewCompactProof(proof)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0x5), compact.PathBits)
+}
+
+func TestCompactProofInvalidArgument(t *testing.T) {
+	_, err := NewCompactProof(make([]ProofNode, 65))
+	assert.Equal(t, "proof is too long for a compact proof", err.Error())
+
+	compact, err := NewCompactProof(make([]ProofNode, 64))
+	assert.Nil(t, err)
+	compact.PathBits = 1 << 63
+	_, err = compact.ProofNodes()
+	assert.Nil(t, err)
+
+	compact = &CompactProof{Hashes: [][]byte{{1}}, Depth: 2}
+	_, err = compact.ProofNodes()
+	assert.Equal(t, "compact proof depth does not match its hashes", err.Error())
+	compact = &CompactProof{Hashes: [][]byte{{1}}, Depth: 1, PathBits: 2}
+	_, err = compact.ProofNodes()
+	assert.Equal(t, "compact proof has path bits beyond its depth", err.Error())
+}
+
+func TestCompressedSMTProof(t *testing.T) {
+	hash := hashFunc
+	tree := NewSMT(emptyHash, hash)
+	err := tree.Generate(testHashes[:5], 64)
+	assert.Nil(t, err)
+
+	for i := uint(0); i < 64; i++ {
+		proof, err := tree.GetMerkleProof(i)
+		assert.Nil(t, err)
+		compressed, err := tree.GetCompressedMerkleProof(i)
+		assert.Nil(t, err)
+		assert.Equal(t, uint8(6), compressed.Depth)
+
+		decompressed, err := compressed.Decompress(emptyHash, hash)
+		assert.Nil(t, err)
+		assert.Equal(t, proof, decompressed)
+	}
+
+	// the proof of leaf 4 only keeps the node over leaves 0 to 3
+	compressed, err := tree.GetCompressedMerkleProof(4)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{tree.fullNodes[2][0]}, compressed.Hashes)
+	assert.Equal(t, uint64(0x3b), compressed.EmptyBits)
+	assert.Equal(t, uint64(0x4), compressed.PathBits)
+
+	compressed.Hashes = nil
+	_, err = compressed.Decompress(emptyHash, hash)
+	assert.Equal(t, "compact proof depth does not match its hashes", err.Error())
+	compressed.Hashes = [][]byte{testHashes[0], testHashes[1]}
+	_, err = compressed.Decompress(emptyHash, hash)
+	assert.Equal(t, "compact proof depth does not match its hashes", err.Error())
+	compressed.EmptyBits = 0x40
+	_, err = compressed.Decompress(emptyHash, hash)
+	assert.Equal(t, "compact proof has path bits beyond its depth", err.Error())
+}
+
+func TestEstimateProofSize(t *testing.T) {
+	hash := hashFunc
+	for _, total := range []int{1, 2, 16, 64} {
+		for nonEmpty := 0; nonEmpty <= total; nonEmpty++ {
+			tree := NewSMT(emptyHash, hash)
+			err := tree.Generate(testHashes[:0], total)
+			if nonEmpty > 0 {
+				leaves := make([][]byte, nonEmpty)
+				for i := range leaves {
+					leaves[i] = testHashes[i%len(testHashes)]
+				}
+				tree = NewSMT(emptyHash, hash)
+				err = tree.Generate(leaves, total)
+			}
+			assert.Nil(t, err)
+
+			longest := 0
+			for i := uint(0); i < uint(total); i++ {
+				compressed, err := tree.GetCompressedMerkleProof(i)
+				assert.Nil(t, err)
+				if len(compressed.Hashes) > longest {
+					longest = len(compressed.Hashes)
+				}
+			}
+			size, err := EstimateProofSize(uint64(total), nonEmpty)
+			assert.Nil(t, err)
+			assert.Equal(t, longest, size, fmt.Sprintf("EstimateProofSize(%d, %d)", total, nonEmpty))
+		}
+	}
+
+	_, err := EstimateProofSize(12, 3)
+	assert.Equal(t, "Leaves number of SMT tree should be power of 2", err.Error())
+	_, err = EstimateProofSize(8, 9)
+	assert.Equal(t, "NonEmptyLeaves is bigger than totalSize", err.Error())
+}
+
+func TestTreeProofLen(t *testing.T) {
+	h := sha256.New()
+	tree := NewTree(h)
+	_, err := tree.ProofLen(0)
+	assert.Equal(t, "Tree is empty", err.Error())
+
+	err = tree.Generate(createDummyTreeData(11, h.Size(), true), 0)
+	assert.Nil(t, err)
+	for i := uint(0); i < 11; i++ {
+		proof, err := tree.GetMerkleProof(i)
+		assert.Nil(t, err)
+		length, err := tree.ProofLen(i)
+		assert.Nil(t, err)
+		assert.Equal(t, len(proof), length)
+	}
+	_, err = tree.ProofLen(11)
+	assert.Equal(t, "node index is too big for node count", err.Error())
+}
+
+func TestSMTProofWithHeader(t *testing.T) {
+	h := md5.New()
+	data := createDummyTreeData(5, h.Size(), true)
+	tree := NewSMT(emptyHash, h)
+	err := tree.Generate(data, 16)
+	assert.Nil(t, err)
+
+	for i := 0; i < 16; i++ {
+		proof, err := tree.GetMerkleProofWithHeader(uint(i))
+		assert.Nil(t, err)
+		assert.Equal(t, uint8(5), proof.Header.TreeHeight)
+		assert.Equal(t, uint64(i), proof.Header.LeafIndex)
+		var leaf []byte
+		if i < 5 {
+			leaf = data[i]
+		}
+		ok, err := VerifyHeaderedSMTProof(tree.RootHash(), leaf, proof, emptyHash, h)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		ok, err = VerifyHeaderedSMTProof(tree.RootHash(), testHashes[15], proof, emptyHash, h)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	}
+
+	// leaf 12 only has the subtree of the first 8 leaves as a non-empty
+	// sibling
+	proof, err := tree.GetMerkleProofWithHeader(12)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0x7), proof.Header.EmptySiblings)
+	assert.Len(t, proof.Hashes, 1)
+}
+
+func TestSMTProofWithHeaderMalformed(t *testing.T) {
+	h := md5.New()
+	tree := NewSMT(emptyHash, h)
+	err := tree.Generate(createDummyTreeData(5, h.Size(), true), 16)
+	assert.Nil(t, err)
+	root := tree.RootHash()
+	valid, err := tree.GetMerkleProofWithHeader(3)
+	assert.Nil(t, err)
+
+	malformed := func(change func(p *HeaderedSMTProof)) *HeaderedSMTProof {
+		p := *valid
+		p.Hashes = append([][]byte{}, valid.Hashes...)
+		change(&p)
+		return &p
+	}
+	for message, proof := range map[string]*HeaderedSMTProof{
+		"proof tree height is invalid":                 malformed(func(p *HeaderedSMTProof) { p.Header.TreeHeight = 0 }),
+		"Leaf index is out of bounds":                  malformed(func(p *HeaderedSMTProof) { p.Header.LeafIndex = 16 }),
+		"proof has empty siblings beyond its depth":    malformed(func(p *HeaderedSMTProof) { p.Header.EmptySiblings |= 1 << 4 }),
+		"proof hashes do not match its empty siblings": malformed(func(p *HeaderedSMTProof) { p.Hashes = p.Hashes[1:] }),
+		"proof hashes should be 16 bytes":              malformed(func(p *HeaderedSMTProof) { p.Hashes[1] = p.Hashes[1][:8] }),
+	} {
+		_, err = VerifyHeaderedSMTProof(root, nil, proof, emptyHash, h)
+		assert.Equal(t, message, err.Error())
+	}
+}
+
+func TestSMTProofWithHeaderForgedEmptyLeaf(t *testing.T) {
+	h := md5.New()
+	data := createDummyTreeData(5, h.Size(), true)
+	tree := NewSMT(emptyHash, h)
+	err := tree.Generate(data, 16)
+	assert.Nil(t, err)
+
+	// leaf 3 is set, the prover claims it is empty by passing it off as the
+	// empty leaf of the tree
+	proof, err := tree.GetMerkleProofWithHeader(3)
+	assert.Nil(t, err)
+	proof.Header.EmptyLeaf = data[3]
+	ok, err := VerifyHeaderedSMTProof(tree.RootHash(), nil, proof, emptyHash, h)
+	assert.Equal(t, "proof empty leaf does not match the empty leaf of the tree", err.Error())
+	assert.False(t, ok)
+
+	proof.Header.EmptyLeaf = emptyHash
+	ok, err = VerifyHeaderedSMTProof(tree.RootHash(), nil, proof, emptyHash, h)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,223 @@
+package merkle
+
+import (
+	"errors"
+	"hash"
+)
+
+// CompactSMT holds the same tree as KeyedSMT, with the same roots and proofs,
+// but only stores the leaves and the nodes where the paths of two leaves part.
+// The runs of nodes in between, whose siblings are empty subtrees, are
+// hashed when needed. Memory grows with the number of keys, not with the
+// depth of the tree.
+type CompactSMT struct {
+	root              *compactNode
+	rootHash          Hash
+	hashFunc          hash.Hash
+	emptyHash         Hash
+	emptyTreeRootHash []Hash
+	depth             int
+}
+
+// A stored node of a CompactSMT. Its position is the first depth bits of
+// path, which is the path of a leaf below it. Leaves are at the depth of the
+// tree, every other node has two children.
+type compactNode struct {
+	path  []byte
+	depth int
+	left  *compactNode
+	right *compactNode
+	hash  Hash
+}
+
+// NewCompactSMT creates a tree whose empty leaves hash to emptyHash
+func NewCompactSMT(emptyHash Hash, hashFunc hash.Hash) (*CompactSMT, error) {
+	keyed, err := NewKeyedSMT(emptyHash, hashFunc)
+	if err != nil {
+		return nil, err
+	}
+	return &CompactSMT{hashFunc: hashFunc, emptyHash: emptyHash, emptyTreeRootHash: keyed.emptyTreeRootHash, depth: keyed.depth}, nil
+}
+
+// Get returns the leaf stored under key, and false if key was never updated
+func (self *CompactSMT) Get(key []byte) ([]byte, bool, error) {
+	path, err := keyToBits(key, self.hashFunc)
+	if err != nil {
+		return nil, false, err
+	}
+	node := self.root
+	for node != nil && commonPrefixLength(node.path, path, node.depth) == node.depth {
+		if node.depth == self.depth {
+			return node.hash, true, nil
+		}
+		node = node.child(path[node.depth])
+	}
+	return nil, false, nil
+}
+
+// Update stores leaf under key and updates the stored nodes on its path. On
+// error the tree is left unchanged.
+func (self *CompactSMT) Update(key, leaf []byte) error {
+	if leaf == nil {
+		return errors.New("leaves should not be nil")
+	}
+	path, err := keyToBits(key, self.hashFunc)
+	if err != nil {
+		return err
+	}
+	root, err := self.insert(self.root, path, leaf)
+	if err != nil {
+		return err
+	}
+	rootHash, err := self.liftedHash(root, 0)
+	if err != nil {
+		return err
+	}
+	self.root = root
+	self.rootHash = rootHash
+	return nil
+}
+
+// RootHash returns the root hash of the tree
+func (self *CompactSMT) RootHash() []byte {
+	if self.root == nil {
+		return self.emptyTreeRootHash[self.depth]
+	}
+	return self.rootHash
+}
+
+// Prove returns the siblings on the path of key, from the leaf up to the root,
+// as KeyedSMT.Prove. Verify it with VerifyKeyedProof.
+func (self *CompactSMT) Prove(key []byte) ([]ProofNode, error) {
+	path, err := keyToBits(key, self.hashFunc)
+	if err != nil {
+		return nil, err
+	}
+	// siblings[d] is the sibling of the node of depth d+1 on the path
+	siblings := make([][]byte, self.depth)
+	for d := range siblings {
+		siblings[d] = self.emptyTreeRootHash[self.depth-d-1]
+	}
+	node := self.root
+	for node != nil {
+		common := commonPrefixLength(node.path, path, node.depth)
+		if common < node.depth {
+			// The path leaves the stored node, whose subtree becomes the
+			// sibling where they part
+			siblings[common], err = self.liftedHash(node, common+1)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+		if node.depth == self.depth {
+			break
+		}
+		bit := path[node.depth]
+		siblings[node.depth], err = self.liftedHash(node.child(1-bit), node.depth+1)
+		if err != nil {
+			return nil, err
+		}
+		node = node.child(bit)
+	}
+
+	proof := make([]ProofNode, 0, self.depth)
+	for d := self.depth - 1; d >= 0; d-- {
+		proof = append(proof, ProofNode{Left: path[d] == 1, Hash: siblings[d]})
+	}
+	return proof, nil
+}
+
+// Following are non public function
+
+func (self *compactNode) child(bit byte) *compactNode {
+	if bit == 0 {
+		return self.left
+	}
+	return self.right
+}
+
+// Returns the node storing leaf under path in place of node, which is at a
+// depth of at least the depth of its parent plus one
+func (self *CompactSMT) insert(node *compactNode, path []byte, leaf []byte) (*compactNode, error) {
+	if node == nil {
+		return &compactNode{path: path, depth: self.depth, hash: leaf}, nil
+	}
+	common := commonPrefixLength(node.path, path, node.depth)
+	if common == self.depth {
+		return &compactNode{path: path, depth: self.depth, hash: leaf}, nil
+	}
+	branch := &compactNode{path: path, depth: common, left: node.left, right: node.right}
+	if common < node.depth {
+		// The paths part above node, a new node joins them
+		added := &compactNode{path: path, depth: self.depth, hash: leaf}
+		branch.left, branch.right = node, added
+		if path[common] == 0 {
+			branch.left, branch.right = added, node
+		}
+	} else {
+		child, err := self.insert(node.child(path[common]), path, leaf)
+		if err != nil {
+			return nil, err
+		}
+		if path[common] == 0 {
+			branch.left = child
+		} else {
+			branch.right = child
+		}
+	}
+	hash, err := self.branchHash(branch)
+	if err != nil {
+		return nil, err
+	}
+	branch.hash = hash
+	return branch, nil
+}
+
+// Returns the hash of a node with two children
+func (self *CompactSMT) branchHash(node *compactNode) ([]byte, error) {
+	left, err := self.liftedHash(node.left, node.depth+1)
+	if err != nil {
+		return nil, err
+	}
+	right, err := self.liftedHash(node.right, node.depth+1)
+	if err != nil {
+		return nil, err
+	}
+	return hashConcat(self.hashFunc, left, right)
+}
+
+// Returns the hash of the ancestor of node at depth, the siblings on the way
+// being empty subtrees
+func (self *CompactSMT) liftedHash(node *compactNode, depth int) ([]byte, error) {
+	hash := []byte(node.hash)
+	for d := node.depth - 1; d >= depth; d-- {
+		children := [][]byte{hash, self.emptyTreeRootHash[self.depth-d-1]}
+		if node.path[d] == 1 {
+			children = [][]byte{self.emptyTreeRootHash[self.depth-d-1], hash}
+		}
+		var err error
+		hash, err = hashConcat(self.hashFunc, children...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return hash, nil
+}
+
+// Returns the number of stored nodes below node, node included
+func (self *compactNode) count() int {
+	if self == nil {
+		return 0
+	}
+	return 1 + self.left.count() + self.right.count()
+}
+
+// Returns the length of the common prefix of a and b, up to max
+func commonPrefixLength(a, b []byte, max int) int {
+	i := 0
+	for i < max && a[i] == b[i] {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,66 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompactSMTMatchesKeyedSMT(t *testing.T) {
+	h := sha256.New()
+	keyed, err := NewKeyedSMT(emptyHash, h)
+	assert.Nil(t, err)
+	compact, err := NewCompactSMT(emptyHash, h)
+	assert.Nil(t, err)
+	assert.Equal(t, keyed.RootHash(), compact.RootHash())
+
+	keys := [][]byte{}
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key %d", i))
+		keys = append(keys, key)
+		err = keyed.Update(key, hashValue(key, h))
+		assert.Nil(t, err)
+		err = compact.Update(key, hashValue(key, h))
+		assert.Nil(t, err)
+		assert.Equal(t, keyed.RootHash(), compact.RootHash())
+	}
+	// 100 leaves and 99 nodes joining them
+	assert.Equal(t, 199, compact.root.count())
+
+	for _, key := range append(keys, []byte("missing")) {
+		expected, err := keyed.Prove(key)
+		assert.Nil(t, err)
+		proof, err := compact.Prove(key)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, proof)
+
+		leaf, ok, err := compact.Get(key)
+		assert.Nil(t, err)
+		expectedLeaf, expectedOk, err := keyed.Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, expectedOk, ok)
+		assert.Equal(t, []byte(expectedLeaf), leaf)
+	}
+
+	// Overwriting a leaf keeps the stored nodes
+	err = keyed.Update(keys[3], emptyHash[:8])
+	assert.Nil(t, err)
+	err = compact.Update(keys[3], emptyHash[:8])
+	assert.Nil(t, err)
+	assert.Equal(t, keyed.RootHash(), compact.RootHash())
+	assert.Equal(t, 199, compact.root.count())
+}
+
+func TestCompactSMTInvalidArgument(t *testing.T) {
+	_, err := NewCompactSMT(emptyHash, NewFailingHash())
+	assert.Equal(t, "Hash size should be positive", err.Error())
+
+	h := sha256.New()
+	tree, err := NewCompactSMT(emptyHash, h)
+	assert.Nil(t, err)
+	err = tree.Update([]byte("key"), nil)
+	assert.Equal(t, "leaves should not be nil", err.Error())
+	assert.Nil(t, tree.root)
+}
@@ -0,0 +1,150 @@
+package merkle
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+)
+
+// VerifyConsistency checks that the tree of the older checkpoint is a prefix
+// of the tree of the newer one, following section 2.1.4.2 of RFC 9162
+func VerifyConsistency(older, newer Checkpoint, proof []ProofNode, h hash.Hash, opts TreeOptions) (bool, error) {
+	if older.Size > newer.Size {
+		return false, errors.New("older checkpoint is bigger than the newer one")
+	}
+	if older.Size == newer.Size {
+		return len(proof) == 0 && bytes.Equal(older.Root, newer.Root), nil
+	}
+	if older.Size == 0 {
+		return len(proof) == 0, nil
+	}
+	if len(proof) == 0 {
+		return false, nil
+	}
+
+	path := make([][]byte, 0, len(proof)+1)
+	if isPowerOfTwo(older.Size) {
+		path = append(path, older.Root)
+	}
+	for _, n := range proof {
+		path = append(path, n.Hash)
+	}
+
+	fn := older.Size - 1
+	sn := newer.Size - 1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr := path[0]
+	sr := path[0]
+	for _, c := range path[1:] {
+		if sn == 0 {
+			return false, nil
+		}
+		if fn&1 == 1 || fn == sn {
+			node, err := NewNode(h, concatHashes(c, fr, opts.EnableHashSorting))
+			if err != nil {
+				return false, err
+			}
+			fr = node.Hash
+			node, err = NewNode(h, concatHashes(c, sr, opts.EnableHashSorting))
+			if err != nil {
+				return false, err
+			}
+			sr = node.Hash
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			node, err := NewNode(h, concatHashes(sr, c, opts.EnableHashSorting))
+			if err != nil {
+				return false, err
+			}
+			sr = node.Hash
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(fr, older.Root) && bytes.Equal(sr, newer.Root), nil
+}
+
+// ConsistencyProof returns the RFC 6962 consistency proof showing that the
+// tree made of the first oldSize leaves is a prefix of the one made of the
+// first newSize leaves
+func (self *Tree) ConsistencyProof(oldSize, newSize uint64) ([]ProofNode, error) {
+	err := self.checkBinary()
+	if err != nil {
+		return nil, err
+	}
+	err = self.checkPromote()
+	if err != nil {
+		return nil, err
+	}
+	leafCount := uint64(len(self.leaves()))
+	if leafCount == 0 {
+		return nil, errors.New("Tree is empty")
+	}
+	if newSize > leafCount {
+		return nil, errors.New("tree size is out of range")
+	}
+	return consistencyPath(oldSize, newSize, self.subtreeHash)
+}
+
+// VerifyConsistencyProof checks a proof returned by ConsistencyProof between
+// the roots of the trees of oldSize and newSize leaves
+func VerifyConsistencyProof(oldSize, newSize uint64, oldRoot, newRoot []byte, proof []ProofNode, h hash.Hash, opts TreeOptions) (bool, error) {
+	return VerifyConsistency(Checkpoint{Size: oldSize, Root: oldRoot}, Checkpoint{Size: newSize, Root: newRoot}, proof, h, opts)
+}
+
+// Following are non public
+
+// Returns the consistency proof between the first m and the first n leaves,
+// as SUBPROOF(m, D[0:n], true) in RFC 9162. subtreeHash returns the hash of
+// the leaves [start, end).
+func consistencyPath(m, n uint64, subtreeHash func(start, end uint64) ([]byte, error)) ([]ProofNode, error) {
+	if m > n {
+		return nil, errors.New("older size is bigger than the newer one")
+	}
+	if m == 0 || m == n {
+		return []ProofNode{}, nil
+	}
+	return consistencySubproof(m, 0, n, true, subtreeHash)
+}
+
+func consistencySubproof(m, start, end uint64, complete bool, subtreeHash func(start, end uint64) ([]byte, error)) ([]ProofNode, error) {
+	n := end - start
+	if m == n {
+		if complete {
+			return []ProofNode{}, nil
+		}
+		hash, err := subtreeHash(start, end)
+		if err != nil {
+			return nil, err
+		}
+		return []ProofNode{{Left: false, Hash: hash}}, nil
+	}
+
+	k := nextPowerOfTwo(n) >> 1
+	if m <= k {
+		proof, err := consistencySubproof(m, start, start+k, complete, subtreeHash)
+		if err != nil {
+			return nil, err
+		}
+		hash, err := subtreeHash(start+k, end)
+		if err != nil {
+			return nil, err
+		}
+		return append(proof, ProofNode{Left: false, Hash: hash}), nil
+	}
+	proof, err := consistencySubproof(m-k, start+k, end, false, subtreeHash)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := subtreeHash(start, start+k)
+	if err != nil {
+		return nil, err
+	}
+	return append(proof, ProofNode{Left: true, Hash: hash}), nil
+}
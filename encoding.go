@@ -0,0 +1,386 @@
+package merkle
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"math"
+	"sort"
+)
+
+const (
+	// Version of the binary proof encoding
+	proofEncodingVersion = 2
+	// Size of the binary proof encoding before the Left flags
+	proofHeaderSize = 23
+)
+
+type jsonProofNode struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"`
+}
+
+// MarshalJSON encodes the node as {"hash": "<hex>", "left": <bool>}
+func (self ProofNode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonProofNode{Hash: hex.EncodeToString(self.Hash), Left: self.Left})
+}
+
+// UnmarshalJSON decodes a node encoded by MarshalJSON
+func (self *ProofNode) UnmarshalJSON(data []byte) error {
+	var node jsonProofNode
+	err := json.Unmarshal(data, &node)
+	if err != nil {
+		return err
+	}
+	hash, err := hex.DecodeString(node.Hash)
+	if err != nil {
+		return fmt.Errorf("invalid hash of proof node: %v", err)
+	}
+	self.Hash = hash
+	self.Left = node.Left
+	return nil
+}
+
+// MarshalBinary encodes the proof as the encoding version (1 byte), the hash
+// size (1 byte), the leaf index and leaf count (8 bytes each), the tree
+// height (1 byte) and the node count (4 bytes), all big endian, then the Left
+// flags as a bitmap where bit i of byte i/8 is set for a left node i, and the
+// hashes
+func (self Proof) MarshalBinary() ([]byte, error) {
+	hashSize := 0
+	if len(self.Nodes) > 0 {
+		hashSize = len(self.Nodes[0].Hash)
+	}
+	if hashSize > 255 {
+		return nil, errors.New("proof hashes are too long")
+	}
+	if self.TreeHeight > 255 {
+		return nil, errors.New("proof tree height is too big")
+	}
+	if uint64(len(self.Nodes)) > 0xffffffff {
+		return nil, errors.New("proof has too many nodes")
+	}
+
+	bitmapSize := (len(self.Nodes) + 7) / 8
+	data := make([]byte, proofHeaderSize+bitmapSize, proofHeaderSize+bitmapSize+len(self.Nodes)*hashSize)
+	data[0] = proofEncodingVersion
+	data[1] = byte(hashSize)
+	binary.BigEndian.PutUint64(data[2:10], uint64(self.LeafIndex))
+	binary.BigEndian.PutUint64(data[10:18], self.LeafCount)
+	data[18] = byte(self.TreeHeight)
+	binary.BigEndian.PutUint32(data[19:23], uint32(len(self.Nodes)))
+	for i, n := range self.Nodes {
+		if len(n.Hash) != hashSize {
+			return nil, errors.New("proof hashes have different sizes")
+		}
+		if n.Left {
+			data[proofHeaderSize+i/8] |= 1 << uint(i%8)
+		}
+		data = append(data, n.Hash...)
+	}
+	return data, nil
+}
+
+// UnmarshalBinary decodes a proof encoded by MarshalBinary
+func (self *Proof) UnmarshalBinary(data []byte) error {
+	if len(data) < 1 {
+		return errors.New("proof encoding is too short")
+	}
+	if data[0] != proofEncodingVersion {
+		return errors.New("unsupported proof encoding version")
+	}
+	if len(data) < proofHeaderSize {
+		return errors.New("proof encoding is too short")
+	}
+	hashSize := int(data[1])
+	count := uint64(binary.BigEndian.Uint32(data[19:23]))
+	bitmapSize := (count + 7) / 8
+	if uint64(len(data)) != proofHeaderSize+bitmapSize+count*uint64(hashSize) {
+		return errors.New("proof encoding does not match its node count")
+	}
+
+	bitmap := data[proofHeaderSize : proofHeaderSize+bitmapSize]
+	hashes := data[proofHeaderSize+bitmapSize:]
+	nodes := make([]ProofNode, count)
+	for i := range nodes {
+		hash := make([]byte, hashSize)
+		copy(hash, hashes[i*hashSize:])
+		nodes[i] = ProofNode{Hash: hash, Left: bitmap[i/8]&(1<<uint(i%8)) != 0}
+	}
+	self.LeafIndex = uint(binary.BigEndian.Uint64(data[2:10]))
+	self.LeafCount = binary.BigEndian.Uint64(data[10:18])
+	self.TreeHeight = uint64(data[18])
+	self.Nodes = nodes
+	return nil
+}
+
+// Pack lays the proof out as Solidity verifiers read it: a 32 bytes big
+// endian path index whose bit i is set when node i is a left sibling,
+// followed by the 32 bytes sibling hashes. In a tree with a power of two
+// leaves, the path index is the index of the leaf.
+func (self Proof) Pack() ([]byte, error) {
+	if len(self.Nodes) > 256 {
+		return nil, errors.New("proof has too many nodes")
+	}
+	data := make([]byte, 32, 32*(len(self.Nodes)+1))
+	for i, n := range self.Nodes {
+		if len(n.Hash) != 32 {
+			return nil, errors.New("proof hashes should be 32 bytes")
+		}
+		if n.Left {
+			data[31-i/8] |= 1 << uint(i%8)
+		}
+		data = append(data, n.Hash...)
+	}
+	return data, nil
+}
+
+// UnpackProof decodes the nodes of a proof laid out by Pack. The layout has
+// no leaf index nor leaf count, verify the nodes with VerifyProof.
+func UnpackProof(data []byte) ([]ProofNode, error) {
+	if len(data) < 32 || len(data)%32 != 0 {
+		return nil, errors.New("packed proof should be a multiple of 32 bytes")
+	}
+	count := len(data)/32 - 1
+	if count > 256 {
+		return nil, errors.New("packed proof has too many nodes")
+	}
+	nodes := make([]ProofNode, count)
+	for i := range nodes {
+		hash := make([]byte, 32)
+		copy(hash, data[32*(i+1):])
+		nodes[i] = ProofNode{Hash: hash, Left: data[31-i/8]&(1<<uint(i%8)) != 0}
+	}
+	for i := count; i < 256; i++ {
+		if data[31-i/8]&(1<<uint(i%8)) != 0 {
+			return nil, errors.New("packed proof has path bits beyond its nodes")
+		}
+	}
+	return nodes, nil
+}
+
+const (
+	// Version of the SMT encoding
+	smtEncodingVersion = 1
+	// Version of the SMT encoding of a tree built by GenerateSparse
+	smtSparseEncodingVersion = 2
+)
+
+// Marshal encodes the state of a generated SMT: the encoding version (1
+// byte), the arity and the height (4 bytes each), the number of non-empty
+// leaves (8 bytes), the empty leaf, the cached empty subtree hashes and the
+// stored nodes of each level from the leaves up. Integers are big endian,
+// every hash is preceded by its size and every list by its length (4 bytes
+// each). The nodes of a tree built by GenerateSparse are each preceded by
+// their index (8 bytes), in increasing order. The hash function is not
+// encoded.
+func (self *SMT) Marshal() ([]byte, error) {
+	if len(self.fullNodes) == 0 {
+		return nil, errors.New("SMT tree is not filled")
+	}
+	// The decoder expects the hash of every empty subtree up to the root
+	err := self.computeEmptyLeavesSubTreeHash(self.hasher(), self.treeHeight)
+	if err != nil {
+		return nil, err
+	}
+	if self.sparseNodes != nil {
+		return self.marshalSparse(), nil
+	}
+	data := []byte{smtEncodingVersion}
+	data = binary.BigEndian.AppendUint32(data, uint32(self.arity))
+	data = binary.BigEndian.AppendUint32(data, uint32(self.treeHeight))
+	data = binary.BigEndian.AppendUint64(data, uint64(self.countOfNonEmptyLeaves))
+	data = appendEncodedHash(data, self.emptyHash)
+	data = appendEncodedHashes(data, self.emptyTreeRootHash)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(self.fullNodes)))
+	for _, level := range self.fullNodes {
+		data = appendEncodedHashes(data, level)
+	}
+	return data, nil
+}
+
+// UnmarshalSMT restores an SMT encoded by Marshal, which then hashes with
+// hashFunc. The nodes are not hashed again, but the sizes of the levels must
+// match the leaf count and the height of the tree.
+func UnmarshalSMT(data []byte, hashFunc hash.Hash) (*SMT, error) {
+	decoder := &smtDecoder{data: data}
+	version := decoder.uint8()
+	if version != smtEncodingVersion && version != smtSparseEncodingVersion && decoder.err == nil {
+		return nil, errors.New("unsupported SMT encoding version")
+	}
+	tree := &SMT{hashFunc: hashFunc}
+	tree.arity = int(decoder.uint32())
+	tree.treeHeight = int(decoder.uint32())
+	tree.countOfNonEmptyLeaves = int(decoder.uint64())
+	tree.emptyHash = decoder.hash()
+	tree.emptyTreeRootHash = decoder.hashes()
+	levelCount := decoder.uint32()
+	if decoder.err != nil {
+		return nil, decoder.err
+	}
+	if levelCount != uint32(tree.treeHeight) {
+		return nil, errors.New("SMT encoding does not match its height")
+	}
+	// The sizes are checked before the levels are allocated
+	if tree.arity < 2 || tree.treeHeight < 1 || len(tree.emptyTreeRootHash) < tree.treeHeight {
+		return nil, errors.New("invalid SMT encoding")
+	}
+	totalSize := uint64(1)
+	for i := 1; i < tree.treeHeight; i++ {
+		if totalSize > math.MaxUint64/uint64(tree.arity) {
+			return nil, errors.New("invalid SMT encoding")
+		}
+		totalSize *= uint64(tree.arity)
+	}
+	if tree.countOfNonEmptyLeaves < 0 || uint64(tree.countOfNonEmptyLeaves) > totalSize {
+		return nil, errors.New("invalid SMT encoding")
+	}
+	// Every level starts with its 4 bytes node count
+	if uint64(levelCount)*4 > uint64(len(decoder.data)) {
+		return nil, errors.New("SMT encoding is too short")
+	}
+	if version == smtSparseEncodingVersion {
+		tree.fullNodes = make([][]Hash, levelCount)
+		for i := uint32(0); i < levelCount && decoder.err == nil; i++ {
+			tree.sparseNodes = append(tree.sparseNodes, decoder.indexedHashes())
+		}
+	}
+	for i := uint32(0); i < levelCount && decoder.err == nil && version == smtEncodingVersion; i++ {
+		tree.fullNodes = append(tree.fullNodes, decoder.hashes())
+	}
+	if decoder.err != nil {
+		return nil, decoder.err
+	}
+	if len(decoder.data) != 0 {
+		return nil, errors.New("SMT encoding has trailing bytes")
+	}
+	if tree.sparseNodes == nil {
+		// Each level holds the parents of the nodes of the level below
+		width := tree.countOfNonEmptyLeaves
+		for _, hashes := range tree.fullNodes {
+			if len(hashes) != width {
+				return nil, errors.New("invalid SMT encoding")
+			}
+			width = (width + tree.arity - 1) / tree.arity
+		}
+	}
+	for level, hashes := range tree.sparseNodes {
+		for index := range hashes {
+			if index < 0 || index >= tree.levelWidth(level) {
+				return nil, errors.New("invalid SMT encoding")
+			}
+		}
+	}
+	return tree, nil
+}
+
+// Following are non public
+
+func (self *SMT) marshalSparse() []byte {
+	data := []byte{smtSparseEncodingVersion}
+	data = binary.BigEndian.AppendUint32(data, uint32(self.arity))
+	data = binary.BigEndian.AppendUint32(data, uint32(self.treeHeight))
+	data = binary.BigEndian.AppendUint64(data, uint64(self.countOfNonEmptyLeaves))
+	data = appendEncodedHash(data, self.emptyHash)
+	data = appendEncodedHashes(data, self.emptyTreeRootHash)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(self.sparseNodes)))
+	for level := range self.sparseNodes {
+		indices := self.storedIndices(level)
+		sort.Ints(indices)
+		data = binary.BigEndian.AppendUint32(data, uint32(len(indices)))
+		for _, index := range indices {
+			data = binary.BigEndian.AppendUint64(data, uint64(index))
+			data = appendEncodedHash(data, self.sparseNodes[level][index])
+		}
+	}
+	return data
+}
+
+func appendEncodedHash(data []byte, hash Hash) []byte {
+	data = binary.BigEndian.AppendUint32(data, uint32(len(hash)))
+	return append(data, hash...)
+}
+
+func appendEncodedHashes(data []byte, hashes []Hash) []byte {
+	data = binary.BigEndian.AppendUint32(data, uint32(len(hashes)))
+	for _, hash := range hashes {
+		data = appendEncodedHash(data, hash)
+	}
+	return data
+}
+
+// Reads the SMT encoding, the first error stops the decoding
+type smtDecoder struct {
+	data []byte
+	err  error
+}
+
+func (self *smtDecoder) next(size uint64) []byte {
+	if self.err != nil {
+		return nil
+	}
+	if uint64(len(self.data)) < size {
+		self.err = errors.New("SMT encoding is too short")
+		return nil
+	}
+	read := self.data[:size]
+	self.data = self.data[size:]
+	return read
+}
+
+func (self *smtDecoder) uint8() uint8 {
+	read := self.next(1)
+	if read == nil {
+		return 0
+	}
+	return read[0]
+}
+
+func (self *smtDecoder) uint32() uint32 {
+	read := self.next(4)
+	if read == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(read)
+}
+
+func (self *smtDecoder) uint64() uint64 {
+	read := self.next(8)
+	if read == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(read)
+}
+
+func (self *smtDecoder) hash() Hash {
+	size := self.uint32()
+	read := self.next(uint64(size))
+	if read == nil {
+		return nil
+	}
+	hash := make(Hash, size)
+	copy(hash, read)
+	return hash
+}
+
+func (self *smtDecoder) hashes() []Hash {
+	count := self.uint32()
+	hashes := []Hash{}
+	for i := uint32(0); i < count && self.err == nil; i++ {
+		hashes = append(hashes, self.hash())
+	}
+	return hashes
+}
+
+func (self *smtDecoder) indexedHashes() map[int]Hash {
+	count := self.uint32()
+	hashes := map[int]Hash{}
+	for i := uint32(0); i < count && self.err == nil; i++ {
+		index := self.uint64()
+		hashes[int(index)] = self.hash()
+	}
+	return hashes
+}
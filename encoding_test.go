@@ -0,0 +1,271 @@
+package merkle
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProofNodeJSON(t *testing.T) {
+	node := ProofNode{Left: true, Hash: []byte{0xde, 0xad, 0xbe, 0xef}}
+	data, err := json.Marshal(node)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"hash":"deadbeef","left":true}`, string(data))
+
+	var decoded ProofNode
+	err = json.Unmarshal(data, &decoded)
+	assert.Nil(t, err)
+	assert.Equal(t, node, decoded)
+
+	err = json.Unmarshal([]byte(`{"hash":"xyz","left":false}`), &decoded)
+	assert.Contains(t, err.Error(), "invalid hash of proof node")
+	err = json.Unmarshal([]byte(`{"hash":1}`), &decoded)
+	assert.NotNil(t, err)
+}
+
+func TestProofJSON(t *testing.T) {
+	h := sha256.New()
+	data := createDummyTreeData(5, h.Size(), true)
+	tree := NewTree(h)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+	proof, err := tree.GetProof(2)
+	assert.Nil(t, err)
+
+	encoded, err := json.Marshal(proof)
+	assert.Nil(t, err)
+	assert.Contains(t, string(encoded), `{"leafIndex":2,"leafCount":5,"treeHeight":4,"nodes":[{"hash":"`)
+
+	var decoded Proof
+	err = json.Unmarshal(encoded, &decoded)
+	assert.Nil(t, err)
+	assert.Equal(t, *proof, decoded)
+	ok, err := decoded.Verify(data[2], tree.RootHash(), h, tree.opts)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func TestProofBinary(t *testing.T) {
+	h := sha256.New()
+	data := createDummyTreeData(11, h.Size(), true)
+	tree := NewTree(h)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+
+	for i := range data {
+		proof, err := tree.GetProof(uint(i))
+		assert.Nil(t, err)
+		encoded, err := proof.MarshalBinary()
+		assert.Nil(t, err)
+		assert.Len(t, encoded, 23+1+len(proof.Nodes)*h.Size())
+
+		var decoded Proof
+		err = decoded.UnmarshalBinary(encoded)
+		assert.Nil(t, err)
+		assert.Equal(t, *proof, decoded)
+	}
+
+	// leaf 5 has left siblings at the first and third nodes
+	proof, err := tree.GetProof(5)
+	assert.Nil(t, err)
+	encoded, err := proof.MarshalBinary()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{2, 32, 0, 0, 0, 0, 0, 0, 0, 5, 0, 0, 0, 0, 0, 0, 0, 11, 5, 0, 0, 0, 4, 0x5}, encoded[:24])
+
+	encoded, err = Proof{}.MarshalBinary()
+	assert.Nil(t, err)
+	assert.Equal(t, make([]byte, 22), encoded[1:])
+	var decoded Proof
+	err = decoded.UnmarshalBinary(encoded)
+	assert.Nil(t, err)
+	assert.Len(t, decoded.Nodes, 0)
+}
+
+func TestProofBinaryInvalidArgument(t *testing.T) {
+	_, err := Proof{Nodes: []ProofNode{{Hash: []byte{1, 2}}, {Hash: []byte{1}}}}.MarshalBinary()
+	assert.Equal(t, "proof hashes have different sizes", err.Error())
+	_, err = Proof{Nodes: []ProofNode{{Hash: make([]byte, 256)}}}.MarshalBinary()
+	assert.Equal(t, "proof hashes are too long", err.Error())
+	_, err = Proof{TreeHeight: 256}.MarshalBinary()
+	assert.Equal(t, "proof tree height is too big", err.Error())
+
+	var proof Proof
+	err = proof.UnmarshalBinary([]byte{2, 32, 0})
+	assert.Equal(t, "proof encoding is too short", err.Error())
+	err = proof.UnmarshalBinary([]byte{1, 32, 0, 0, 0, 0})
+	assert.Equal(t, "unsupported proof encoding version", err.Error())
+	encoded := make([]byte, 25)
+	encoded[0], encoded[1], encoded[22], encoded[24] = 2, 2, 1, 0xaa
+	err = proof.UnmarshalBinary(encoded)
+	assert.Equal(t, "proof encoding does not match its node count", err.Error())
+}
+
+func TestProofPack(t *testing.T) {
+	h := sha256.New()
+	data := createDummyTreeData(8, h.Size(), true)
+	tree := NewTree(h)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+
+	for i := range data {
+		nodes, err := tree.GetMerkleProof(uint(i))
+		assert.Nil(t, err)
+		packed, err := Proof{Nodes: nodes}.Pack()
+		assert.Nil(t, err)
+		assert.Len(t, packed, 32*(len(nodes)+1))
+		// the path index of a full tree is the leaf index
+		assert.Equal(t, make([]byte, 31), packed[:31])
+		assert.Equal(t, byte(i), packed[31])
+		for j, n := range nodes {
+			assert.Equal(t, n.Hash, packed[32*(j+1):32*(j+2)])
+		}
+
+		unpacked, err := UnpackProof(packed)
+		assert.Nil(t, err)
+		assert.Equal(t, nodes, unpacked)
+		ok, err := VerifyProof(data[i], unpacked, tree.RootHash(), h, TreeOptions{})
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	}
+}
+
+func TestProofPackInvalidArgument(t *testing.T) {
+	_, err := Proof{Nodes: []ProofNode{{Hash: make([]byte, 16)}}}.Pack()
+	assert.Equal(t, "proof hashes should be 32 bytes", err.Error())
+	_, err = Proof{Nodes: make([]ProofNode, 257)}.Pack()
+	assert.Equal(t, "proof has too many nodes", err.Error())
+
+	_, err = UnpackProof(make([]byte, 48))
+	assert.Equal(t, "packed proof should be a multiple of 32 bytes", err.Error())
+	_, err = UnpackProof(nil)
+	assert.Equal(t, "packed proof should be a multiple of 32 bytes", err.Error())
+	_, err = UnpackProof(make([]byte, 32*300))
+	assert.Equal(t, "packed proof has too many nodes", err.Error())
+	_, err = UnpackProof(make([]byte, 32*257))
+	assert.Nil(t, err)
+	packed := make([]byte, 64)
+	packed[31] = 2
+	_, err = UnpackProof(packed)
+	assert.Equal(t, "packed proof has path bits beyond its nodes", err.Error())
+}
+
+func TestSMTMarshal(t *testing.T) {
+	h := md5.New()
+	for _, arity := range []int{2, 4} {
+		for _, count := range []int{0, 3, 16} {
+			tree := NewSMTWithArity(arity, h, emptyHash)
+			err := tree.Generate(createDummyTreeData(count, h.Size(), true), 16)
+			assert.Nil(t, err)
+
+			data, err := tree.Marshal()
+			assert.Nil(t, err)
+			restored, err := UnmarshalSMT(data, h)
+			assert.Nil(t, err)
+			// the counters of Stats are not encoded
+			tree.ResetStats()
+			assert.Equal(t, tree, restored)
+			assert.Equal(t, tree.RootHash(), restored.RootHash())
+		}
+	}
+
+	tree := NewSMT(emptyHash, h)
+	data := createDummyTreeData(5, h.Size(), true)
+	err := tree.Generate(data, 8)
+	assert.Nil(t, err)
+	encoded, err := tree.Marshal()
+	assert.Nil(t, err)
+	restored, err := UnmarshalSMT(encoded, h)
+	assert.Nil(t, err)
+	proof, err := restored.GetMerkleProof(2)
+	assert.Nil(t, err)
+	ok, err := VerifySMTProof(tree.RootHash(), 2, data[2], proof, emptyHash, h)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	err = restored.UpdateLeaf(2, emptyHash)
+	assert.Nil(t, err)
+}
+
+func TestSMTMarshalInvalidArgument(t *testing.T) {
+	h := md5.New()
+	tree := NewSMT(emptyHash, h)
+	_, err := tree.Marshal()
+	assert.Equal(t, "SMT tree is not filled", err.Error())
+	err = tree.Generate(createDummyTreeData(3, h.Size(), true), 4)
+	assert.Nil(t, err)
+	data, err := tree.Marshal()
+	assert.Nil(t, err)
+
+	_, err = UnmarshalSMT(nil, h)
+	assert.Equal(t, "SMT encoding is too short", err.Error())
+	_, err = UnmarshalSMT(data[:len(data)-1], h)
+	assert.Equal(t, "SMT encoding is too short", err.Error())
+	_, err = UnmarshalSMT(append(data, 0), h)
+	assert.Equal(t, "SMT encoding has trailing bytes", err.Error())
+	_, err = UnmarshalSMT(append([]byte{3}, data[1:]...), h)
+	assert.Equal(t, "unsupported SMT encoding version", err.Error())
+	invalid := append([]byte{}, data...)
+	invalid[8] = 2
+	_, err = UnmarshalSMT(invalid, h)
+	assert.Equal(t, "SMT encoding does not match its height", err.Error())
+	invalid = append([]byte{}, data...)
+	invalid[16] = 2
+	_, err = UnmarshalSMT(invalid, h)
+	assert.Equal(t, "invalid SMT encoding", err.Error())
+}
+
+func TestSMTUnmarshalMalformed(t *testing.T) {
+	h := md5.New()
+	tree := NewSMT(emptyHash, h)
+	err := tree.Generate(createDummyTreeData(3, h.Size(), true), 4)
+	assert.Nil(t, err)
+	// Marshal fills the hashes of the empty subtrees up to the root
+	valid, err := tree.Marshal()
+	assert.Nil(t, err)
+	assert.Len(t, tree.emptyTreeRootHash, 3)
+	assert.Equal(t, valid, encodeSMT(2, 3, 3, tree.emptyTreeRootHash, tree.fullNodes))
+	restored, err := UnmarshalSMT(valid, h)
+	assert.Nil(t, err)
+	assert.Equal(t, tree.RootHash(), restored.RootHash())
+
+	malformed := map[string][]byte{
+		"empty top level":          encodeSMT(2, 3, 3, tree.emptyTreeRootHash, [][]Hash{tree.fullNodes[0], tree.fullNodes[1], {}}),
+		"level wider than parents": encodeSMT(2, 3, 3, tree.emptyTreeRootHash, [][]Hash{tree.fullNodes[0], tree.fullNodes[0], tree.fullNodes[2]}),
+		"too few empty roots":      encodeSMT(2, 3, 3, tree.emptyTreeRootHash[:2], tree.fullNodes),
+		"count above total size":   encodeSMT(2, 2, 3, tree.emptyTreeRootHash, [][]Hash{tree.fullNodes[0], tree.fullNodes[1]}),
+		"negative count":           encodeSMT(2, 3, 1<<63, tree.emptyTreeRootHash, tree.fullNodes),
+		"total size overflows":     encodeSMT(1<<31, 4, 0, []Hash{emptyHash, emptyHash, emptyHash, emptyHash}, [][]Hash{{}, {}, {}, {}}),
+		"arity below 2":            encodeSMT(1, 3, 3, tree.emptyTreeRootHash, tree.fullNodes),
+	}
+	// the levels are not allocated before the sizes are checked
+	huge := []byte{smtSparseEncodingVersion, 0, 0, 0, 2, 0x7f, 0xff, 0xff, 0xff}
+	huge = append(huge, make([]byte, 8+4+4)...)
+	huge = append(huge, 0x7f, 0xff, 0xff, 0xff)
+	assert.Len(t, huge, 29)
+	malformed["huge sparse height"] = huge
+	for name, data := range malformed {
+		_, err = UnmarshalSMT(data, h)
+		assert.NotNil(t, err, name)
+		if err != nil {
+			assert.Equal(t, "invalid SMT encoding", err.Error(), name)
+		}
+	}
+}
+
+// Encodes the fields of a dense SMT as Marshal does, without checking them
+func encodeSMT(arity, height uint32, count uint64, emptyRoots []Hash, levels [][]Hash) []byte {
+	data := []byte{smtEncodingVersion}
+	data = binary.BigEndian.AppendUint32(data, arity)
+	data = binary.BigEndian.AppendUint32(data, height)
+	data = binary.BigEndian.AppendUint64(data, count)
+	data = appendEncodedHash(data, emptyRoots[0])
+	data = appendEncodedHashes(data, emptyRoots)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(levels)))
+	for _, level := range levels {
+		data = appendEncodedHashes(data, level)
+	}
+	return data
+}
@@ -0,0 +1,51 @@
+package merkle
+
+import (
+	"bytes"
+	"errors"
+)
+
+// RootsEquivalent reports whether tree and smt commit to the same root.
+//
+// The roots match exactly when both trees were built with the same hash
+// function over the same leaves, the tree has hash sorting disabled, the SMT
+// is binary and its totalSize equals the leaf count, which is then a power of
+// two. As soon as the SMT holds empty leaves the roots differ: the SMT hashes
+// a lone node with the root of an empty subtree, where the Tree promotes it
+// unchanged. CheckRootsEquivalent returns the reason of a mismatch.
+func RootsEquivalent(tree *Tree, smt *SMT) bool {
+	return CheckRootsEquivalent(tree, smt) == nil
+}
+
+// CheckRootsEquivalent is RootsEquivalent returning why the roots differ
+func CheckRootsEquivalent(tree *Tree, smt *SMT) error {
+	if tree.nodes == nil {
+		return errors.New("Tree is empty")
+	}
+	if len(smt.fullNodes) == 0 {
+		return errors.New("SMT tree is not filled")
+	}
+	if tree.enableHashSorting {
+		return errors.New("Tree sorts hashes, SMT does not")
+	}
+	if smt.arity != 2 {
+		return errors.New("SMT is not binary")
+	}
+
+	leaves := tree.levels[len(tree.levels)-1]
+	if smt.countOfNonEmptyLeaves != len(leaves) {
+		return errors.New("Trees have a different number of leaves")
+	}
+	for i, leaf := range leaves {
+		if !bytes.Equal(leaf.Hash, smt.node(0, i)) {
+			return errors.New("Trees have different leaves")
+		}
+	}
+	if uint64(1)<<uint(smt.treeHeight-1) != uint64(len(leaves)) {
+		return errors.New("SMT pads with empty leaves where Tree promotes lone nodes")
+	}
+	if !bytes.Equal(tree.RootHash(), smt.RootHash()) {
+		return errors.New("Root hashes differ, hash functions do not match")
+	}
+	return nil
+}
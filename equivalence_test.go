@@ -0,0 +1,66 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRootsEquivalent(t *testing.T) {
+	for _, count := range []int{1, 2, 4, 8, 16} {
+		tree := NewTree(hashFunc)
+		err := tree.Generate(testHashes[:count], 0)
+		assert.Nil(t, err)
+		smt := NewSMT(emptyHash, hashFunc)
+		err = smt.Generate(testHashes[:count], count)
+		assert.Nil(t, err)
+
+		assert.True(t, RootsEquivalent(tree, smt))
+		assert.Equal(t, tree.RootHash(), smt.RootHash())
+	}
+}
+
+func TestRootsNotEquivalent(t *testing.T) {
+	tree := NewTree(hashFunc)
+	err := tree.Generate(testHashes[:5], 0)
+	assert.Nil(t, err)
+	smt := NewSMT(emptyHash, hashFunc)
+	err = smt.Generate(testHashes[:5], 8)
+	assert.Nil(t, err)
+	assert.False(t, RootsEquivalent(tree, smt))
+	assert.NotEqual(t, tree.RootHash(), smt.RootHash())
+	assert.Equal(t, "SMT pads with empty leaves where Tree promotes lone nodes", CheckRootsEquivalent(tree, smt).Error())
+
+	smt = NewSMT(emptyHash, hashFunc)
+	err = smt.Generate(testHashes[:4], 8)
+	assert.Nil(t, err)
+	assert.Equal(t, "Trees have a different number of leaves", CheckRootsEquivalent(tree, smt).Error())
+
+	smt = NewSMT(emptyHash, hashFunc)
+	err = smt.Generate(testHashes[1:6], 8)
+	assert.Nil(t, err)
+	assert.Equal(t, "Trees have different leaves", CheckRootsEquivalent(tree, smt).Error())
+
+	tree = NewTree(sha256.New())
+	err = tree.Generate(testHashes[:4], 0)
+	assert.Nil(t, err)
+	smt = NewSMT(emptyHash, hashFunc)
+	err = smt.Generate(testHashes[:4], 4)
+	assert.Nil(t, err)
+	assert.Equal(t, "Root hashes differ, hash functions do not match", CheckRootsEquivalent(tree, smt).Error())
+
+	tree = NewTreeWithHashSortingEnable(hashFunc)
+	err = tree.Generate(testHashes[:4], 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "Tree sorts hashes, SMT does not", CheckRootsEquivalent(tree, smt).Error())
+
+	smt = NewSMTWithArity(4, hashFunc, emptyHash)
+	err = smt.Generate(testHashes[:4], 4)
+	assert.Nil(t, err)
+	tree = NewTree(hashFunc)
+	err = tree.Generate(testHashes[:4], 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "SMT is not binary", CheckRootsEquivalent(tree, smt).Error())
+	assert.Equal(t, "Tree is empty", CheckRootsEquivalent(NewTree(hashFunc), smt).Error())
+}
@@ -0,0 +1,181 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+// IAVLTree commits to sorted key/value pairs hashing its nodes as the IAVL
+// trees of the Cosmos SDK do, so its proofs can be checked by IAVL verifiers.
+// The shape of the tree is the one of Tree, every node of it is written with
+// the version given to the constructor.
+type IAVLTree struct {
+	version int64
+	root    *iavlNode
+	leaves  []*iavlNode
+}
+
+// IAVLProofNode is an inner node on the path of a leaf, as the ProofInnerNode
+// of IAVL. Left is empty when the path goes through the left child, Right
+// when it goes through the right one.
+type IAVLProofNode struct {
+	Height  int8
+	Size    int64
+	Version int64
+	Left    []byte
+	Right   []byte
+}
+
+type iavlNode struct {
+	hash   []byte
+	height int8
+	size   int64
+	left   *iavlNode
+	right  *iavlNode
+	parent *iavlNode
+}
+
+// NewIAVLCompatibleTree creates an empty tree whose nodes have the given
+// version
+func NewIAVLCompatibleTree(version int64) *IAVLTree {
+	return &IAVLTree{version: version}
+}
+
+// Generate builds the tree over the key/value pairs. Keys must be sorted in
+// increasing order without duplicates.
+func (self *IAVLTree) Generate(keys, values [][]byte) error {
+	if self.root != nil {
+		return errors.New("IAVL tree already filled")
+	}
+	if len(keys) == 0 {
+		return errors.New("Empty tree")
+	}
+	if len(keys) != len(values) {
+		return errors.New("keys and values have a different length")
+	}
+	for i := 1; i < len(keys); i++ {
+		if bytes.Compare(keys[i-1], keys[i]) >= 0 {
+			return errors.New("keys are not sorted")
+		}
+	}
+
+	leaves := make([]*iavlNode, len(keys))
+	for i := range keys {
+		leaves[i] = &iavlNode{hash: iavlLeafHash(keys[i], values[i], self.version), height: 0, size: 1}
+	}
+	level := leaves
+	for len(level) > 1 {
+		next := make([]*iavlNode, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			left, right := level[i], level[i+1]
+			node := &iavlNode{left: left, right: right, size: left.size + right.size, height: left.height + 1}
+			if right.height >= left.height {
+				node.height = right.height + 1
+			}
+			node.hash = iavlInnerHash(node.height, node.size, self.version, left.hash, right.hash)
+			left.parent = node
+			right.parent = node
+			next = append(next, node)
+		}
+		level = next
+	}
+	self.root = level[0]
+	self.leaves = leaves
+	return nil
+}
+
+// RootHash returns the IAVL root hash, nil if the tree is empty
+func (self *IAVLTree) RootHash() []byte {
+	if self.root == nil {
+		return nil
+	}
+	return self.root.hash
+}
+
+// GetIAVLProof returns the inner nodes on the path of the leaf at index,
+// ordered from the root down to the leaf as in the PathToLeaf of IAVL
+func (self *IAVLTree) GetIAVLProof(index uint) ([]IAVLProofNode, error) {
+	if self.root == nil {
+		return nil, errors.New("Tree is empty")
+	}
+	if index >= uint(len(self.leaves)) {
+		return nil, errors.New("node index is too big for node count")
+	}
+	path := []IAVLProofNode{}
+	for n := self.leaves[index]; n.parent != nil; n = n.parent {
+		p := n.parent
+		node := IAVLProofNode{Height: p.height, Size: p.size, Version: self.version}
+		if n == p.left {
+			node.Right = p.right.hash
+		} else {
+			node.Left = p.left.hash
+		}
+		path = append([]IAVLProofNode{node}, path...)
+	}
+	return path, nil
+}
+
+// VerifyIAVLProof hashes the key/value pair as an IAVL leaf of the given
+// version, folds the path from the last node up to the first one and
+// compares the result with root
+func VerifyIAVLProof(key, value []byte, version int64, path []IAVLProofNode, root []byte) (bool, error) {
+	hash := iavlLeafHash(key, value, version)
+	for i := len(path) - 1; i >= 0; i-- {
+		n := path[i]
+		if (len(n.Left) == 0) == (len(n.Right) == 0) {
+			return false, errors.New("IAVL proof node should have exactly one sibling")
+		}
+		if len(n.Left) == 0 {
+			hash = iavlInnerHash(n.Height, n.Size, n.Version, hash, n.Right)
+		} else {
+			hash = iavlInnerHash(n.Height, n.Size, n.Version, n.Left, hash)
+		}
+	}
+	return bytes.Equal(hash, root), nil
+}
+
+// Following are non public
+
+// Hashes a leaf as IAVL: height 0, size 1, version, key and hashed value
+func iavlLeafHash(key, value []byte, version int64) []byte {
+	valueHash := sha256.Sum256(value)
+	buf := new(bytes.Buffer)
+	iavlWriteVarint(buf, 0)
+	iavlWriteVarint(buf, 1)
+	iavlWriteVarint(buf, version)
+	iavlWriteBytes(buf, key)
+	iavlWriteBytes(buf, valueHash[:])
+	hash := sha256.Sum256(buf.Bytes())
+	return hash[:]
+}
+
+// Hashes an inner node as IAVL: height, size, version and both children
+func iavlInnerHash(height int8, size, version int64, left, right []byte) []byte {
+	buf := new(bytes.Buffer)
+	iavlWriteVarint(buf, int64(height))
+	iavlWriteVarint(buf, size)
+	iavlWriteVarint(buf, version)
+	iavlWriteBytes(buf, left)
+	iavlWriteBytes(buf, right)
+	hash := sha256.Sum256(buf.Bytes())
+	return hash[:]
+}
+
+// Writes a zigzag encoded varint, as amino does for signed integers
+func iavlWriteVarint(buf *bytes.Buffer, n int64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutVarint(b[:], n)])
+}
+
+// Writes bytes prefixed by their length as an unsigned varint
+func iavlWriteBytes(buf *bytes.Buffer, data []byte) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutUvarint(b[:], uint64(len(data)))])
+	buf.Write(data)
+}
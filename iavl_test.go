@@ -0,0 +1,73 @@
+package merkle
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIAVLTree(t *testing.T) {
+	keys := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	values := [][]byte{[]byte("1"), []byte("2"), []byte("3")}
+	tree := NewIAVLCompatibleTree(1)
+	err := tree.Generate(keys, values)
+	assert.Nil(t, err)
+
+	// IAVL hashes of a = 1, b = 2 and c = 3 written at version 1, where a and
+	// b have an inner node of height 1 and size 2, under the root of height 2
+	// and size 3
+	leafA := mustDecodeHex("bbe33cd0a785b97b9fb1f964aa71159dacd9e0ade84df7403dc0f9dc24818404")
+	nodeAB := mustDecodeHex("94b037ab65e50f94eb827902a873ee796cb04e3c9ad38c9860d84cbad668a9e7")
+	root := mustDecodeHex("d363e645a93aaefdd92856da6fbe92ba3d4401a680efe748a9cfc44012aefec2")
+	assert.Equal(t, root, tree.RootHash())
+
+	path, err := tree.GetIAVLProof(1)
+	assert.Nil(t, err)
+	assert.Equal(t, []IAVLProofNode{
+		{Height: 2, Size: 3, Version: 1, Right: tree.leaves[2].hash},
+		{Height: 1, Size: 2, Version: 1, Left: leafA},
+	}, path)
+	path, err = tree.GetIAVLProof(2)
+	assert.Nil(t, err)
+	assert.Equal(t, []IAVLProofNode{{Height: 2, Size: 3, Version: 1, Left: nodeAB}}, path)
+
+	for i := range keys {
+		path, err := tree.GetIAVLProof(uint(i))
+		assert.Nil(t, err)
+		ok, err := VerifyIAVLProof(keys[i], values[i], 1, path, root)
+		assert.Nil(t, err)
+		assert.True(t, ok, fmt.Sprintf("VerifyIAVLProof(%s)", keys[i]))
+
+		// another value, or another version, does not verify
+		ok, err = VerifyIAVLProof(keys[i], []byte("4"), 1, path, root)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+		ok, err = VerifyIAVLProof(keys[i], values[i], 2, path, root)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	}
+
+	_, err = VerifyIAVLProof(keys[0], values[0], 1, []IAVLProofNode{{Height: 1, Size: 2}}, root)
+	assert.Equal(t, "IAVL proof node should have exactly one sibling", err.Error())
+	_, err = tree.GetIAVLProof(3)
+	assert.Equal(t, "node index is too big for node count", err.Error())
+	err = tree.Generate(keys, values)
+	assert.Equal(t, "IAVL tree already filled", err.Error())
+}
+
+func TestIAVLTreeInvalidArgument(t *testing.T) {
+	tree := NewIAVLCompatibleTree(1)
+	_, err := tree.GetIAVLProof(0)
+	assert.Equal(t, "Tree is empty", err.Error())
+	assert.Nil(t, tree.RootHash())
+
+	err = tree.Generate(nil, nil)
+	assert.Equal(t, "Empty tree", err.Error())
+	err = tree.Generate([][]byte{[]byte("a")}, nil)
+	assert.Equal(t, "keys and values have a different length", err.Error())
+	err = tree.Generate([][]byte{[]byte("b"), []byte("a")}, [][]byte{{1}, {2}})
+	assert.Equal(t, "keys are not sorted", err.Error())
+	err = tree.Generate([][]byte{[]byte("a"), []byte("a")}, [][]byte{{1}, {2}})
+	assert.Equal(t, "keys are not sorted", err.Error())
+}
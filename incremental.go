@@ -0,0 +1,133 @@
+package merkle
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+)
+
+// IncrementalTree is an append-only tree keeping the frontier of its complete
+// subtrees, so the root is updated in O(log n) per appended leaf. Leaves are
+// kept to serve consistency proofs between checkpoints.
+type IncrementalTree struct {
+	leaves [][]byte
+	// frontier[i] is the root of the complete subtree of 2^i leaves, if the
+	// size of the tree has bit i set
+	frontier [][]byte
+	root     []byte
+	hashFunc hash.Hash
+}
+
+// Checkpoint captures the size and root of an IncrementalTree
+type Checkpoint struct {
+	Size uint64
+	Root []byte
+}
+
+// NewIncrementalTree creates an empty append-only tree. Leaves are expected
+// to be hashed already, as in Tree.
+func NewIncrementalTree(hashFunc hash.Hash) *IncrementalTree {
+	return &IncrementalTree{leaves: [][]byte{}, frontier: [][]byte{}, hashFunc: hashFunc}
+}
+
+// Append adds a leaf to the right of the tree
+func (it *IncrementalTree) Append(leaf []byte) error {
+	// Work on a copy so a failing hash leaves the tree untouched
+	frontier := make([][]byte, len(it.frontier), len(it.frontier)+1)
+	copy(frontier, it.frontier)
+
+	carry := leaf
+	size := uint64(len(it.leaves))
+	level := 0
+	for ; size&1 == 1; size >>= 1 {
+		node, err := NewNode(it.hashFunc, concatHashes(frontier[level], carry, false))
+		if err != nil {
+			return err
+		}
+		carry = node.Hash
+		frontier[level] = nil
+		level++
+	}
+	if level == len(frontier) {
+		frontier = append(frontier, nil)
+	}
+	frontier[level] = carry
+
+	// Fold the frontier from the smallest subtree up
+	var root []byte
+	for _, hash := range frontier {
+		if hash == nil {
+			continue
+		}
+		if root == nil {
+			root = hash
+			continue
+		}
+		node, err := NewNode(it.hashFunc, concatHashes(hash, root, false))
+		if err != nil {
+			return err
+		}
+		root = node.Hash
+	}
+	it.frontier = frontier
+	it.root = root
+	it.leaves = append(it.leaves, leaf)
+	return nil
+}
+
+// Size returns the number of appended leaves
+func (it *IncrementalTree) Size() uint64 {
+	return uint64(len(it.leaves))
+}
+
+// Root returns the root hash of the appended leaves, nil if there are none
+func (it *IncrementalTree) Root() []byte {
+	return it.root
+}
+
+// Checkpoint returns the current size and root of the tree
+func (it *IncrementalTree) Checkpoint() Checkpoint {
+	return Checkpoint{Size: it.Size(), Root: it.root}
+}
+
+// VerifyCheckpointConsistency checks that older was taken on this tree and
+// returns the RFC 9162 consistency proof from older to the current tree
+func (it *IncrementalTree) VerifyCheckpointConsistency(older Checkpoint) ([]ProofNode, error) {
+	if older.Size > it.Size() {
+		return nil, errors.New("checkpoint is bigger than the tree")
+	}
+	if older.Size > 0 {
+		root, err := it.subtreeHash(0, older.Size)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(root, older.Root) {
+			return nil, errors.New("checkpoint root does not match the tree")
+		}
+	}
+	return consistencyPath(older.Size, it.Size(), it.subtreeHash)
+}
+
+// Following are non public
+
+// Returns the hash of the leaves [start, end)
+func (it *IncrementalTree) subtreeHash(start, end uint64) ([]byte, error) {
+	n := end - start
+	if n == 1 {
+		return it.leaves[start], nil
+	}
+	k := nextPowerOfTwo(n) >> 1
+	left, err := it.subtreeHash(start, start+k)
+	if err != nil {
+		return nil, err
+	}
+	right, err := it.subtreeHash(start+k, end)
+	if err != nil {
+		return nil, err
+	}
+	node, err := NewNode(it.hashFunc, concatHashes(left, right, false))
+	if err != nil {
+		return nil, err
+	}
+	return node.Hash, nil
+}
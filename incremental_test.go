@@ -0,0 +1,165 @@
+package merkle
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIncrementalTreeRoot(t *testing.T) {
+	h := md5.New()
+	data := createDummyTreeData(17, h.Size(), true)
+	it := NewIncrementalTree(h)
+	assert.Nil(t, it.Root())
+	assert.Equal(t, uint64(0), it.Size())
+
+	for i, leaf := range data {
+		err := it.Append(leaf)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(i+1), it.Size())
+
+		tree := NewTree(h)
+		err = tree.Generate(data[:i+1], 0)
+		assert.Nil(t, err)
+		assert.Equal(t, tree.RootHash(), it.Root(), fmt.Sprintf("Root of %d leaves", i+1))
+	}
+}
+
+func TestIncrementalTreeAppendFailedHash(t *testing.T) {
+	hashCount := 0
+	it := NewIncrementalTree(NewHashCountErrorDecorator(md5.New(), &hashCount, 1))
+	err := it.Append(testHashes[0])
+	assert.Nil(t, err)
+	err = it.Append(testHashes[1])
+	assert.Equal(t, "Hash error", err.Error())
+	assert.Equal(t, uint64(1), it.Size())
+	assert.Equal(t, testHashes[0], it.Root())
+}
+
+func TestIncrementalTreeCheckpointConsistency(t *testing.T) {
+	h := md5.New()
+	data := createDummyTreeData(13, h.Size(), true)
+	it := NewIncrementalTree(h)
+	checkpoints := []Checkpoint{it.Checkpoint()}
+	for i, leaf := range data {
+		assert.Nil(t, it.Append(leaf))
+		if i == 0 || i == 2 || i == 3 || i == 6 || i == 7 || i == 12 {
+			checkpoints = append(checkpoints, it.Checkpoint())
+		}
+	}
+
+	for _, older := range checkpoints {
+		proof, err := it.VerifyCheckpointConsistency(older)
+		assert.Nil(t, err)
+		ok, err := VerifyConsistency(older, it.Checkpoint(), proof, h, TreeOptions{})
+		assert.Nil(t, err)
+		assert.True(t, ok, fmt.Sprintf("Consistency from %d to %d", older.Size, it.Size()))
+	}
+
+	// the proof only holds for the size it was generated for
+	proof, err := it.VerifyCheckpointConsistency(checkpoints[2])
+	assert.Nil(t, err)
+	ok, err := VerifyConsistency(checkpoints[2], checkpoints[4], proof, h, TreeOptions{})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestIncrementalTreeCheckpointMismatch(t *testing.T) {
+	h := md5.New()
+	data := createDummyTreeData(6, h.Size(), true)
+	it := NewIncrementalTree(h)
+	for _, leaf := range data {
+		assert.Nil(t, it.Append(leaf))
+	}
+
+	_, err := it.VerifyCheckpointConsistency(Checkpoint{Size: 7, Root: it.Root()})
+	assert.Equal(t, "checkpoint is bigger than the tree", err.Error())
+	_, err = it.VerifyCheckpointConsistency(Checkpoint{Size: 3, Root: it.Root()})
+	assert.Equal(t, "checkpoint root does not match the tree", err.Error())
+}
+
+func TestIncrementalTreeRFC9162Vectors(t *testing.T) {
+	h := PrefixHash{Hash: sha256.New(), Prefix: []byte{0x01}}
+	it := NewIncrementalTree(h)
+	checkpoints := map[uint64]Checkpoint{}
+	for _, leaf := range rfcLeafHashes() {
+		assert.Nil(t, it.Append(leaf))
+		checkpoints[it.Size()] = it.Checkpoint()
+	}
+	assert.Equal(t, mustDecodeHex("5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328"), it.Root())
+
+	inputs := []struct {
+		size uint64
+		path []string
+	}{
+		{1, []string{
+			"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+		}},
+		{4, []string{
+			"6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+		}},
+		{6, []string{
+			"0ebc5d3437fbe2db158b9f126a1d118e308181031d0a949f8dededebc558ef6a",
+			"ca854ea128ed050b41b35ffc1b87b8eb2bde461e9e3b5596ece6b9d5975a0ae0",
+			"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+		}},
+		{8, []string{}},
+	}
+	for _, in := range inputs {
+		proof, err := it.VerifyCheckpointConsistency(checkpoints[in.size])
+		assert.Nil(t, err)
+		assert.Equal(t, len(in.path), len(proof))
+		for i, p := range in.path {
+			assert.Equal(t, mustDecodeHex(p), proof[i].Hash)
+		}
+		ok, err := VerifyConsistency(checkpoints[in.size], it.Checkpoint(), proof, h, TreeOptions{})
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	}
+}
+
+func TestVerifyConsistencyRejects(t *testing.T) {
+	h := md5.New()
+	data := createDummyTreeData(7, h.Size(), true)
+	it := NewIncrementalTree(h)
+	for _, leaf := range data[:3] {
+		assert.Nil(t, it.Append(leaf))
+	}
+	older := it.Checkpoint()
+	for _, leaf := range data[3:] {
+		assert.Nil(t, it.Append(leaf))
+	}
+	newer := it.Checkpoint()
+	proof, err := it.VerifyCheckpointConsistency(older)
+	assert.Nil(t, err)
+
+	// tampered proof
+	tampered := append([]ProofNode{}, proof...)
+	tampered[0] = ProofNode{Hash: data[0]}
+	ok, err := VerifyConsistency(older, newer, tampered, h, TreeOptions{})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// truncated proof
+	ok, err = VerifyConsistency(older, newer, proof[:len(proof)-1], h, TreeOptions{})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// empty proof
+	ok, err = VerifyConsistency(older, newer, nil, h, TreeOptions{})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// wrong older root
+	ok, err = VerifyConsistency(Checkpoint{Size: older.Size, Root: data[0]}, newer, proof, h, TreeOptions{})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	_, err = VerifyConsistency(newer, older, proof, h, TreeOptions{})
+	assert.Equal(t, "older checkpoint is bigger than the newer one", err.Error())
+}
@@ -0,0 +1,160 @@
+package merkle
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+	"sort"
+)
+
+// GetKaryMerkleProof returns, for every level from the leaves up, the
+// siblings of the node on the path of the leaf, for trees of any arity. A
+// level where the node is promoted has no sibling.
+func (self *Tree) GetKaryMerkleProof(leafIndex uint) ([]KaryProofNode, error) {
+	leafCount := len(self.leaves())
+	if leafCount == 0 {
+		return nil, errors.New("Tree is empty")
+	}
+	if leafIndex >= uint(leafCount) {
+		return nil, errors.New("node index is too big for node count")
+	}
+
+	proofs := []KaryProofNode{}
+	index := int(leafIndex)
+	for level := self.height() - 1; level > 0; level-- {
+		siblings := [][]byte{}
+		for i, hash := range self.karyGroup(self.levels[level], index) {
+			if i != index%self.arity() {
+				siblings = append(siblings, hash)
+			}
+		}
+		proofs = append(proofs, KaryProofNode{Siblings: siblings})
+		index = index / self.arity()
+	}
+	return proofs, nil
+}
+
+// VerifyKaryProof checks a proof returned by GetKaryMerkleProof for the leaf
+// hash at index in a tree of treeSize leaves configured with opts. The number
+// of siblings at each level must match the tree size.
+func VerifyKaryProof(leafHash []byte, index uint, treeSize uint64, proof []KaryProofNode, root []byte, h hash.Hash, opts TreeOptions) (bool, error) {
+	tree := NewTreeWithOpts(h, opts)
+	if tree.arity() < 2 {
+		return false, errors.New("Arity of tree should be at least 2")
+	}
+	if uint64(index) >= treeSize {
+		return false, errors.New("node index is too big for node count")
+	}
+	arity := uint64(tree.arity())
+	height, _ := calculateKaryHeightAndNodeCount(treeSize, arity)
+	if uint64(len(proof)) != height-1 {
+		return false, nil
+	}
+
+	current := leafHash
+	position := uint64(index)
+	width := treeSize
+	for _, n := range proof {
+		first := position - position%arity
+		size := width - first
+		if size > arity {
+			size = arity
+		}
+		if opts.OddNodeStrategy != OddNodePromote {
+			size = arity
+		}
+		if uint64(len(n.Siblings))+1 != size {
+			return false, nil
+		}
+		offset := position % arity
+		children := make([][]byte, 0, size)
+		children = append(children, n.Siblings[:offset]...)
+		children = append(children, current)
+		children = append(children, n.Siblings[offset:]...)
+		node, err := tree.karyParent(children)
+		if err != nil {
+			return false, err
+		}
+		current = node.Hash
+		position = position / arity
+		width = (width + arity - 1) / arity
+	}
+	return bytes.Equal(current, root), nil
+}
+
+// Following are non public
+
+// Returns the arity of the tree
+func (self *Tree) arity() int {
+	if self.opts.Arity == 0 {
+		return 2
+	}
+	return self.opts.Arity
+}
+
+// Returns an error if the tree is not binary
+func (self *Tree) checkBinary() error {
+	if self.arity() != 2 {
+		return errors.New("Binary proofs need an arity of 2, use GetKaryMerkleProof")
+	}
+	return nil
+}
+
+// Returns the hashes of the children of the parent of the node at index of a
+// level, with the hashes OddNodeStrategy pairs a partial group with
+func (self *Tree) karyGroup(level []Node, index int) [][]byte {
+	first := index - index%self.arity()
+	hashes := [][]byte{}
+	for i := first; i < first+self.arity() && i < len(level); i++ {
+		hashes = append(hashes, level[i].Hash)
+	}
+	for len(hashes) < self.arity() {
+		sibling := self.oddSibling(hashes[len(hashes)-1])
+		if sibling == nil {
+			break
+		}
+		hashes = append(hashes, sibling)
+	}
+	return hashes
+}
+
+// Creates the nodes of the level above below in a tree of any arity, as
+// generateNodeLevel does for binary trees. The nodes are not linked to
+// their children. Returns the number of nodes added to current level
+func (self *Tree) generateKaryNodeLevel(below []Node, current []Node) (uint64, error) {
+	end := (len(below) + self.arity() - 1) / self.arity()
+	for i := 0; i < end; i++ {
+		node, err := self.karyParent(self.karyGroup(below, i*self.arity()))
+		if err != nil {
+			return 0, err
+		}
+		current[i] = node
+	}
+	return uint64(end), nil
+}
+
+// Returns the parent of the children hashes, a copy of a lone child
+func (self *Tree) karyParent(children [][]byte) (Node, error) {
+	if len(children) == 1 {
+		return Node{Hash: append([]byte(nil), children[0]...)}, nil
+	}
+	if self.enableHashSorting {
+		children = append([][]byte(nil), children...)
+		sort.Slice(children, func(i, j int) bool {
+			return bytes.Compare(children[i], children[j]) < 0
+		})
+	}
+	return NewNode(self.hashFunc, bytes.Join(children, nil))
+}
+
+// Returns the height and number of nodes of a tree of the given arity and
+// number of leaves
+func calculateKaryHeightAndNodeCount(leaves, arity uint64) (height, nodeCount uint64) {
+	for width := leaves; ; width = (width + arity - 1) / arity {
+		height++
+		nodeCount += width
+		if width <= 1 {
+			return
+		}
+	}
+}
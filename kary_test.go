@@ -0,0 +1,104 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateKary(t *testing.T) {
+	h := md5.New()
+	data := createDummyTreeData(6, h.Size(), true)
+	tree := NewTreeWithOpts(h, TreeOptions{DisableHashLeaves: true, Arity: 4})
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), tree.Height())
+
+	// the last group of two leaves is hashed as it is
+	left, err := NewNode(h, bytes.Join(data[:4], nil))
+	assert.Nil(t, err)
+	right, err := NewNode(h, bytes.Join(data[4:], nil))
+	assert.Nil(t, err)
+	root, err := NewNode(h, append(left.Hash, right.Hash...))
+	assert.Nil(t, err)
+	assert.Equal(t, root.Hash, tree.RootHash())
+
+	// a binary arity gives the binary tree
+	binary := NewTreeWithOpts(h, TreeOptions{DisableHashLeaves: true, Arity: 2})
+	err = binary.Generate(data, 0)
+	assert.Nil(t, err)
+	expected := NewTree(h)
+	err = expected.Generate(data, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, expected.RootHash(), binary.RootHash())
+
+	err = NewTreeWithOpts(h, TreeOptions{Arity: 1}).Generate(data, 0)
+	assert.Equal(t, "Arity of tree should be at least 2", err.Error())
+	_, err = tree.GetMerkleProof(0)
+	assert.Equal(t, "Binary proofs need an arity of 2, use GetKaryMerkleProof", err.Error())
+	err = tree.Append(data[:1])
+	assert.Equal(t, "Binary proofs need an arity of 2, use GetKaryMerkleProof", err.Error())
+}
+
+func TestKaryMerkleProof(t *testing.T) {
+	h := md5.New()
+	for _, arity := range []int{2, 3, 4, 16} {
+		for _, strategy := range []OddNodeStrategy{OddNodePromote, OddNodeDuplicate, OddNodeZeroHash} {
+			for _, sorting := range []bool{false, true} {
+				opts := TreeOptions{DisableHashLeaves: true, Arity: arity, OddNodeStrategy: strategy, EnableHashSorting: sorting}
+				for _, count := range []int{1, 2, 5, 16, 17, 33} {
+					data := createDummyTreeData(count, h.Size(), true)
+					tree := NewTreeWithOpts(h, opts)
+					err := tree.Generate(data, 0)
+					assert.Nil(t, err)
+
+					for i := range data {
+						proof, err := tree.GetKaryMerkleProof(uint(i))
+						assert.Nil(t, err)
+						assert.Len(t, proof, int(tree.Height()-1))
+						ok, err := VerifyKaryProof(data[i], uint(i), uint64(count), proof, tree.RootHash(), h, opts)
+						assert.Nil(t, err)
+						assert.True(t, ok, fmt.Sprintf("VerifyKaryProof(%d) of %d with %+v", i, count, opts))
+						if count > 1 {
+							ok, err = VerifyKaryProof(data[(i+1)%count], uint(i), uint64(count), proof, tree.RootHash(), h, opts)
+							assert.Nil(t, err)
+							assert.False(t, ok)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestKaryMerkleProofInvalidArgument(t *testing.T) {
+	h := md5.New()
+	tree := NewTreeWithOpts(h, TreeOptions{DisableHashLeaves: true, Arity: 4})
+	_, err := tree.GetKaryMerkleProof(0)
+	assert.Equal(t, "Tree is empty", err.Error())
+	data := createDummyTreeData(6, h.Size(), true)
+	err = tree.Generate(data, 0)
+	assert.Nil(t, err)
+	_, err = tree.GetKaryMerkleProof(6)
+	assert.Equal(t, "node index is too big for node count", err.Error())
+
+	opts := TreeOptions{DisableHashLeaves: true, Arity: 4}
+	proof, err := tree.GetKaryMerkleProof(5)
+	assert.Nil(t, err)
+	// the leaf is in a group of 2 leaves, then of 2 subtrees
+	assert.Len(t, proof[0].Siblings, 1)
+	assert.Len(t, proof[1].Siblings, 1)
+	ok, err := VerifyKaryProof(data[5], 5, 7, proof, tree.RootHash(), h, opts)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = VerifyKaryProof(data[5], 5, 6, proof[:1], tree.RootHash(), h, opts)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	_, err = VerifyKaryProof(data[5], 6, 6, proof, tree.RootHash(), h, opts)
+	assert.Equal(t, "node index is too big for node count", err.Error())
+	_, err = VerifyKaryProof(data[5], 5, 6, proof, tree.RootHash(), h, TreeOptions{Arity: -1})
+	assert.Equal(t, "Arity of tree should be at least 2", err.Error())
+}
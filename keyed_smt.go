@@ -0,0 +1,150 @@
+package merkle
+
+import (
+	"errors"
+	"hash"
+)
+
+// KeyedSMT is a binary sparse Merkle tree addressed by arbitrary keys. The
+// path of a key is the bits of its hash, most significant first, so the tree
+// has 256 levels below the root with SHA-256. Empty subtrees are never
+// stored, their hashes are cached per height like in SMT.
+type KeyedSMT struct {
+	// Hashes of the non-empty nodes, keyed by their bit path
+	nodes             map[string]Hash
+	hashFunc          hash.Hash
+	emptyHash         Hash
+	emptyTreeRootHash []Hash
+	depth             int
+}
+
+// NewKeyedSMT creates a tree whose empty leaves hash to emptyHash
+func NewKeyedSMT(emptyHash Hash, hashFunc hash.Hash) (*KeyedSMT, error) {
+	depth := 8 * hashFunc.Size()
+	if depth == 0 {
+		return nil, errors.New("Hash size should be positive")
+	}
+	emptyTreeRootHash := []Hash{emptyHash}
+	for i := 0; i < depth; i++ {
+		hash, err := hashConcat(hashFunc, emptyTreeRootHash[i], emptyTreeRootHash[i])
+		if err != nil {
+			return nil, err
+		}
+		emptyTreeRootHash = append(emptyTreeRootHash, hash)
+	}
+	return &KeyedSMT{nodes: map[string]Hash{}, hashFunc: hashFunc, emptyHash: emptyHash, emptyTreeRootHash: emptyTreeRootHash, depth: depth}, nil
+}
+
+// Get returns the leaf stored under key, and false if key was never updated
+func (self *KeyedSMT) Get(key []byte) ([]byte, bool, error) {
+	path, err := keyToBits(key, self.hashFunc)
+	if err != nil {
+		return nil, false, err
+	}
+	leaf, ok := self.nodes[string(path)]
+	return leaf, ok, nil
+}
+
+// Update stores leaf under key and updates the nodes on its path. On error the
+// tree is left unchanged.
+func (self *KeyedSMT) Update(key, leaf []byte) error {
+	if leaf == nil {
+		return errors.New("leaves should not be nil")
+	}
+	path, err := keyToBits(key, self.hashFunc)
+	if err != nil {
+		return err
+	}
+	hashes := make([]Hash, len(path)+1)
+	hashes[len(path)] = leaf
+	for depth := len(path) - 1; depth >= 0; depth-- {
+		sibling := self.nodeHash(siblingPath(path[:depth+1]))
+		children := [][]byte{hashes[depth+1], sibling}
+		if path[depth] == 1 {
+			children = [][]byte{sibling, hashes[depth+1]}
+		}
+		hash, err := hashConcat(self.hashFunc, children...)
+		if err != nil {
+			return err
+		}
+		hashes[depth] = hash
+	}
+	for depth, hash := range hashes {
+		self.nodes[string(path[:depth])] = hash
+	}
+	return nil
+}
+
+// RootHash returns the root hash of the tree
+func (self *KeyedSMT) RootHash() []byte {
+	return self.nodeHash([]byte{})
+}
+
+// Prove returns the siblings on the path of key, from the leaf up to the root.
+// Keys that were never updated are proven against the empty leaf hash.
+func (self *KeyedSMT) Prove(key []byte) ([]ProofNode, error) {
+	path, err := keyToBits(key, self.hashFunc)
+	if err != nil {
+		return nil, err
+	}
+	proof := make([]ProofNode, 0, len(path))
+	for depth := len(path) - 1; depth >= 0; depth-- {
+		sibling := self.nodeHash(siblingPath(path[:depth+1]))
+		proof = append(proof, ProofNode{Left: path[depth] == 1, Hash: sibling})
+	}
+	return proof, nil
+}
+
+// VerifyKeyedProof checks that leaf is stored under key in the tree with the
+// given root. Non-membership is proven by passing the empty leaf hash. A
+// proof of the wrong length does not verify.
+func VerifyKeyedProof(key, leaf []byte, proof []ProofNode, root []byte, hashFunc hash.Hash) (bool, error) {
+	path, err := keyToBits(key, hashFunc)
+	if err != nil {
+		return false, err
+	}
+	if len(proof) != len(path) {
+		return false, nil
+	}
+	for i, node := range proof {
+		if node.Left != (path[len(path)-1-i] == 1) {
+			return false, nil
+		}
+	}
+	return VerifyProof(leaf, proof, root, hashFunc, TreeOptions{})
+}
+
+// Following are non public function
+
+// Returns the hash of the node at path, falling back to the cached empty
+// subtree hash of its height
+func (self *KeyedSMT) nodeHash(path []byte) []byte {
+	if hash, ok := self.nodes[string(path)]; ok {
+		return hash
+	}
+	return self.emptyTreeRootHash[self.depth-len(path)]
+}
+
+// Returns the path of the sibling of the node at path
+func siblingPath(path []byte) []byte {
+	sibling := make([]byte, len(path))
+	copy(sibling, path)
+	sibling[len(path)-1] ^= 1
+	return sibling
+}
+
+// Hashes key and splits the hash into its bits, most significant first
+func keyToBits(key []byte, hashFunc hash.Hash) ([]byte, error) {
+	defer hashFunc.Reset()
+	_, err := hashFunc.Write(key)
+	if err != nil {
+		return nil, err
+	}
+	bits := []byte{}
+	for _, b := range hashFunc.Sum(nil) {
+		for i := 7; i >= 0; i-- {
+			bits = append(bits, (b>>uint(i))&1)
+		}
+	}
+	return bits, nil
+}
@@ -0,0 +1,108 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyToBits(t *testing.T) {
+	h := sha256.New()
+	bits, err := keyToBits([]byte("abc"), h)
+	assert.Nil(t, err)
+	assert.Len(t, bits, 256)
+	// SHA-256("abc") starts with 0xba
+	assert.Equal(t, []byte{1, 0, 1, 1, 1, 0, 1, 0}, bits[:8])
+}
+
+func TestKeyedSMTEmptyRoot(t *testing.T) {
+	h := sha256.New()
+	tree, err := NewKeyedSMT(emptyHash, h)
+	assert.Nil(t, err)
+	assert.Len(t, tree.emptyTreeRootHash, 257)
+	assert.Equal(t, []byte(tree.emptyTreeRootHash[256]), tree.RootHash())
+
+	proof, err := tree.Prove([]byte("missing"))
+	assert.Nil(t, err)
+	assert.Len(t, proof, 256)
+	ok, err := VerifyKeyedProof([]byte("missing"), emptyHash, proof, tree.RootHash(), h)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func TestKeyedSMT(t *testing.T) {
+	h := sha256.New()
+	tree, err := NewKeyedSMT(emptyHash, h)
+	assert.Nil(t, err)
+	reversed, err := NewKeyedSMT(emptyHash, h)
+	assert.Nil(t, err)
+
+	keys := [][]byte{}
+	for i := 0; i < 20; i++ {
+		keys = append(keys, []byte(fmt.Sprintf("key %d", i)))
+	}
+	for i, key := range keys {
+		err = tree.Update(key, hashValue(key, h))
+		assert.Nil(t, err)
+		err = reversed.Update(keys[len(keys)-1-i], hashValue(keys[len(keys)-1-i], h))
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, tree.RootHash(), reversed.RootHash())
+	assert.NotEqual(t, []byte(tree.emptyTreeRootHash[256]), tree.RootHash())
+
+	for _, key := range keys {
+		leaf, ok, err := tree.Get(key)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, hashValue(key, h), leaf)
+
+		proof, err := tree.Prove(key)
+		assert.Nil(t, err)
+		ok, err = VerifyKeyedProof(key, leaf, proof, tree.RootHash(), h)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		ok, err = VerifyKeyedProof(key, emptyHash, proof, tree.RootHash(), h)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+		ok, err = VerifyKeyedProof([]byte("other"), leaf, proof, tree.RootHash(), h)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	}
+
+	_, ok, err := tree.Get([]byte("missing"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	proof, err := tree.Prove([]byte("missing"))
+	assert.Nil(t, err)
+	ok, err = VerifyKeyedProof([]byte("missing"), emptyHash, proof, tree.RootHash(), h)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// Overwriting a leaf
+	root := tree.RootHash()
+	err = tree.Update(keys[0], emptyHash[:8])
+	assert.Nil(t, err)
+	assert.NotEqual(t, root, tree.RootHash())
+	err = tree.Update(keys[0], hashValue(keys[0], h))
+	assert.Nil(t, err)
+	assert.Equal(t, root, tree.RootHash())
+}
+
+func TestKeyedSMTInvalidArgument(t *testing.T) {
+	_, err := NewKeyedSMT(emptyHash, NewFailingHash())
+	assert.Equal(t, "Hash size should be positive", err.Error())
+
+	h := sha256.New()
+	tree, err := NewKeyedSMT(emptyHash, h)
+	assert.Nil(t, err)
+	err = tree.Update([]byte("key"), nil)
+	assert.Equal(t, "leaves should not be nil", err.Error())
+
+	proof, err := tree.Prove([]byte("key"))
+	assert.Nil(t, err)
+	ok, err := VerifyKeyedProof([]byte("key"), emptyHash, proof[1:], tree.RootHash(), h)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,69 @@
+package merkle
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+)
+
+// ExportKV returns every node hash of the tree keyed by "level:index", where
+// level 0 holds the root, so the tree can be stored in a key-value store
+func (self *Tree) ExportKV() map[string][]byte {
+	m := map[string][]byte{}
+	for level, nodes := range self.levels {
+		for index, n := range nodes {
+			hash := make([]byte, len(n.Hash))
+			copy(hash, n.Hash)
+			m[kvKey(level, index)] = hash
+		}
+	}
+	return m
+}
+
+// ImportKV rebuilds a tree of leafCount leaves from the map produced by
+// ExportKV. The imported tree serves roots and proofs of a tree which
+// promotes lone nodes. It has no hash function, so the methods which hash new
+// nodes return an error, use ImportKVWithOpts to keep changing the tree.
+func ImportKV(m map[string][]byte, leafCount uint64) (*Tree, error) {
+	return ImportKVWithOpts(m, leafCount, nil, TreeOptions{})
+}
+
+// ImportKVWithOpts rebuilds a tree of leafCount leaves from the map produced
+// by ExportKV, which hashes with hashFunc and is configured by opts as the
+// exported tree was
+func ImportKVWithOpts(m map[string][]byte, leafCount uint64, hashFunc hash.Hash, opts TreeOptions) (*Tree, error) {
+	if leafCount == 0 {
+		return nil, errors.New("Empty tree")
+	}
+	height, nodeCount := calculateHeightAndNodeCount(leafCount)
+	if uint64(len(m)) != nodeCount {
+		return nil, errors.New("node count does not match the leaf count")
+	}
+
+	nodes := make([]Node, 0, nodeCount)
+	width := leafCount
+	for level := int(height) - 1; level >= 0; level-- {
+		for index := 0; index < int(width); index++ {
+			hash, ok := m[kvKey(level, index)]
+			if !ok {
+				return nil, fmt.Errorf("missing node %s", kvKey(level, index))
+			}
+			nodes = append(nodes, Node{Hash: hash})
+		}
+		width = (width + width%2) / 2
+	}
+
+	tree := NewTreeWithOpts(hashFunc, opts)
+	tree.nodes = nodes
+	err := tree.RebuildLevels(leafCount)
+	if err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// Following are non public
+
+func kvKey(level, index int) string {
+	return fmt.Sprintf("%d:%d", level, index)
+}
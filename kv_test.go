@@ -0,0 +1,86 @@
+package merkle
+
+import (
+	"crypto/md5"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportImportKV(t *testing.T) {
+	h := md5.New()
+	for _, count := range []int{1, 2, 7, 16} {
+		tree := NewTree(h)
+		err := tree.Generate(createDummyTreeData(count, h.Size(), true), 0)
+		assert.Nil(t, err)
+
+		m := tree.ExportKV()
+		_, nodeCount := calculateHeightAndNodeCount(uint64(count))
+		assert.Len(t, m, int(nodeCount))
+		assert.Equal(t, tree.RootHash(), m["0:0"])
+
+		imported, err := ImportKV(m, uint64(count))
+		assert.Nil(t, err)
+		assert.Equal(t, tree.RootHash(), imported.RootHash())
+		for i := 0; i < count; i++ {
+			expected, err := tree.GetMerkleProof(uint(i))
+			assert.Nil(t, err)
+			proof, err := imported.GetMerkleProof(uint(i))
+			assert.Nil(t, err)
+			assert.Equal(t, expected, proof)
+		}
+	}
+}
+
+func TestImportKVWithOpts(t *testing.T) {
+	h := md5.New()
+	data := createDummyTreeData(3, h.Size(), true)
+	opts := TreeOptions{DisableHashLeaves: true, OddNodeStrategy: OddNodeDuplicate}
+	tree := NewTreeWithOpts(h, opts)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+
+	imported, err := ImportKVWithOpts(tree.ExportKV(), 3, h, opts)
+	assert.Nil(t, err)
+	for i := range data {
+		expected, err := tree.GetMerkleProof(uint(i))
+		assert.Nil(t, err)
+		proof, err := imported.GetMerkleProof(uint(i))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, proof)
+	}
+	err = imported.Update(1, data[0])
+	assert.Nil(t, err)
+	err = tree.Update(1, data[0])
+	assert.Nil(t, err)
+	assert.Equal(t, tree.RootHash(), imported.RootHash())
+
+	// without hash function the imported tree can not change
+	plain, err := ImportKV(tree.ExportKV(), 3)
+	assert.Nil(t, err)
+	err = plain.Append(data)
+	assert.Equal(t, "tree has no hash function", err.Error())
+	err = plain.Update(0, data[1])
+	assert.Equal(t, "tree has no hash function", err.Error())
+	err = plain.Verify()
+	assert.Equal(t, "tree has no hash function", err.Error())
+	assert.Equal(t, tree.RootHash(), plain.RootHash())
+}
+
+func TestImportKVInvalidArgument(t *testing.T) {
+	h := md5.New()
+	tree := NewTree(h)
+	err := tree.Generate(createDummyTreeData(5, h.Size(), true), 0)
+	assert.Nil(t, err)
+	m := tree.ExportKV()
+
+	_, err = ImportKV(m, 0)
+	assert.Equal(t, "Empty tree", err.Error())
+	_, err = ImportKV(m, 6)
+	assert.Equal(t, "node count does not match the leaf count", err.Error())
+
+	m["9:9"] = m["3:4"]
+	delete(m, "3:4")
+	_, err = ImportKV(m, 5)
+	assert.Equal(t, "missing node 3:4", err.Error())
+}
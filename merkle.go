@@ -8,8 +8,14 @@ package merkle
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"hash"
+	"io"
+	"runtime"
+	"sort"
+	"sync"
 )
 
 // Node in the merkle tree
@@ -45,14 +51,118 @@ type Tree struct {
 
 	enableHashSorting bool
 	hashFunc          hash.Hash
+	leafHash          hash.Hash
+	opts              TreeOptions
+	hashOrder         []HashPosition
+	accumulator       []byte
+	leafIndex         map[string]uint
+	// Number of leaves made from blocks, the leaves after them are padding
+	blockCount int
+}
+
+// TreeOptions configures the hashing behaviour of a Tree
+type TreeOptions struct {
+	// EnableHashSorting sorts each pair of child hashes before concatenating
+	// them to calculate the parent hash
+	EnableHashSorting bool
+
+	// ForbiddenRoots makes Generate fail if the computed root hash equals one
+	// of these values
+	ForbiddenRoots [][]byte
+
+	// DeduplicateSubtrees makes identical nodes, such as the roots of
+	// identical subtrees, share a single copy of their hash as soon as their
+	// level is generated
+	DeduplicateSubtrees bool
+
+	// RecordHashOrder makes Generate log the position of every internal node
+	// in the order it was computed, see HashOrder
+	RecordHashOrder bool
+
+	// LeafAccumulator is folded over the leaf hashes in order during
+	// Generate, starting from a nil accumulator, see Accumulator
+	LeafAccumulator func(acc, leafHash []byte) []byte
+
+	// MetricsSink receives events during Generate: "leaves_hashed" with the
+	// number of leaves, "level_completed" with the level, where level 0 holds
+	// the root, after each level is built, and "hash_ops" with the number of
+	// hashes computed once the tree is built. Generate is sequential, the sink
+	// is called from the goroutine calling Generate.
+	MetricsSink func(event string, value int64)
+
+	// IndexLeafHashes makes Generate map each leaf hash to its index, see
+	// GetMerkleProofByHash
+	IndexLeafHashes bool
+
+	// DisableHashLeaves makes Generate store the leaf blocks as they are,
+	// for blocks which are already hashes. Otherwise each block is hashed
+	// into its leaf. NewTree and NewTreeWithHashSortingEnable set it.
+	DisableHashLeaves bool
+
+	// PadToPowerOfTwo makes Generate add PaddingLeaf leaves after the blocks
+	// up to the next power of two, so every leaf has a proof of the same
+	// length. Padded trees do not support Append.
+	PadToPowerOfTwo bool
+
+	// PaddingLeaf is the leaf hash added by PadToPowerOfTwo and up to the
+	// totalLeavesSize of Generate, the zero hash of the size of the hash
+	// function when nil
+	PaddingLeaf []byte
+
+	// OddNodeStrategy sets how the last node of a level with an odd number
+	// of nodes gets its parent. The proofs of GetMerkleProof, GetProof,
+	// PartialProof and CheckpointProof hold the sibling it is paired with,
+	// as VerifyProof, VerifyInclusion and Proof.Verify expect. Other proofs
+	// return an error unless it is OddNodePromote.
+	OddNodeStrategy OddNodeStrategy
+
+	// Arity is the number of children of the internal nodes, 2 when zero.
+	// Parents of a partial group of children hash the children present, a
+	// lone child being handled by OddNodeStrategy. Trees of a higher arity
+	// are proven by GetKaryMerkleProof, the binary proofs, Append and
+	// RebuildLevels return an error for them.
+	Arity int
+}
+
+// OddNodeStrategy is the handling of a node without a sibling
+type OddNodeStrategy int
+
+const (
+	// OddNodePromote carries the node up unchanged as its parent
+	OddNodePromote OddNodeStrategy = iota
+	// OddNodeDuplicate pairs the node with itself, as Bitcoin does
+	OddNodeDuplicate
+	// OddNodeZeroHash pairs the node with a zero hash of the same size
+	OddNodeZeroHash
+)
+
+// HashPosition locates a node by its level, where level 0 holds the root,
+// and its index within the level
+type HashPosition struct {
+	Level uint64
+	Index int
+}
+
+// NewTreeWithOpts creates a tree configured by opts
+func NewTreeWithOpts(hashFunc hash.Hash, opts TreeOptions) *Tree {
+	return &Tree{nodes: nil, levels: nil, enableHashSorting: opts.EnableHashSorting, hashFunc: hashFunc, opts: opts}
+}
+
+// NewTreeWithTwoHashFuncs creates a tree hashing the leaf blocks with
+// leafHash and the internal nodes with internalHash, for domain separated
+// constructions
+func NewTreeWithTwoHashFuncs(leafHash, internalHash hash.Hash) *Tree {
+	tree := NewTreeWithOpts(internalHash, TreeOptions{})
+	tree.leafHash = leafHash
+	return tree
 }
 
 func NewTreeWithHashSortingEnable(hashFunc hash.Hash) *Tree {
-	return &Tree{nodes: nil, levels: nil, enableHashSorting: true, hashFunc: hashFunc}
+	return NewTreeWithOpts(hashFunc, TreeOptions{EnableHashSorting: true, DisableHashLeaves: true})
 }
 
 func NewTree(hashFunc hash.Hash) *Tree {
-	return &Tree{nodes: nil, levels: nil, hashFunc: hashFunc}
+	return NewTreeWithOpts(hashFunc, TreeOptions{DisableHashLeaves: true})
 }
 
 func (self *Tree) RootHash() []byte {
@@ -63,48 +173,197 @@ func (self *Tree) RootHash() []byte {
 	}
 }
 
-// Generates the tree nodes by using different hash funtions between internal and leaf node
+// Generates the tree nodes by using different hash funtions between internal and leaf node.
+// A positive totalLeavesSize is the number of leaves of the tree, the blocks
+// being followed by PaddingLeaf leaves, a zero totalLeavesSize gives one leaf
+// per block. With PadToPowerOfTwo a positive totalLeavesSize should be a
+// power of two. Padded trees do not support Append.
 func (self *Tree) Generate(blocks [][]byte, totalLeavesSize int) error {
-	return self.generate(blocks)
+	if totalLeavesSize < 0 {
+		return errors.New("totalLeavesSize should not be negative")
+	}
+	if totalLeavesSize > 0 && len(blocks) > totalLeavesSize {
+		return errors.New("Leaves number is bigger than totalLeavesSize")
+	}
+	if totalLeavesSize > 0 && self.opts.PadToPowerOfTwo && !isPowerOfTwo(uint64(totalLeavesSize)) {
+		return errors.New("totalLeavesSize should be a power of two with PadToPowerOfTwo")
+	}
+	return self.generateLeaves(blocks, self.leafHasher(), uint64(totalLeavesSize))
 }
 func (self *Tree) generate(blocks [][]byte) error {
+	return self.generateLeaves(blocks, self.leafHasher(), 0)
+}
+
+// Generates the tree from the blocks, hashed into the leaves by leafHash if
+// it is not nil, and padded up to totalLeaves leaves
+func (self *Tree) generateLeaves(blocks [][]byte, leafHash hash.Hash, totalLeaves uint64) error {
 	blockCount := uint64(len(blocks))
 	if blockCount == 0 {
 		return errors.New("Empty tree")
 	}
-	height, nodeCount := calculateHeightAndNodeCount(blockCount)
+	err := self.checkHashFunc()
+	if err != nil {
+		return err
+	}
+	if self.arity() < 2 {
+		return errors.New("Arity of tree should be at least 2")
+	}
+	leafCount := blockCount
+	if totalLeaves > leafCount {
+		leafCount = totalLeaves
+	}
+	if self.opts.PadToPowerOfTwo {
+		leafCount = nextPowerOfTwo(leafCount)
+	}
+	height, nodeCount := calculateHeightAndNodeCount(leafCount)
+	if self.arity() != 2 {
+		height, nodeCount = calculateKaryHeightAndNodeCount(leafCount, uint64(self.arity()))
+	}
 	levels := make([][]Node, height)
 	nodes := make([]Node, nodeCount)
 
 	// Create the leaf nodes
 	for i, block := range blocks {
-		node, err := NewNode(nil, block)
+		node, err := NewNode(leafHash, block)
 		if err != nil {
 			return err
 		}
 		nodes[i] = node
 	}
-	levels[height-1] = nodes[:len(blocks)]
+	for i := blockCount; i < leafCount; i++ {
+		nodes[i] = Node{Hash: self.paddingLeaf()}
+	}
+	levels[height-1] = nodes[:leafCount]
+	self.emitMetric("leaves_hashed", int64(len(blocks)))
+	// Identical nodes share their hash as soon as their level is generated
+	pool := self.newHashPool()
+	dedupNodes(pool, levels[height-1], true)
+
+	var accumulator []byte
+	if self.opts.LeafAccumulator != nil {
+		for _, leaf := range levels[height-1][:len(blocks)] {
+			accumulator = self.opts.LeafAccumulator(accumulator, leaf.Hash)
+		}
+	}
 
 	// Create each node level
-	current := nodes[len(blocks):]
+	var hashOrder []HashPosition
+	hashOps := int64(0)
+	current := nodes[leafCount:]
 	h := height - 1
 	for ; h > 0; h-- {
 		below := levels[h]
-		wrote, err := self.generateNodeLevel(below, current)
+		generateLevel := self.generateNodeLevel
+		if self.arity() != 2 {
+			generateLevel = self.generateKaryNodeLevel
+		}
+		wrote, err := generateLevel(below, current)
 		if err != nil {
 			return err
 		}
+		dedupNodes(pool, current[:wrote], false)
+		hashOps += self.hashOpsOf(len(below))
+		self.emitMetric("level_completed", int64(h-1))
+		if self.opts.RecordHashOrder {
+			for i := 0; i < int(wrote); i++ {
+				hashOrder = append(hashOrder, HashPosition{Level: h - 1, Index: i})
+			}
+		}
 		levels[h-1] = current[:wrote]
 		current = current[wrote:]
 	}
 
+	self.emitMetric("hash_ops", hashOps)
+
+	var leafIndex map[string]uint
+	if self.opts.IndexLeafHashes {
+		leafIndex = make(map[string]uint, len(blocks))
+		// Duplicated leaves map to their first occurrence
+		for i := len(blocks) - 1; i >= 0; i-- {
+			leafIndex[string(levels[height-1][i].Hash)] = uint(i)
+		}
+	}
+
+	err = self.checkRoot(levels[0][0].Hash)
+	if err != nil {
+		return err
+	}
+
 	self.nodes = nodes
 	self.levels = levels
+	self.hashOrder = hashOrder
+	self.accumulator = accumulator
+	self.leafIndex = leafIndex
+	self.blockCount = len(blocks)
 	return nil
 }
 
+// GenerateFromReader generates the tree whose leaves are the hashes of the
+// successive chunks of chunkSize bytes read from r, the last one being
+// possibly shorter. Chunks are hashed whatever DisableHashLeaves is, so only
+// their hashes are kept in memory.
+func (self *Tree) GenerateFromReader(r io.Reader, chunkSize int) error {
+	if chunkSize <= 0 {
+		return errors.New("chunk size should be positive")
+	}
+	leafHash := self.leafHash
+	if leafHash == nil {
+		leafHash = self.hashFunc
+	}
+	hashes := [][]byte{}
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		leaf, hashErr := NewNode(leafHash, buf[:n])
+		if hashErr != nil {
+			return hashErr
+		}
+		hashes = append(hashes, leaf.Hash)
+		if err == io.ErrUnexpectedEOF {
+			break
+		}
+	}
+	return self.generateLeaves(hashes, nil, 0)
+}
+
+// GetMerkleProofByHash returns the proof of the first leaf with the given
+// hash. The tree must be created with IndexLeafHashes.
+func (self *Tree) GetMerkleProofByHash(leafHash []byte) ([]ProofNode, error) {
+	if self.leafIndex == nil {
+		return nil, errors.New("leaf hashes are not indexed")
+	}
+	index, ok := self.leafIndex[string(leafHash)]
+	if !ok {
+		return nil, errors.New("leaf hash is not in the tree")
+	}
+	return self.GetMerkleProof(index)
+}
+
+// Accumulator returns the value of TreeOptions.LeafAccumulator folded over
+// the leaves by the last Generate, or nil when no accumulator is set
+func (self *Tree) Accumulator() []byte {
+	return self.accumulator
+}
+
+// HashOrder returns the positions of the internal nodes in the order the last
+// Generate computed them, if the tree was created with RecordHashOrder.
+// Levels are built one after the other from the leaves up and each level from
+// left to right, so the same leaves always give the same order.
+func (self *Tree) HashOrder() []HashPosition {
+	return self.hashOrder
+}
+
 func (self *Tree) GetMerkleProof(leafIndex uint) ([]ProofNode, error) {
+	err := self.checkBinary()
+	if err != nil {
+		return nil, err
+	}
 	leafCount := len(self.leaves())
 	if leafCount == 0 {
 		return nil, errors.New("Tree is empty")
@@ -129,6 +388,9 @@ func (self *Tree) GetMerkleProof(leafIndex uint) ([]ProofNode, error) {
 				nodes = append(nodes, ProofNode{Left: true, Hash: self.nodes[offset+uint64(leafIndex)-1].Hash})
 			}
 			index++
+		} else if sibling := self.oddSibling(self.nodes[offset+uint64(leafIndex)].Hash); sibling != nil {
+			nodes = append(nodes, ProofNode{Left: false, Hash: sibling})
+			index++
 		}
 		leafIndex = leafIndex / 2
 		offset += lastNodeInLevel + 1
@@ -138,6 +400,862 @@ func (self *Tree) GetMerkleProof(leafIndex uint) ([]ProofNode, error) {
 
 }
 
+// GetMerkleProofWithMarkers returns the proof of a leaf with one node per
+// level below the root, marking as Promoted the levels where GetMerkleProof
+// skips a node because the path has no sibling
+func (self *Tree) GetMerkleProofWithMarkers(index uint) ([]ProofNodeMarked, error) {
+	err := self.checkBinary()
+	if err != nil {
+		return nil, err
+	}
+	err = self.checkPromote()
+	if err != nil {
+		return nil, err
+	}
+	leafCount := len(self.leaves())
+	if leafCount == 0 {
+		return nil, errors.New("Tree is empty")
+	}
+	if index >= uint(leafCount) {
+		return nil, errors.New("node index is too big for node count")
+	}
+
+	nodes := []ProofNodeMarked{}
+	for level := self.height() - 1; level > 0; level-- {
+		current := self.levels[level]
+		if index%2 == 1 {
+			nodes = append(nodes, ProofNodeMarked{Left: true, Hash: current[index-1].Hash})
+		} else if index+1 < uint(len(current)) {
+			nodes = append(nodes, ProofNodeMarked{Left: false, Hash: current[index+1].Hash})
+		} else {
+			nodes = append(nodes, ProofNodeMarked{Promoted: true})
+		}
+		index = index / 2
+	}
+	return nodes, nil
+}
+
+// GetAnnotatedMerkleProof returns the proof of a leaf, each node annotated
+// with the level and index of the sibling, where level 0 holds the root.
+// Without the positions, the nodes are the ones of GetMerkleProof.
+func (self *Tree) GetAnnotatedMerkleProof(index uint) ([]ProofNodeAnnotated, error) {
+	err := self.checkBinary()
+	if err != nil {
+		return nil, err
+	}
+	err = self.checkPromote()
+	if err != nil {
+		return nil, err
+	}
+	leafCount := len(self.leaves())
+	if leafCount == 0 {
+		return nil, errors.New("Tree is empty")
+	}
+	if index >= uint(leafCount) {
+		return nil, errors.New("node index is too big for node count")
+	}
+
+	nodes := []ProofNodeAnnotated{}
+	for level := self.height() - 1; level > 0; level-- {
+		current := self.levels[level]
+		sibling := int(index ^ 1)
+		if sibling < len(current) {
+			nodes = append(nodes, ProofNodeAnnotated{
+				Hash:     current[sibling].Hash,
+				Left:     index%2 == 1,
+				Position: HashPosition{Level: level, Index: sibling},
+			})
+		}
+		index = index / 2
+	}
+	return nodes, nil
+}
+
+// GetSortedMerkleProof returns the proof of the leaf at index without the
+// sides of the siblings, for trees with hash sorting enabled
+func (self *Tree) GetSortedMerkleProof(index uint) (SortedProof, error) {
+	if !self.enableHashSorting {
+		return nil, errors.New("hash sorting is not enabled")
+	}
+	nodes, err := self.GetMerkleProof(index)
+	if err != nil {
+		return nil, err
+	}
+	proof := make(SortedProof, len(nodes))
+	for i, n := range nodes {
+		proof[i] = n.Hash
+	}
+	return proof, nil
+}
+
+// GetProof returns the proof of the leaf at index along with the index, the
+// number of leaves and the height of the tree
+func (self *Tree) GetProof(index uint) (*Proof, error) {
+	nodes, err := self.GetMerkleProof(index)
+	if err != nil {
+		return nil, err
+	}
+	return &Proof{LeafIndex: index, LeafCount: uint64(len(self.leaves())), TreeHeight: self.height(), Nodes: nodes}, nil
+}
+
+// ProofLen returns the number of nodes in the proof of the leaf at index,
+// without building it. Only a tree which promotes lone nodes has proofs
+// shorter than its height.
+func (self *Tree) ProofLen(index uint) (int, error) {
+	err := self.checkBinary()
+	if err != nil {
+		return 0, err
+	}
+	leafCount := len(self.leaves())
+	if leafCount == 0 {
+		return 0, errors.New("Tree is empty")
+	}
+	if index >= uint(leafCount) {
+		return 0, errors.New("node index is too big for node count")
+	}
+	if self.opts.OddNodeStrategy != OddNodePromote {
+		return int(self.height()) - 1, nil
+	}
+	return proofLength(uint64(index), uint64(leafCount)), nil
+}
+
+// GetMerkleProofs returns the proofs of several leaves, in the order of
+// indices. The proofs are computed in parallel as the generated tree is only
+// read.
+func (self *Tree) GetMerkleProofs(indices []uint) ([][]ProofNode, error) {
+	err := self.checkBinary()
+	if err != nil {
+		return nil, err
+	}
+	leafCount := len(self.leaves())
+	if leafCount == 0 {
+		return nil, errors.New("Tree is empty")
+	}
+	for _, i := range indices {
+		if i >= uint(leafCount) {
+			return nil, errors.New("node index is too big for node count")
+		}
+	}
+
+	proofs := make([][]ProofNode, len(indices))
+	errs := make([]error, len(indices))
+	workers := runtime.NumCPU()
+	if workers > len(indices) {
+		workers = len(indices)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < len(indices); i += workers {
+				proofs[i], errs[i] = self.GetMerkleProof(indices[i])
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return proofs, nil
+}
+
+// SubtreeLeafCount returns the number of leaves under the node at the given
+// index of a level, where level 0 holds the root. Rightmost subtrees of an
+// unbalanced tree hold fewer leaves than the others of their level.
+func (self *Tree) SubtreeLeafCount(level uint64, index int) (int, error) {
+	err := self.checkBinary()
+	if err != nil {
+		return 0, err
+	}
+	if self.levels == nil {
+		return 0, errors.New("Tree is empty")
+	}
+	if level >= self.height() {
+		return 0, errors.New("level is out of range")
+	}
+	if index < 0 || index >= len(self.levels[level]) {
+		return 0, errors.New("index is out of range")
+	}
+	span := 1 << (self.height() - 1 - level)
+	leafCount := len(self.leaves())
+	start := index * span
+	end := start + span
+	if end > leafCount {
+		end = leafCount
+	}
+	return end - start, nil
+}
+
+// PartialProof returns the proof of a leaf up to the node of upToLevel above
+// it, where level 0 holds the root, together with the hash of that node. A
+// verifier trusting the intermediate node folds the proof only that far.
+func (self *Tree) PartialProof(leafIndex uint, upToLevel uint64) ([]ProofNode, []byte, error) {
+	err := self.checkBinary()
+	if err != nil {
+		return nil, nil, err
+	}
+	leafCount := len(self.leaves())
+	if leafCount == 0 {
+		return nil, nil, errors.New("Tree is empty")
+	}
+	if leafIndex >= uint(leafCount) {
+		return nil, nil, errors.New("node index is too big for node count")
+	}
+	if upToLevel >= self.height() {
+		return nil, nil, errors.New("level is out of range")
+	}
+
+	nodes, index := self.pathProof(self.height()-1, int(leafIndex), upToLevel)
+	return nodes, self.levels[upToLevel][index].Hash, nil
+}
+
+// GetProofToAncestor returns the proof of a leaf against its ancestor at the
+// given level, where level 0 holds the root, as PartialProof does without
+// returning the ancestor hash
+func (self *Tree) GetProofToAncestor(leafIndex uint, level uint) ([]ProofNode, error) {
+	proof, _, err := self.PartialProof(leafIndex, uint64(level))
+	return proof, err
+}
+
+// CheckpointRoot returns the hashes of all nodes of a level, where level 0
+// holds the root. Verifiers trusting these nodes check a leaf against its
+// checkpoint with PartialProof, and each checkpoint against the root with
+// CheckpointProof.
+func (self *Tree) CheckpointRoot(level uint64) ([][]byte, error) {
+	if self.levels == nil {
+		return nil, errors.New("Tree is empty")
+	}
+	if level >= self.height() {
+		return nil, errors.New("level is out of range")
+	}
+	hashes := make([][]byte, len(self.levels[level]))
+	for i, n := range self.levels[level] {
+		hashes[i] = n.Hash
+	}
+	return hashes, nil
+}
+
+// CheckpointProof returns the proof of the node at the given index of a
+// level against the root
+func (self *Tree) CheckpointProof(level uint64, index int) ([]ProofNode, error) {
+	err := self.checkBinary()
+	if err != nil {
+		return nil, err
+	}
+	if self.levels == nil {
+		return nil, errors.New("Tree is empty")
+	}
+	if level >= self.height() {
+		return nil, errors.New("level is out of range")
+	}
+	if index < 0 || index >= len(self.levels[level]) {
+		return nil, errors.New("index is out of range")
+	}
+	nodes, _ := self.pathProof(level, index, 0)
+	return nodes, nil
+}
+
+// Leaves returns copies of the leaf nodes, nil if the tree is empty
+func (self *Tree) Leaves() []Node {
+	return copyNodes(self.leaves())
+}
+
+// Height returns the number of levels of the tree, 0 if it is empty
+func (self *Tree) Height() uint64 {
+	return self.height()
+}
+
+// NodesAtHeight returns copies of the nodes at height h, where height 1
+// holds the root and Height() the leaves, nil if h is out of range
+func (self *Tree) NodesAtHeight(h uint64) []Node {
+	return copyNodes(self.getNodesAtHeight(h))
+}
+
+// Walk calls fn for every node of the tree, level by level from the root,
+// where level 0 holds the root, and from left to right within a level. The
+// walk stops as soon as fn returns false.
+func (self *Tree) Walk(fn func(level uint, index uint, n *Node) bool) {
+	for level, nodes := range self.levels {
+		for i := range nodes {
+			if !fn(uint(level), uint(i), &nodes[i]) {
+				return
+			}
+		}
+	}
+}
+
+// LeafIterator iterates over the leaves of a tree, see Tree.LeafIterator
+type LeafIterator struct {
+	leaves []Node
+	index  int
+}
+
+// LeafIterator returns an iterator over the leaves of the tree in order
+func (self *Tree) LeafIterator() *LeafIterator {
+	return &LeafIterator{leaves: self.leaves(), index: -1}
+}
+
+// Next moves to the next leaf and returns false once all leaves were seen
+func (self *LeafIterator) Next() bool {
+	if self.index < len(self.leaves) {
+		self.index++
+	}
+	return self.index < len(self.leaves)
+}
+
+// Index returns the index of the current leaf
+func (self *LeafIterator) Index() uint {
+	return uint(self.index)
+}
+
+// Node returns a copy of the current leaf
+func (self *LeafIterator) Node() Node {
+	return copyNodes(self.leaves[self.index : self.index+1])[0]
+}
+
+// RebuildLevels recomputes the level boundaries of the flat nodes slice for
+// a tree of leafCount leaves and links every internal node to its children.
+// Node hashes are left as they are, no leaf is taken as padding.
+func (self *Tree) RebuildLevels(leafCount uint64) error {
+	err := self.checkBinary()
+	if err != nil {
+		return err
+	}
+	if leafCount == 0 {
+		return errors.New("Empty tree")
+	}
+	height, nodeCount := calculateHeightAndNodeCount(leafCount)
+	if uint64(len(self.nodes)) != nodeCount {
+		return errors.New("node count does not match the leaf count")
+	}
+
+	levels := make([][]Node, height)
+	current := self.nodes
+	width := leafCount
+	for h := height; h > 0; h-- {
+		levels[h-1] = current[:width]
+		current = current[width:]
+		width = (width + width%2) / 2
+	}
+	for h := uint64(0); h < height-1; h++ {
+		below := levels[h+1]
+		for i := range levels[h] {
+			levels[h][i].Left = &below[2*i]
+			levels[h][i].Right = nil
+			if 2*i+1 < len(below) {
+				levels[h][i].Right = &below[2*i+1]
+			}
+		}
+	}
+	self.levels = levels
+	self.blockCount = int(leafCount)
+	return nil
+}
+
+// Append adds blocks as the last leaves of the tree, generating it if it is
+// empty. Only the nodes on the right edge of the previous leaves are hashed
+// again, the others keep their hashes. Metrics and the hash order describe
+// the hashes computed by Append. On error the tree is left unchanged.
+func (self *Tree) Append(blocks [][]byte) error {
+	err := self.checkBinary()
+	if err != nil {
+		return err
+	}
+	err = self.checkHashFunc()
+	if err != nil {
+		return err
+	}
+	if self.nodes == nil {
+		return self.generate(blocks)
+	}
+	if self.opts.PadToPowerOfTwo || self.blockCount != len(self.leaves()) {
+		return errors.New("padded trees do not support Append")
+	}
+	if len(blocks) == 0 {
+		return nil
+	}
+	oldLevels := self.levels
+	oldCount := len(self.leaves())
+	leafCount := oldCount + len(blocks)
+	height, nodeCount := calculateHeightAndNodeCount(uint64(leafCount))
+	levels := make([][]Node, height)
+	nodes := make([]Node, nodeCount)
+
+	for i, leaf := range self.leaves() {
+		nodes[i] = Node{Hash: leaf.Hash}
+	}
+	for i, block := range blocks {
+		node, err := NewNode(self.leafHasher(), block)
+		if err != nil {
+			return err
+		}
+		nodes[oldCount+i] = node
+	}
+	levels[height-1] = nodes[:leafCount]
+	self.emitMetric("leaves_hashed", int64(len(blocks)))
+	pool := self.newHashPool()
+	dedupNodes(pool, levels[height-1][:oldCount], false)
+	dedupNodes(pool, levels[height-1][oldCount:], true)
+
+	accumulator := self.accumulator
+	if self.opts.LeafAccumulator != nil {
+		for _, leaf := range levels[height-1][oldCount:] {
+			accumulator = self.opts.LeafAccumulator(accumulator, leaf.Hash)
+		}
+	}
+
+	// Nodes before start in each level only have unchanged children and keep
+	// the hash of the node at the same position of the previous tree
+	var hashOrder []HashPosition
+	hashOps := int64(0)
+	current := nodes[leafCount:]
+	start := oldCount
+	for h := height - 1; h > 0; h-- {
+		below := levels[h]
+		end := (len(below) + len(below)%2) / 2
+		start = start / 2
+		if start > 0 {
+			old := oldLevels[uint64(len(oldLevels))-height+h-1]
+			for i := 0; i < start; i++ {
+				current[i] = Node{Hash: old[i].Hash, Left: &below[2*i], Right: &below[2*i+1]}
+			}
+		}
+		wrote, err := self.generateNodeLevel(below[2*start:], current[start:end])
+		if err != nil {
+			return err
+		}
+		dedupNodes(pool, current[:end], false)
+		hashOps += self.hashOpsOf(len(below[2*start:]))
+		self.emitMetric("level_completed", int64(h-1))
+		if self.opts.RecordHashOrder {
+			for i := start; i < start+int(wrote); i++ {
+				hashOrder = append(hashOrder, HashPosition{Level: h - 1, Index: i})
+			}
+		}
+		levels[h-1] = current[:end]
+		current = current[end:]
+	}
+
+	self.emitMetric("hash_ops", hashOps)
+
+	var leafIndex map[string]uint
+	if self.opts.IndexLeafHashes {
+		leafIndex = make(map[string]uint, leafCount)
+		for hash, i := range self.leafIndex {
+			leafIndex[hash] = i
+		}
+		for i := oldCount; i < leafCount; i++ {
+			if _, ok := leafIndex[string(levels[height-1][i].Hash)]; !ok {
+				leafIndex[string(levels[height-1][i].Hash)] = uint(i)
+			}
+		}
+	}
+
+	err = self.checkRoot(levels[0][0].Hash)
+	if err != nil {
+		return err
+	}
+
+	self.nodes = nodes
+	self.levels = levels
+	self.hashOrder = hashOrder
+	self.accumulator = accumulator
+	self.leafIndex = leafIndex
+	self.blockCount = leafCount
+	return nil
+}
+
+// Verify recomputes every internal node from its children and returns an
+// error for the first one which does not match, going up from the leaves,
+// for trees read from storage or received from untrusted peers
+func (self *Tree) Verify() error {
+	if self.levels == nil {
+		return errors.New("Tree is empty")
+	}
+	err := self.checkHashFunc()
+	if err != nil {
+		return err
+	}
+	for level := self.height() - 1; level > 0; level-- {
+		below := self.levels[level]
+		if len(self.levels[level-1]) != (len(below)+self.arity()-1)/self.arity() {
+			return fmt.Errorf("level %d does not match the width of the level below", level-1)
+		}
+		for i, n := range self.levels[level-1] {
+			parent, err := self.karyParent(self.karyGroup(below, i*self.arity()))
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(parent.Hash, n.Hash) {
+				return fmt.Errorf("node %d of level %d does not match its children", i, level-1)
+			}
+		}
+	}
+	return nil
+}
+
+// Subtree returns an independent tree made of the node at index of level,
+// where level 0 holds the root, and of the nodes under it. Hashes are shared
+// and not computed again. The node must not have a single child unless the
+// tree promotes lone nodes, as it is not the root of a tree of its leaves
+// otherwise.
+func (self *Tree) Subtree(level uint, index uint) (*Tree, error) {
+	if self.levels == nil {
+		return nil, errors.New("Tree is empty")
+	}
+	if uint64(level) >= self.height() {
+		return nil, errors.New("level is out of range")
+	}
+	if index >= uint(len(self.levels[level])) {
+		return nil, errors.New("index is out of range")
+	}
+
+	// The nodes under the node at each level, from the node down
+	ranges := [][]Node{}
+	first, end := int(index), int(index)+1
+	leafFirst := 0
+	for l := uint64(level); l < self.height(); l++ {
+		if end > len(self.levels[l]) {
+			end = len(self.levels[l])
+		}
+		ranges = append(ranges, self.levels[l][first:end])
+		leafFirst = first
+		first, end = first*self.arity(), end*self.arity()
+	}
+	// A node with a single child is that child promoted
+	for len(ranges) > 1 && len(ranges[1]) == 1 {
+		if self.opts.OddNodeStrategy != OddNodePromote {
+			return nil, errors.New("node has a single child, its subtree needs OddNodePromote")
+		}
+		ranges = ranges[1:]
+	}
+
+	nodeCount := 0
+	for _, r := range ranges {
+		nodeCount += len(r)
+	}
+	subtree := &Tree{
+		nodes:             make([]Node, nodeCount),
+		levels:            make([][]Node, len(ranges)),
+		enableHashSorting: self.enableHashSorting,
+		hashFunc:          self.hashFunc,
+		leafHash:          self.leafHash,
+		opts:              self.opts,
+	}
+	current := subtree.nodes
+	for l := len(ranges) - 1; l >= 0; l-- {
+		nodes := current[:len(ranges[l])]
+		current = current[len(ranges[l]):]
+		for i, n := range ranges[l] {
+			nodes[i] = Node{Hash: n.Hash}
+			if l+1 < len(ranges) && self.arity() == 2 {
+				below := subtree.levels[l+1]
+				nodes[i].Left = &below[2*i]
+				if 2*i+1 < len(below) {
+					nodes[i].Right = &below[2*i+1]
+				}
+			}
+		}
+		subtree.levels[l] = nodes
+	}
+
+	// Padding leaves of the tree are padding leaves of the subtree
+	subtree.blockCount = self.blockCount - leafFirst
+	if subtree.blockCount < 0 {
+		subtree.blockCount = 0
+	}
+	if subtree.blockCount > len(subtree.leaves()) {
+		subtree.blockCount = len(subtree.leaves())
+	}
+	leaves := subtree.blockLeaves()
+	if self.opts.LeafAccumulator != nil {
+		for _, leaf := range leaves {
+			subtree.accumulator = self.opts.LeafAccumulator(subtree.accumulator, leaf.Hash)
+		}
+	}
+	if self.opts.IndexLeafHashes {
+		subtree.leafIndex = make(map[string]uint, len(leaves))
+		for i := len(leaves) - 1; i >= 0; i-- {
+			subtree.leafIndex[string(leaves[i].Hash)] = uint(i)
+		}
+	}
+	return subtree, nil
+}
+
+// Clone returns a copy of the tree that the changes to the tree, such as
+// Append or Update, do not affect, and the other way around. Hashes are
+// immutable and shared, and so is the hash function: proofs are served
+// without hashing, but both trees should not be changed concurrently.
+func (self *Tree) Clone() *Tree {
+	clone := &Tree{
+		enableHashSorting: self.enableHashSorting,
+		hashFunc:          self.hashFunc,
+		leafHash:          self.leafHash,
+		opts:              self.opts,
+		hashOrder:         append([]HashPosition(nil), self.hashOrder...),
+		accumulator:       append([]byte(nil), self.accumulator...),
+		blockCount:        self.blockCount,
+	}
+	if self.leafIndex != nil {
+		clone.leafIndex = make(map[string]uint, len(self.leafIndex))
+		for hash, i := range self.leafIndex {
+			clone.leafIndex[hash] = i
+		}
+	}
+	if self.nodes == nil {
+		return clone
+	}
+
+	// Levels and children point into the nodes slice, so they are pointed
+	// at the same positions of the copy
+	clone.nodes = make([]Node, len(self.nodes))
+	copy(clone.nodes, self.nodes)
+	positions := make(map[*Node]int, len(self.nodes))
+	for i := range self.nodes {
+		positions[&self.nodes[i]] = i
+	}
+	relink := func(n *Node) *Node {
+		if n == nil {
+			return nil
+		}
+		return &clone.nodes[positions[n]]
+	}
+	for i := range clone.nodes {
+		clone.nodes[i].Left = relink(clone.nodes[i].Left)
+		clone.nodes[i].Right = relink(clone.nodes[i].Right)
+	}
+	clone.levels = make([][]Node, len(self.levels))
+	for i, level := range self.levels {
+		first := positions[&level[0]]
+		clone.levels[i] = clone.nodes[first : first+len(level)]
+	}
+	return clone
+}
+
+// Update replaces the leaf at leafIndex by the leaf of newBlock and hashes
+// again only the nodes on its path to the root. Metrics and the hash order
+// describe the hashes computed by Update. The padding leaves can not be
+// updated. On error the tree is left unchanged.
+func (self *Tree) Update(leafIndex uint, newBlock []byte) error {
+	leafCount := len(self.leaves())
+	if leafCount == 0 {
+		return errors.New("Tree is empty")
+	}
+	if leafIndex >= uint(leafCount) {
+		return errors.New("node index is too big for node count")
+	}
+	if leafIndex >= uint(self.blockCount) {
+		return errors.New("padding leaves can not be updated")
+	}
+	err := self.checkHashFunc()
+	if err != nil {
+		return err
+	}
+	leaf, err := NewNode(self.leafHasher(), newBlock)
+	if err != nil {
+		return err
+	}
+	self.emitMetric("leaves_hashed", 1)
+
+	// path[i] is the new hash of the node at height()-1-i on the path
+	path := [][]byte{leaf.Hash}
+	var hashOrder []HashPosition
+	hashOps := int64(0)
+	index := int(leafIndex)
+	for level := self.height() - 1; level > 0; level-- {
+		below := self.levels[level]
+		first := index - index%self.arity()
+		children := self.karyGroup(below, index)
+		children[index-first] = path[len(path)-1]
+		var node Node
+		if self.arity() == 2 {
+			var right []byte
+			if first+1 < len(below) {
+				right = children[1]
+			}
+			node, err = self.generateNode(children[0], right)
+		} else {
+			node, err = self.karyParent(children)
+		}
+		if err != nil {
+			return err
+		}
+		hashOps += self.hashOpsOf(len(children))
+		self.emitMetric("level_completed", int64(level-1))
+		if self.opts.RecordHashOrder {
+			hashOrder = append(hashOrder, HashPosition{Level: level - 1, Index: index / self.arity()})
+		}
+		path = append(path, node.Hash)
+		index = index / self.arity()
+	}
+	self.emitMetric("hash_ops", hashOps)
+
+	for _, forbidden := range self.opts.ForbiddenRoots {
+		if bytes.Equal(path[len(path)-1], forbidden) {
+			return errors.New("Root hash is forbidden")
+		}
+	}
+
+	index = int(leafIndex)
+	for i, hash := range path {
+		self.levels[self.height()-1-uint64(i)][index].Hash = hash
+		index = index / self.arity()
+	}
+	self.hashOrder = hashOrder
+	blockLeaves := self.blockLeaves()
+	if self.opts.LeafAccumulator != nil {
+		self.accumulator = nil
+		for _, leaf := range blockLeaves {
+			self.accumulator = self.opts.LeafAccumulator(self.accumulator, leaf.Hash)
+		}
+	}
+	if self.opts.IndexLeafHashes {
+		self.leafIndex = make(map[string]uint, len(blockLeaves))
+		for i := len(blockLeaves) - 1; i >= 0; i-- {
+			self.leafIndex[string(blockLeaves[i].Hash)] = uint(i)
+		}
+	}
+	return nil
+}
+
+// AppendAndProve adds block as the last leaf of the tree and returns its
+// index, its proof and the new root hash. The tree is extended by Append, on
+// error it is left unchanged.
+func (self *Tree) AppendAndProve(block []byte) (index uint, proof []ProofNode, root []byte, err error) {
+	err = self.Append([][]byte{block})
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	index = uint(len(self.leaves()) - 1)
+	proof, err = self.GetMerkleProof(index)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return index, proof, self.RootHash(), nil
+}
+
+// FirstDivergence returns the smallest index of a leaf whose hash differs
+// between the two trees, and false if the trees are identical. It descends
+// from the roots into the leftmost differing child, so only one path is
+// visited. Both trees must have the same number of leaves and be configured
+// with the same options.
+func (self *Tree) FirstDivergence(other *Tree) (int, bool, error) {
+	err := self.checkBinary()
+	if err != nil {
+		return 0, false, err
+	}
+	err = other.checkBinary()
+	if err != nil {
+		return 0, false, err
+	}
+	if !self.sameShape(other) {
+		return 0, false, errors.New("trees have different options")
+	}
+	if self.levels == nil || other.levels == nil {
+		return 0, false, errors.New("Tree is empty")
+	}
+	if len(self.leaves()) != len(other.leaves()) {
+		return 0, false, errors.New("trees have a different number of leaves")
+	}
+
+	a, b := self.root(), other.root()
+	if bytes.Equal(a.Hash, b.Hash) {
+		return -1, false, nil
+	}
+	index := 0
+	for a.Left != nil {
+		index = 2 * index
+		if a.Right != nil && bytes.Equal(a.Left.Hash, b.Left.Hash) {
+			a, b = a.Right, b.Right
+			index++
+		} else {
+			a, b = a.Left, b.Left
+		}
+	}
+	return index, true, nil
+}
+
+// StreamLeaves emits the leaf nodes in order on the returned channel, which
+// is closed once all leaves were sent or ctx is cancelled
+func (self *Tree) StreamLeaves(ctx context.Context) <-chan Node {
+	leaves := self.leaves()
+	out := make(chan Node)
+	go func() {
+		defer close(out)
+		for _, leaf := range leaves {
+			select {
+			case out <- leaf:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// SortedMultisetRoot returns the root of a tree built from the leaves sorted
+// by value, so it does not depend on their order. Unlike a set commitment,
+// duplicated leaves are kept and change the root.
+func SortedMultisetRoot(leaves [][]byte, h hash.Hash) ([]byte, error) {
+	sorted := make([][]byte, len(leaves))
+	copy(sorted, leaves)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+
+	tree := NewTree(h)
+	err := tree.generate(sorted)
+	if err != nil {
+		return nil, err
+	}
+	return tree.RootHash(), nil
+}
+
+// ZeroLeafRoot returns the root of a tree configured with opts of leafCount
+// leaf blocks all equal to zeroLeaf without building it. Complete subtrees of
+// identical leaves are hashed once per height, so this takes O(log leafCount)
+// hashes. Only binary trees which promote lone nodes and are not padded are
+// supported.
+func ZeroLeafRoot(leafCount uint64, zeroLeaf []byte, h hash.Hash, opts TreeOptions) ([]byte, error) {
+	err := checkFrontierOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if leafCount == 0 {
+		return nil, errors.New("Empty tree")
+	}
+	subtree, err := leafOf(zeroLeaf, h, opts)
+	if err != nil {
+		return nil, err
+	}
+	var root []byte
+	for n := leafCount; n > 0; n >>= 1 {
+		if n&1 == 1 {
+			if root == nil {
+				root = subtree
+			} else {
+				node, err := NewNode(h, concatHashes(subtree, root, opts.EnableHashSorting))
+				if err != nil {
+					return nil, err
+				}
+				root = node.Hash
+			}
+		}
+		if n > 1 {
+			node, err := NewNode(h, concatHashes(subtree, subtree, opts.EnableHashSorting))
+			if err != nil {
+				return nil, err
+			}
+			subtree = node.Hash
+		}
+	}
+	return root, nil
+}
+
 // Following are non public
 
 // Returns a slice of the leaf nodes in the tree, if available, else nil
@@ -149,6 +1267,24 @@ func (self *Tree) leaves() []Node {
 	}
 }
 
+// Returns the leaves made from blocks, without the padding leaves after them
+func (self *Tree) blockLeaves() []Node {
+	return self.leaves()[:self.blockCount]
+}
+
+// Returns copies of nodes with their own hash buffers and without children,
+// so the tree cannot be changed through them
+func copyNodes(nodes []Node) []Node {
+	if nodes == nil {
+		return nil
+	}
+	copies := make([]Node, len(nodes))
+	for i, n := range nodes {
+		copies[i] = Node{Hash: append([]byte(nil), n.Hash...)}
+	}
+	return copies
+}
+
 // Returns the root node of the tree, if available, else nil
 func (self *Tree) root() *Node {
 	if self.nodes == nil {
@@ -170,6 +1306,84 @@ func (self *Tree) getNodesAtHeight(h uint64) []Node {
 	}
 }
 
+// Returns the proof of the node at index of level up to the node of
+// upToLevel above it, and the index of that node
+func (self *Tree) pathProof(level uint64, index int, upToLevel uint64) ([]ProofNode, int) {
+	nodes := []ProofNode{}
+	for ; level > upToLevel; level-- {
+		current := self.levels[level]
+		if index%2 == 1 {
+			nodes = append(nodes, ProofNode{Left: true, Hash: current[index-1].Hash})
+		} else if index+1 < len(current) {
+			nodes = append(nodes, ProofNode{Left: false, Hash: current[index+1].Hash})
+		} else if sibling := self.oddSibling(current[index].Hash); sibling != nil {
+			nodes = append(nodes, ProofNode{Left: false, Hash: sibling})
+		}
+		index = index / 2
+	}
+	return nodes, index
+}
+
+// Returns the number of distinct hash buffers held by the nodes of the tree
+func (self *Tree) uniqueNodeCount() int {
+	buffers := map[*byte]bool{}
+	empty := 0
+	for _, n := range self.nodes {
+		if len(n.Hash) == 0 {
+			empty = 1
+			continue
+		}
+		buffers[&n.Hash[0]] = true
+	}
+	return len(buffers) + empty
+}
+
+// Returns the pool of the hashes shared by identical nodes, nil unless
+// DeduplicateSubtrees is set
+func (self *Tree) newHashPool() map[string][]byte {
+	if !self.opts.DeduplicateSubtrees {
+		return nil
+	}
+	return map[string][]byte{}
+}
+
+// Makes the nodes share the hash of the pool equal to theirs, adding the
+// missing hashes to the pool. Leaf hashes may be buffers of the caller and are
+// copied before they are added.
+func dedupNodes(pool map[string][]byte, nodes []Node, leaves bool) {
+	if pool == nil {
+		return
+	}
+	for i := range nodes {
+		hash, ok := pool[string(nodes[i].Hash)]
+		if !ok {
+			hash = nodes[i].Hash
+			if leaves && hash != nil {
+				hash = append([]byte{}, hash...)
+			}
+			pool[string(hash)] = hash
+		}
+		nodes[i].Hash = hash
+	}
+}
+
+// Checks root against the forbidden roots
+func (self *Tree) checkRoot(root []byte) error {
+	for _, forbidden := range self.opts.ForbiddenRoots {
+		if bytes.Equal(root, forbidden) {
+			return errors.New("Root hash is forbidden")
+		}
+	}
+	return nil
+}
+
+// Sends an event to the metrics sink of the tree options, if any
+func (self *Tree) emitMetric(event string, value int64) {
+	if self.opts.MetricsSink != nil {
+		self.opts.MetricsSink(event, value)
+	}
+}
+
 // Returns the height of this tree
 func (self *Tree) height() uint64 {
 	return uint64(len(self.levels))
@@ -207,22 +1421,138 @@ func (self *Tree) generateNodeLevel(below []Node, current []Node) (uint64, error
 }
 
 func (self *Tree) generateNode(left, right []byte) (Node, error) {
+	if right == nil {
+		right = self.oddSibling(left)
+	}
 	if right == nil {
 		data := make([]byte, len(left))
 		copy(data, left)
 		return Node{Hash: data}, nil
 	}
 
+	return NewNode(self.hashFunc, concatHashes(left, right, self.enableHashSorting))
+}
+
+// Returns the number of hashes computed for the parents of count nodes
+func (self *Tree) hashOpsOf(count int) int64 {
+	groups := count / self.arity()
+	rest := count % self.arity()
+	if rest > 1 || (rest == 1 && self.opts.OddNodeStrategy != OddNodePromote) {
+		groups++
+	}
+	return int64(groups)
+}
+
+// Returns true if both trees are built the same way from their leaves
+func (self *Tree) sameShape(other *Tree) bool {
+	return self.arity() == other.arity() &&
+		self.enableHashSorting == other.enableHashSorting &&
+		self.opts.DisableHashLeaves == other.opts.DisableHashLeaves &&
+		self.opts.PadToPowerOfTwo == other.opts.PadToPowerOfTwo &&
+		self.opts.OddNodeStrategy == other.opts.OddNodeStrategy &&
+		bytes.Equal(self.opts.PaddingLeaf, other.opts.PaddingLeaf)
+}
+
+// Returns an error if the tree has no hash function, as a tree imported by
+// ImportKV
+func (self *Tree) checkHashFunc() error {
+	if self.hashFunc == nil {
+		return errors.New("tree has no hash function")
+	}
+	return nil
+}
+
+// Returns an error unless the tree promotes lone nodes, as the proofs which
+// skip the missing siblings expect
+func (self *Tree) checkPromote() error {
+	return checkPromoteOptions(self.opts)
+}
+
+func checkPromoteOptions(opts TreeOptions) error {
+	if opts.OddNodeStrategy != OddNodePromote {
+		return errors.New("proof needs the OddNodePromote strategy")
+	}
+	return nil
+}
+
+// Returns an error unless opts describe a binary tree promoting lone nodes
+func checkBinaryPromoteOptions(opts TreeOptions) error {
+	if opts.Arity != 0 && opts.Arity != 2 {
+		return errors.New("tree options should have an arity of 2")
+	}
+	return checkPromoteOptions(opts)
+}
+
+// Returns an error unless the root of a tree configured with opts can be
+// folded from the roots of its complete subtrees, that is for binary trees
+// without padding which promote lone nodes
+func checkFrontierOptions(opts TreeOptions) error {
+	if opts.PadToPowerOfTwo {
+		return errors.New("PadToPowerOfTwo is not supported")
+	}
+	return checkBinaryPromoteOptions(opts)
+}
+
+// Returns the hash a node without a sibling is paired with, nil when it is
+// promoted
+func (self *Tree) oddSibling(hash []byte) []byte {
+	switch self.opts.OddNodeStrategy {
+	case OddNodeDuplicate:
+		return hash
+	case OddNodeZeroHash:
+		return make([]byte, len(hash))
+	}
+	return nil
+}
+
+// Returns the leaf added by PadToPowerOfTwo
+func (self *Tree) paddingLeaf() []byte {
+	if self.opts.PaddingLeaf != nil {
+		return self.opts.PaddingLeaf
+	}
+	return make([]byte, self.hashFunc.Size())
+}
+
+// Returns the hash function of the leaves, nil when they are stored as they
+// are
+func (self *Tree) leafHasher() hash.Hash {
+	if self.leafHash != nil && !self.opts.DisableHashLeaves {
+		return self.leafHash
+	}
+	return leafHashFunc(self.hashFunc, self.opts)
+}
+
+// Returns the hash function of the leaves of a tree configured with opts, nil
+// when the leaves are stored as they are
+func leafHashFunc(h hash.Hash, opts TreeOptions) hash.Hash {
+	if opts.DisableHashLeaves {
+		return nil
+	}
+	return h
+}
+
+// Returns the leaf a tree configured with opts stores for leafData, its hash
+// unless leaves are stored as they are
+func leafOf(leafData []byte, h hash.Hash, opts TreeOptions) ([]byte, error) {
+	leaf, err := NewNode(leafHashFunc(h, opts), leafData)
+	if err != nil {
+		return nil, err
+	}
+	return leaf.Hash, nil
+}
+
+// Concatenates the two children hashes, ordering them by value when sorting
+// is enabled
+func concatHashes(left, right []byte, sorting bool) []byte {
 	data := make([]byte, len(left)+len(right))
-	if self.enableHashSorting && bytes.Compare(left, right) > 0 {
+	if sorting && bytes.Compare(left, right) > 0 {
 		copy(data[:len(right)], right)
 		copy(data[len(right):], left)
 	} else {
 		copy(data[:len(left)], left)
 		copy(data[len(left):], right)
 	}
-
-	return NewNode(self.hashFunc, data)
+	return data
 }
 
 // Returns the height and number of nodes in an unbalanced binary tree given
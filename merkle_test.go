@@ -7,6 +7,7 @@ package merkle
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/sha256"
@@ -249,6 +250,23 @@ func TestNextPowerOfTwo(t *testing.T) {
 	}
 }
 
+func TestNextPowerOf(t *testing.T) {
+	for _, c := range []struct {
+		n, base, power uint64
+	}{{0, 2, 1}, {1, 3, 1}, {5, 2, 8}, {10, 3, 27}, {64, 4, 64}, {1 << 63, 2, 1 << 63}} {
+		power, err := nextPowerOf(c.n, c.base)
+		assert.Nil(t, err)
+		assert.Equal(t, c.power, power)
+	}
+
+	_, err := nextPowerOf(1<<63+1, 2)
+	assert.Equal(t, "next power overflows", err.Error())
+	_, err = nextPowerOf(^uint64(0), 3)
+	assert.Equal(t, "next power overflows", err.Error())
+	_, err = nextPowerOf(5, 1)
+	assert.Equal(t, "base should be at least 2", err.Error())
+}
+
 func TestIsPowerOfTwo(t *testing.T) {
 	type powerOfTwoResult struct {
 		input  uint64
@@ -275,6 +293,90 @@ func TestIsPowerOfTwo(t *testing.T) {
 	}
 }
 
+func TestIsPowerOf(t *testing.T) {
+	type powerOfResult struct {
+		input  uint64
+		base   uint64
+		output bool
+	}
+	inputs := []powerOfResult{
+		{0, 4, false},
+		{1, 4, true},
+		{4, 4, true},
+		{8, 4, false},
+		{16, 4, true},
+		{64, 16, false},
+		{256, 16, true},
+		{8, 2, true},
+		{8, 1, false},
+	}
+	for _, i := range inputs {
+		r := isPowerOf(i.input, i.base)
+		if r != i.output {
+			failNotEqual(t, "isPowerOf", []uint64{i.input, i.base}, i.output, r)
+		}
+	}
+}
+
+func TestLogBase(t *testing.T) {
+	inputs := [][]uint64{
+		{1, 4, 0},
+		{4, 4, 1},
+		{16, 4, 2},
+		{256, 16, 2},
+		{64, 2, 6},
+	}
+	for _, i := range inputs {
+		r := logBase(i[0], i[1])
+		if r != i[2] {
+			failNotEqual(t, "logBase", i[:2], i[2], r)
+		}
+	}
+}
+
+func TestAreSiblings(t *testing.T) {
+	type siblingsResult struct {
+		a, b   uint
+		output bool
+	}
+	inputs := []siblingsResult{
+		{0, 1, true},
+		{1, 0, true},
+		{6, 7, true},
+		{0, 0, false},
+		{1, 2, false},
+		{2, 1, false},
+		{3, 4, false},
+		{0, 5, false},
+	}
+	for _, i := range inputs {
+		r := AreSiblings(i.a, i.b)
+		if r != i.output {
+			failNotEqual(t, "AreSiblings", []uint{i.a, i.b}, i.output, r)
+		}
+	}
+}
+
+func TestSiblingIndex(t *testing.T) {
+	inputs := [][]uint{
+		{0, 1},
+		{1, 0},
+		{2, 3},
+		{3, 2},
+		{14, 15},
+	}
+	for _, i := range inputs {
+		r := SiblingIndex(i[0])
+		if r != i[1] {
+			failNotEqual(t, "SiblingIndex", i[0], i[1], r)
+		}
+	}
+	for i := uint(0); i < 64; i++ {
+		assert.Equal(t, i, SiblingIndex(SiblingIndex(i)))
+		assert.True(t, AreSiblings(i, SiblingIndex(i)))
+	}
+}
+
 /* Tree */
 
 func containsNode(nodes []Node, node *Node) bool {
@@ -920,6 +1022,664 @@ func TestGetMerkleProof3(t *testing.T) {
 	assert.Equal(t, err.Error(), "node index is too big for node count")
 }
 
+func TestTreeGetMerkleProofs(t *testing.T) {
+	h := md5.New()
+	tree := NewTree(h)
+	_, err := tree.GetMerkleProofs([]uint{0})
+	assert.Equal(t, "Tree is empty", err.Error())
+
+	treeData := createDummyTreeData(37, h.Size(), true)
+	err = tree.Generate(treeData, 0)
+	assert.Nil(t, err)
+
+	indices := []uint{36, 0, 5, 5, 17, 1, 35, 20}
+	proofs, err := tree.GetMerkleProofs(indices)
+	assert.Nil(t, err)
+	assert.Len(t, proofs, len(indices))
+	for i, index := range indices {
+		proof, err := tree.GetMerkleProof(index)
+		assert.Nil(t, err)
+		assert.Equal(t, proof, proofs[i], fmt.Sprintf("GetMerkleProofs, index %d", index))
+	}
+
+	proofs, err = tree.GetMerkleProofs(nil)
+	assert.Nil(t, err)
+	assert.Empty(t, proofs)
+
+	_, err = tree.GetMerkleProofs([]uint{0, 37})
+	assert.Equal(t, "node index is too big for node count", err.Error())
+}
+
+func TestAppendAndProve(t *testing.T) {
+	h := md5.New()
+	treeData := createDummyTreeData(9, h.Size(), true)
+	tree := NewTree(h)
+
+	for i, block := range treeData {
+		index, proof, root, err := tree.AppendAndProve(block)
+		assert.Nil(t, err)
+		assert.Equal(t, uint(i), index)
+		assert.Equal(t, tree.RootHash(), root)
+		assert.True(t, VerifyInclusion(block, index, uint64(i+1), proof, root, h, TreeOptions{DisableHashLeaves: true}))
+
+		expected := NewTree(h)
+		err = expected.Generate(treeData[:i+1], 0)
+		assert.Nil(t, err)
+		assert.Equal(t, expected.RootHash(), root)
+	}
+	verifyGeneratedTree(t, tree, h)
+
+	// A failing hash leaves the tree unchanged
+	root := tree.RootHash()
+	tree.hashFunc = NewFailingHash()
+	_, _, _, err := tree.AppendAndProve(treeData[0])
+	assert.Equal(t, "Failed to write hash", err.Error())
+	assert.Equal(t, root, tree.RootHash())
+	assert.Len(t, tree.leaves(), len(treeData))
+}
+
+func TestAppend(t *testing.T) {
+	h := md5.New()
+	treeData := createDummyTreeData(37, h.Size(), true)
+	for _, split := range []int{1, 4, 5, 8, 16, 36} {
+		for _, opts := range []TreeOptions{{}, {EnableHashSorting: true}, {DeduplicateSubtrees: true}} {
+			tree := NewTreeWithOpts(h, opts)
+			err := tree.Generate(treeData[:split], 0)
+			assert.Nil(t, err)
+			err = tree.Append(treeData[split:])
+			assert.Nil(t, err)
+
+			expected := NewTreeWithOpts(h, opts)
+			err = expected.Generate(treeData, 0)
+			assert.Nil(t, err)
+			assert.Equal(t, expected.RootHash(), tree.RootHash())
+			verifyGeneratedTree(t, tree, h)
+		}
+	}
+
+	tree := NewTree(h)
+	err := tree.Append(treeData[:3])
+	assert.Nil(t, err)
+	err = tree.Append(nil)
+	assert.Nil(t, err)
+	assert.Len(t, tree.leaves(), 3)
+	err = NewTree(h).Append(nil)
+	assert.Equal(t, "Empty tree", err.Error())
+}
+
+func TestAppendHashesRightEdge(t *testing.T) {
+	h := md5.New()
+	treeData := createDummyTreeData(17, h.Size(), true)
+	count := 0
+	tree := NewTree(NewHashCountDecorator(h, &count))
+	err := tree.Generate(treeData[:16], 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 15, count)
+
+	// the new leaf is carried up to a new root joining the previous root
+	count = 0
+	err = tree.Append(treeData[16:])
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+
+	// the new pair is hashed once and carried up to the root
+	count = 0
+	err = tree.Append(treeData[:1])
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestUpdate(t *testing.T) {
+	h := md5.New()
+	for _, opts := range []TreeOptions{{}, {EnableHashSorting: true}, {OddNodeStrategy: OddNodeDuplicate}, {Arity: 3}, {DisableHashLeaves: true, IndexLeafHashes: true}} {
+		for _, count := range []int{1, 2, 5, 11} {
+			data := createDummyTreeData(count, h.Size(), true)
+			tree := NewTreeWithOpts(h, opts)
+			err := tree.Generate(data, 0)
+			assert.Nil(t, err)
+			for i := range data {
+				data[i] = createDummyTreeData(1, h.Size(), true)[0]
+				err = tree.Update(uint(i), data[i])
+				assert.Nil(t, err)
+
+				expected := NewTreeWithOpts(h, opts)
+				err = expected.Generate(data, 0)
+				assert.Nil(t, err)
+				assert.Equal(t, expected.levels, tree.levels)
+				assert.Equal(t, expected.leafIndex, tree.leafIndex)
+			}
+		}
+	}
+
+	data := createDummyTreeData(16, h.Size(), true)
+	count := 0
+	tree := NewTree(NewHashCountDecorator(h, &count))
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+	count = 0
+	err = tree.Update(3, data[4])
+	assert.Nil(t, err)
+	assert.Equal(t, 4, count)
+	verifyGeneratedTree(t, tree, h)
+
+	// a failing hash leaves the tree unchanged
+	root := tree.RootHash()
+	tree.hashFunc = NewFailingHash()
+	err = tree.Update(3, data[5])
+	assert.Equal(t, "Failed to write hash", err.Error())
+	assert.Equal(t, root, tree.RootHash())
+	assert.Equal(t, data[4], tree.leaves()[3].Hash)
+
+	err = tree.Update(16, data[0])
+	assert.Equal(t, "node index is too big for node count", err.Error())
+	err = NewTree(h).Update(0, data[0])
+	assert.Equal(t, "Tree is empty", err.Error())
+
+	// padding leaves are neither updated nor accumulated or indexed
+	opts := TreeOptions{DisableHashLeaves: true, IndexLeafHashes: true, LeafAccumulator: func(acc, leafHash []byte) []byte {
+		return append(acc, leafHash[0])
+	}}
+	tree = NewTreeWithOpts(h, opts)
+	err = tree.Generate(data[:2], 4)
+	assert.Nil(t, err)
+	err = tree.Update(0, data[2])
+	assert.Nil(t, err)
+	expected := NewTreeWithOpts(h, opts)
+	err = expected.Generate([][]byte{data[2], data[1]}, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, expected.levels, tree.levels)
+	assert.Equal(t, expected.accumulator, tree.accumulator)
+	assert.Len(t, tree.accumulator, 2)
+	assert.Equal(t, expected.leafIndex, tree.leafIndex)
+	assert.Len(t, tree.leafIndex, 2)
+	err = tree.Update(3, data[0])
+	assert.Equal(t, "padding leaves can not be updated", err.Error())
+}
+
+func TestTreeVerify(t *testing.T) {
+	h := md5.New()
+	err := NewTree(h).Verify()
+	assert.Equal(t, "Tree is empty", err.Error())
+
+	for _, opts := range []TreeOptions{{}, {EnableHashSorting: true}, {OddNodeStrategy: OddNodeZeroHash}, {Arity: 4}} {
+		for _, count := range []int{1, 2, 5, 11} {
+			tree := NewTreeWithOpts(h, opts)
+			err := tree.Generate(createDummyTreeData(count, h.Size(), true), 0)
+			assert.Nil(t, err)
+			assert.Nil(t, tree.Verify())
+		}
+	}
+
+	// 5 Leaf Tree:
+	//             10
+	//        8         9 (7)
+	//   5       6     7 (4)
+	// 0   1   2   3   4
+	tree := NewTree(h)
+	err = tree.Generate(createDummyTreeData(5, h.Size(), true), 0)
+	assert.Nil(t, err)
+	tree.levels[3][2].Hash = testHashes[0]
+	err = tree.Verify()
+	assert.Equal(t, "node 1 of level 2 does not match its children", err.Error())
+	err = tree.Update(2, testHashes[0])
+	assert.Nil(t, err)
+	assert.Nil(t, tree.Verify())
+	tree.levels[0][0].Hash = testHashes[0]
+	err = tree.Verify()
+	assert.Equal(t, "node 0 of level 0 does not match its children", err.Error())
+	tree.levels[1] = tree.levels[1][:1]
+	err = tree.Verify()
+	assert.Equal(t, "level 1 does not match the width of the level below", err.Error())
+}
+
+func TestSubtree(t *testing.T) {
+	h := md5.New()
+	for _, opts := range []TreeOptions{{DisableHashLeaves: true}, {DisableHashLeaves: true, EnableHashSorting: true}, {DisableHashLeaves: true, Arity: 3}} {
+		data := createDummyTreeData(11, h.Size(), true)
+		tree := NewTreeWithOpts(h, opts)
+		err := tree.Generate(data, 0)
+		assert.Nil(t, err)
+
+		for level := uint(0); level < uint(tree.Height()); level++ {
+			span := 1
+			for i := level; i < uint(tree.Height())-1; i++ {
+				span *= tree.arity()
+			}
+			for index := uint(0); index < uint(len(tree.levels[level])); index++ {
+				subtree, err := tree.Subtree(level, index)
+				assert.Nil(t, err)
+				assert.Equal(t, tree.levels[level][index].Hash, subtree.RootHash())
+				assert.Nil(t, subtree.Verify())
+
+				// the subtree is the tree of its leaves
+				end := (int(index) + 1) * span
+				if end > len(data) {
+					end = len(data)
+				}
+				expected := NewTreeWithOpts(h, opts)
+				err = expected.Generate(data[int(index)*span:end], 0)
+				assert.Nil(t, err)
+				assert.Equal(t, expected.levels, subtree.levels)
+			}
+		}
+	}
+
+	// changes to the subtree do not affect the tree
+	data := createDummyTreeData(8, h.Size(), true)
+	tree := NewTree(h)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+	root := tree.RootHash()
+	subtree, err := tree.Subtree(1, 1)
+	assert.Nil(t, err)
+	err = subtree.Update(0, data[0])
+	assert.Nil(t, err)
+	assert.Equal(t, root, tree.RootHash())
+	assert.Equal(t, data[4], tree.leaves()[4].Hash)
+
+	_, err = tree.Subtree(4, 0)
+	assert.Equal(t, "level is out of range", err.Error())
+	_, err = tree.Subtree(1, 2)
+	assert.Equal(t, "index is out of range", err.Error())
+	_, err = NewTree(h).Subtree(0, 0)
+	assert.Equal(t, "Tree is empty", err.Error())
+	tree = NewTreeWithOpts(h, TreeOptions{DisableHashLeaves: true, OddNodeStrategy: OddNodeDuplicate})
+	err = tree.Generate(data[:5], 0)
+	assert.Nil(t, err)
+	_, err = tree.Subtree(1, 1)
+	assert.Equal(t, "node has a single child, its subtree needs OddNodePromote", err.Error())
+	_, err = tree.Subtree(1, 0)
+	assert.Nil(t, err)
+
+	// padding leaves are neither accumulated nor indexed
+	opts := TreeOptions{DisableHashLeaves: true, IndexLeafHashes: true, LeafAccumulator: func(acc, leafHash []byte) []byte {
+		return append(acc, leafHash[0])
+	}}
+	tree = NewTreeWithOpts(h, opts)
+	err = tree.Generate(data[:2], 4)
+	assert.Nil(t, err)
+	subtree, err = tree.Subtree(0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, tree.accumulator, subtree.accumulator)
+	assert.Len(t, subtree.accumulator, 2)
+	assert.Equal(t, tree.leafIndex, subtree.leafIndex)
+	assert.Len(t, subtree.leafIndex, 2)
+	subtree, err = tree.Subtree(1, 1)
+	assert.Nil(t, err)
+	assert.Nil(t, subtree.accumulator)
+	assert.Empty(t, subtree.leafIndex)
+	err = subtree.Append(data[:1])
+	assert.Equal(t, "padded trees do not support Append", err.Error())
+}
+
+func TestTreeClone(t *testing.T) {
+	h := md5.New()
+	data := createDummyTreeData(11, h.Size(), true)
+	tree := NewTreeWithOpts(h, TreeOptions{DisableHashLeaves: true, IndexLeafHashes: true, RecordHashOrder: true})
+	assert.Equal(t, tree, tree.Clone())
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+
+	clone := tree.Clone()
+	assert.Equal(t, tree, clone)
+	verifyGeneratedTree(t, clone, h)
+	for i := range clone.nodes {
+		assert.False(t, &clone.nodes[i] == &tree.nodes[i])
+	}
+
+	// changes to the tree do not affect the clone, and the other way around
+	root := tree.RootHash()
+	proof, err := tree.GetMerkleProof(3)
+	assert.Nil(t, err)
+	err = tree.Update(3, data[4])
+	assert.Nil(t, err)
+	err = tree.Append(data[:2])
+	assert.Nil(t, err)
+	assert.Equal(t, root, clone.RootHash())
+	clonedProof, err := clone.GetMerkleProof(3)
+	assert.Nil(t, err)
+	assert.Equal(t, proof, clonedProof)
+	_, err = clone.GetMerkleProofByHash(data[3])
+	assert.Nil(t, err)
+	_, err = tree.GetMerkleProofByHash(data[3])
+	assert.NotNil(t, err)
+
+	err = clone.Update(0, data[1])
+	assert.Nil(t, err)
+	assert.Equal(t, data[0], tree.leaves()[0].Hash)
+	verifyGeneratedTree(t, clone, h)
+}
+
+func TestWalk(t *testing.T) {
+	h := md5.New()
+	tree := NewTree(h)
+	tree.Walk(func(level, index uint, n *Node) bool {
+		t.Fatal("empty tree has no node")
+		return true
+	})
+	err := tree.Generate(createDummyTreeData(5, h.Size(), true), 0)
+	assert.Nil(t, err)
+
+	// 5 Leaf Tree:
+	//             10
+	//        8         9 (7)
+	//   5       6     7 (4)
+	// 0   1   2   3   4
+	positions := []HashPosition{}
+	tree.Walk(func(level, index uint, n *Node) bool {
+		assert.Equal(t, tree.levels[level][index].Hash, n.Hash)
+		positions = append(positions, HashPosition{Level: uint64(level), Index: int(index)})
+		return true
+	})
+	assert.Equal(t, []HashPosition{{0, 0}, {1, 0}, {1, 1}, {2, 0}, {2, 1}, {2, 2}, {3, 0}, {3, 1}, {3, 2}, {3, 3}, {3, 4}}, positions)
+
+	visited := 0
+	tree.Walk(func(level, index uint, n *Node) bool {
+		visited++
+		return level < 2
+	})
+	assert.Equal(t, 4, visited)
+}
+
+func TestLeafIterator(t *testing.T) {
+	h := md5.New()
+	assert.False(t, NewTree(h).LeafIterator().Next())
+
+	data := createDummyTreeData(5, h.Size(), true)
+	tree := NewTree(h)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+	it := tree.LeafIterator()
+	count := 0
+	for it.Next() {
+		assert.Equal(t, uint(count), it.Index())
+		assert.Equal(t, data[count], it.Node().Hash)
+		count++
+	}
+	assert.Equal(t, 5, count)
+	assert.False(t, it.Next())
+}
+
+func TestGenerateFromReader(t *testing.T) {
+	h := md5.New()
+	stream := make([]byte, 10*64+7)
+	for i := range stream {
+		stream[i] = byte(i * 31)
+	}
+	for _, size := range []int{1, 64, 100, len(stream), 2 * len(stream)} {
+		tree := NewTree(h)
+		err := tree.GenerateFromReader(bytes.NewReader(stream), size)
+		assert.Nil(t, err)
+
+		hashes := [][]byte{}
+		for i := 0; i < len(stream); i += size {
+			end := i + size
+			if end > len(stream) {
+				end = len(stream)
+			}
+			leaf, err := NewNode(h, stream[i:end])
+			assert.Nil(t, err)
+			hashes = append(hashes, leaf.Hash)
+		}
+		expected := NewTree(h)
+		err = expected.Generate(hashes, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, expected.RootHash(), tree.RootHash())
+
+		root, err := StreamingRoot(bytes.NewReader(stream), size, h, TreeOptions{})
+		assert.Nil(t, err)
+		assert.Equal(t, root, tree.RootHash())
+	}
+
+	tree := NewTree(h)
+	err := tree.GenerateFromReader(bytes.NewReader(nil), 64)
+	assert.Equal(t, "Empty tree", err.Error())
+	err = tree.GenerateFromReader(bytes.NewReader(stream), 0)
+	assert.Equal(t, "chunk size should be positive", err.Error())
+	err = NewTree(NewFailingHash()).GenerateFromReader(bytes.NewReader(stream), 64)
+	assert.Equal(t, "Failed to write hash", err.Error())
+}
+
+func TestStreamLeaves(t *testing.T) {
+	h := md5.New()
+	tree := NewTree(h)
+	count := 0
+	for range tree.StreamLeaves(context.Background()) {
+		count++
+	}
+	assert.Equal(t, 0, count)
+
+	treeData := createDummyTreeData(11, h.Size(), true)
+	err := tree.Generate(treeData, 0)
+	assert.Nil(t, err)
+
+	leaves := []Node{}
+	for leaf := range tree.StreamLeaves(context.Background()) {
+		leaves = append(leaves, leaf)
+	}
+	assert.Len(t, leaves, len(treeData))
+	for i, leaf := range leaves {
+		assert.Equal(t, treeData[i], leaf.Hash)
+	}
+}
+
+func TestStreamLeavesCancel(t *testing.T) {
+	h := md5.New()
+	tree := NewTree(h)
+	err := tree.Generate(createDummyTreeData(11, h.Size(), true), 0)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leaves := tree.StreamLeaves(ctx)
+	<-leaves
+	<-leaves
+	cancel()
+
+	// At most one more leaf may be in flight before the channel is closed
+	count := 0
+	for range leaves {
+		count++
+	}
+	assert.True(t, count <= 1)
+}
+
+func TestSortedMultisetRoot(t *testing.T) {
+	h := md5.New()
+	leaves := [][]byte{testHashes[3], testHashes[0], testHashes[2], testHashes[0], testHashes[1]}
+	root, err := SortedMultisetRoot(leaves, h)
+	assert.Nil(t, err)
+
+	// permutations yield the same root
+	permutations := [][][]byte{
+		{testHashes[0], testHashes[0], testHashes[1], testHashes[2], testHashes[3]},
+		{testHashes[1], testHashes[0], testHashes[3], testHashes[2], testHashes[0]},
+	}
+	for _, p := range permutations {
+		r, err := SortedMultisetRoot(p, h)
+		assert.Nil(t, err)
+		assert.Equal(t, root, r)
+	}
+	// the input is left untouched
+	assert.Equal(t, testHashes[3], leaves[0])
+
+	// duplicates are kept
+	r, err := SortedMultisetRoot(append(leaves, testHashes[0]), h)
+	assert.Nil(t, err)
+	assert.NotEqual(t, root, r)
+	r, err = SortedMultisetRoot([][]byte{testHashes[3], testHashes[2], testHashes[0], testHashes[1]}, h)
+	assert.Nil(t, err)
+	assert.NotEqual(t, root, r)
+
+	_, err = SortedMultisetRoot(nil, h)
+	assert.Equal(t, "Empty tree", err.Error())
+}
+
+func TestGenerateForbiddenRoots(t *testing.T) {
+	h := md5.New()
+	treeData := createDummyTreeData(5, h.Size(), true)
+	tree := NewTree(h)
+	err := tree.Generate(treeData, 0)
+	assert.Nil(t, err)
+	root := tree.RootHash()
+
+	// the computed root is forbidden
+	forbidden := NewTreeWithOpts(h, TreeOptions{DisableHashLeaves: true, ForbiddenRoots: [][]byte{make([]byte, h.Size()), root}})
+	err = forbidden.Generate(treeData, 0)
+	assert.Equal(t, "Root hash is forbidden", err.Error())
+	verifyInitialState(t, forbidden)
+
+	// a single leaf is its own root
+	err = forbidden.Generate(treeData[:1], 0)
+	assert.Nil(t, err)
+	err = NewTreeWithOpts(h, TreeOptions{DisableHashLeaves: true, ForbiddenRoots: [][]byte{treeData[0]}}).Generate(treeData[:1], 0)
+	assert.Equal(t, "Root hash is forbidden", err.Error())
+
+	// other roots are accepted
+	allowed := NewTreeWithOpts(h, TreeOptions{DisableHashLeaves: true, ForbiddenRoots: [][]byte{make([]byte, h.Size())}})
+	err = allowed.Generate(treeData, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, root, allowed.RootHash())
+}
+
+func TestRebuildLevels(t *testing.T) {
+	h := md5.New()
+	tree := NewTree(h)
+	err := tree.Generate(createDummyTreeData(13, h.Size(), true), 0)
+	assert.Nil(t, err)
+	proofs := make([][]ProofNode, 13)
+	for i := range proofs {
+		proofs[i], err = tree.GetMerkleProof(uint(i))
+		assert.Nil(t, err)
+	}
+	root := tree.RootHash()
+
+	tree.levels = nil
+	for i := range tree.nodes {
+		tree.nodes[i].Left = nil
+		tree.nodes[i].Right = nil
+	}
+	err = tree.RebuildLevels(13)
+	assert.Nil(t, err)
+	verifyGeneratedTree(t, tree, h)
+	assert.Equal(t, root, tree.RootHash())
+	for i := range proofs {
+		proof, err := tree.GetMerkleProof(uint(i))
+		assert.Nil(t, err)
+		assert.Equal(t, proofs[i], proof)
+	}
+
+	err = tree.RebuildLevels(12)
+	assert.Equal(t, "node count does not match the leaf count", err.Error())
+	err = tree.RebuildLevels(0)
+	assert.Equal(t, "Empty tree", err.Error())
+}
+
+func TestGenerateDeduplicateSubtrees(t *testing.T) {
+	h := md5.New()
+	// 16 leaves made of the same 2 values repeated
+	treeData := make([][]byte, 16)
+	for i := range treeData {
+		treeData[i] = append([]byte{}, testHashes[i%2]...)
+	}
+
+	tree := NewTree(h)
+	err := tree.Generate(treeData, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 31, tree.uniqueNodeCount())
+
+	dedup := NewTreeWithOpts(h, TreeOptions{DeduplicateSubtrees: true, DisableHashLeaves: true})
+	err = dedup.Generate(treeData, 0)
+	assert.Nil(t, err)
+	// 2 leaves, then a single distinct node per level
+	assert.Equal(t, 2+1+1+1+1, dedup.uniqueNodeCount())
+	assert.Equal(t, tree.RootHash(), dedup.RootHash())
+	verifyGeneratedTree(t, dedup, h)
+
+	for i := range treeData {
+		proof, err := dedup.GetMerkleProof(uint(i))
+		assert.Nil(t, err)
+		expected, err := tree.GetMerkleProof(uint(i))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, proof)
+		assert.True(t, VerifyInclusion(treeData[i], uint(i), 16, proof, dedup.RootHash(), h, TreeOptions{DisableHashLeaves: true}))
+	}
+
+	// the pooled leaves do not share the buffers of the blocks
+	root := dedup.RootHash()
+	treeData[0][0] ^= 0xff
+	assert.Equal(t, testHashes[0], dedup.leaves()[0].Hash)
+	assert.Equal(t, testHashes[0], dedup.leaves()[2].Hash)
+	treeData[0][0] ^= 0xff
+
+	// appended leaves join the pool
+	err = dedup.Append(treeData[:4])
+	assert.Nil(t, err)
+	// the promoted nodes of the 20 leaves share the hashes of the levels below
+	assert.Equal(t, 2+1+1+1+1+1, dedup.uniqueNodeCount())
+	assert.NotEqual(t, root, dedup.RootHash())
+	verifyGeneratedTree(t, dedup, h)
+}
+
+func TestZeroLeafRoot(t *testing.T) {
+	h := md5.New()
+	zeroLeaf := make([]byte, h.Size())
+	for _, opts := range []TreeOptions{{}, {EnableHashSorting: true}, {DisableHashLeaves: true}} {
+		for _, count := range []int{1, 2, 3, 5, 8, 13, 16, 33} {
+			treeData := make([][]byte, count)
+			for i := range treeData {
+				treeData[i] = zeroLeaf
+			}
+			tree := NewTreeWithOpts(h, opts)
+			err := tree.Generate(treeData, 0)
+			assert.Nil(t, err)
+
+			root, err := ZeroLeafRoot(uint64(count), zeroLeaf, h, opts)
+			assert.Nil(t, err)
+			assert.Equal(t, tree.RootHash(), root, fmt.Sprintf("ZeroLeafRoot(%d)", count))
+		}
+	}
+
+	hashCount := 0
+	root, err := ZeroLeafRoot(1<<20, zeroLeaf, NewHashCountDecorator(h, &hashCount), TreeOptions{DisableHashLeaves: true})
+	assert.Nil(t, err)
+	assert.NotNil(t, root)
+	assert.Equal(t, 20, hashCount)
+
+	// options giving another shape than the frontier of complete subtrees
+	unsupported := []struct {
+		opts    TreeOptions
+		message string
+	}{
+		{TreeOptions{OddNodeStrategy: OddNodeDuplicate}, "proof needs the OddNodePromote strategy"},
+		{TreeOptions{OddNodeStrategy: OddNodeZeroHash}, "proof needs the OddNodePromote strategy"},
+		{TreeOptions{Arity: 4}, "tree options should have an arity of 2"},
+		{TreeOptions{PadToPowerOfTwo: true}, "PadToPowerOfTwo is not supported"},
+	}
+	for _, c := range unsupported {
+		for _, count := range []int{3, 5, 6} {
+			treeData := make([][]byte, count)
+			for i := range treeData {
+				treeData[i] = zeroLeaf
+			}
+			// Generate gives another root than the promoting tree
+			tree := NewTreeWithOpts(h, c.opts)
+			err := tree.Generate(treeData, 0)
+			assert.Nil(t, err)
+			promoted, err := ZeroLeafRoot(uint64(count), zeroLeaf, h, TreeOptions{})
+			assert.Nil(t, err)
+			assert.NotEqual(t, promoted, tree.RootHash())
+
+			_, err = ZeroLeafRoot(uint64(count), zeroLeaf, h, c.opts)
+			assert.Equal(t, c.message, err.Error())
+		}
+	}
+
+	_, err = ZeroLeafRoot(0, zeroLeaf, h, TreeOptions{})
+	assert.Equal(t, "Empty tree", err.Error())
+	_, err = ZeroLeafRoot(2, zeroLeaf, NewFailingHash(), TreeOptions{})
+	assert.Equal(t, "Failed to write hash", err.Error())
+}
+
 /* Benchmarks */
 
 func generateBenchmark(b *testing.B, data [][]byte, hashf hash.Hash) {
@@ -975,6 +1735,20 @@ func BenchmarkGenerate_1GB_2MB_SHA256(b *testing.B) {
 	generateBenchmark(b, data, sha256.New())
 }
 
+func BenchmarkGetMerkleProofs_1K_Indices(b *testing.B) {
+	data := createDummyTreeData(1000, 32, false)
+	tree := NewTree(sha256.New())
+	tree.Generate(data, 0)
+	indices := make([]uint, len(data))
+	for i := range indices {
+		indices[i] = uint(i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.GetMerkleProofs(indices)
+	}
+}
+
 func Example_complete() {
 	items := [][]byte{[]byte("alpha"), []byte("beta"), []byte("gamma"), []byte("delta"), []byte("epsilon")}
 
@@ -990,3 +1764,710 @@ func Example_complete() {
 	fmt.Printf("N Leaves: %v\n", len(tree.leaves()))
 	fmt.Printf("Height 2: %v\n", tree.getNodesAtHeight(2))
 }
+
+func TestSubtreeLeafCount(t *testing.T) {
+	tree := NewTree(md5.New())
+	_, err := tree.SubtreeLeafCount(0, 0)
+	assert.Equal(t, "Tree is empty", err.Error())
+
+	// 5 Leaf Tree:
+	//             10
+	//        8         9 (7)
+	//   5       6     7 (4)
+	// 0   1   2   3   4
+	err = tree.Generate(createDummyTreeData(5, md5.Size, true), 0)
+	assert.Nil(t, err)
+
+	inputs := []struct {
+		level uint64
+		index int
+		count int
+	}{
+		// root covers all leaves
+		{0, 0, 5},
+		// mid-level nodes
+		{1, 0, 4},
+		{2, 1, 2},
+		// unbalanced rightmost nodes
+		{1, 1, 1},
+		{2, 2, 1},
+		// leaves
+		{3, 4, 1},
+	}
+	for _, in := range inputs {
+		count, err := tree.SubtreeLeafCount(in.level, in.index)
+		assert.Nil(t, err)
+		assert.Equal(t, in.count, count, fmt.Sprintf("SubtreeLeafCount(%d, %d)", in.level, in.index))
+	}
+
+	_, err = tree.SubtreeLeafCount(4, 0)
+	assert.Equal(t, "level is out of range", err.Error())
+	_, err = tree.SubtreeLeafCount(1, 2)
+	assert.Equal(t, "index is out of range", err.Error())
+	_, err = tree.SubtreeLeafCount(1, -1)
+	assert.Equal(t, "index is out of range", err.Error())
+}
+
+func TestNewTreeWithOpts(t *testing.T) {
+	tree := NewTreeWithOpts(nil, TreeOptions{})
+	verifyInitialState(t, tree)
+	assert.False(t, tree.enableHashSorting)
+
+	tree = NewTreeWithOpts(nil, TreeOptions{EnableHashSorting: true})
+	verifyInitialState(t, tree)
+	assert.True(t, tree.enableHashSorting)
+}
+
+func TestGenerateHashLeaves(t *testing.T) {
+	h := md5.New()
+	data := createDummyTreeData(5, h.Size(), true)
+	hashed := make([][]byte, len(data))
+	for i, block := range data {
+		node, err := NewNode(h, block)
+		assert.Nil(t, err)
+		hashed[i] = node.Hash
+	}
+
+	// blocks are hashed into the leaves by default
+	tree := NewTreeWithOpts(h, TreeOptions{})
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+	for i, leaf := range tree.leaves() {
+		assert.Equal(t, hashed[i], leaf.Hash)
+	}
+	err = tree.Append(data[:1])
+	assert.Nil(t, err)
+	assert.Equal(t, hashed[0], tree.leaves()[5].Hash)
+
+	// and stored as they are with DisableHashLeaves
+	raw := NewTreeWithOpts(h, TreeOptions{DisableHashLeaves: true})
+	err = raw.Generate(append(hashed, hashed[0]), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, raw.RootHash(), tree.RootHash())
+
+	proof, err := tree.GetMerkleProof(3)
+	assert.Nil(t, err)
+	assert.True(t, VerifyInclusion(data[3], 3, 6, proof, tree.RootHash(), h, TreeOptions{}))
+	assert.False(t, VerifyInclusion(data[3], 3, 6, proof, tree.RootHash(), h, TreeOptions{DisableHashLeaves: true}))
+	assert.True(t, VerifyInclusion(hashed[3], 3, 6, proof, tree.RootHash(), h, TreeOptions{DisableHashLeaves: true}))
+
+	err = NewTreeWithOpts(NewFailingHash(), TreeOptions{}).Generate(data, 0)
+	assert.Equal(t, "Failed to write hash", err.Error())
+}
+
+func TestNewTreeWithTwoHashFuncs(t *testing.T) {
+	leafHash, internalHash := sha256.New(), md5.New()
+	data := createDummyTreeData(5, 40, true)
+	tree := NewTreeWithTwoHashFuncs(leafHash, internalHash)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+
+	hashed := make([][]byte, len(data))
+	for i, block := range data {
+		node, err := NewNode(leafHash, block)
+		assert.Nil(t, err)
+		hashed[i] = node.Hash
+		assert.Equal(t, hashed[i], tree.leaves()[i].Hash)
+	}
+	expected := NewTree(internalHash)
+	err = expected.Generate(hashed, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, expected.RootHash(), tree.RootHash())
+	assert.Len(t, tree.RootHash(), md5.Size)
+
+	proof, err := tree.GetMerkleProof(4)
+	assert.Nil(t, err)
+	ok, err := VerifyProof(hashed[4], proof, tree.RootHash(), internalHash, TreeOptions{})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	err = tree.Append(data[:1])
+	assert.Nil(t, err)
+	assert.Equal(t, hashed[0], tree.leaves()[5].Hash)
+}
+
+func TestGeneratePadToPowerOfTwo(t *testing.T) {
+	h := md5.New()
+	data := createDummyTreeData(5, h.Size(), true)
+	for _, padding := range [][]byte{nil, testHashes[3]} {
+		tree := NewTreeWithOpts(h, TreeOptions{DisableHashLeaves: true, PadToPowerOfTwo: true, PaddingLeaf: padding})
+		err := tree.Generate(data, 0)
+		assert.Nil(t, err)
+		assert.Len(t, tree.leaves(), 8)
+		verifyGeneratedTree(t, tree, h)
+
+		if padding == nil {
+			padding = make([]byte, h.Size())
+		}
+		expected := NewTree(h)
+		err = expected.Generate(append(append([][]byte{}, data...), padding, padding, padding), 0)
+		assert.Nil(t, err)
+		assert.Equal(t, expected.RootHash(), tree.RootHash())
+
+		// all proofs have the same length
+		for i := uint(0); i < 8; i++ {
+			proof, err := tree.GetMerkleProof(i)
+			assert.Nil(t, err)
+			assert.Len(t, proof, 3)
+		}
+		err = tree.Append(data[:1])
+		assert.Equal(t, "padded trees do not support Append", err.Error())
+	}
+
+	// a power of two is not padded
+	tree := NewTreeWithOpts(h, TreeOptions{DisableHashLeaves: true, PadToPowerOfTwo: true})
+	err := tree.Generate(data[:4], 0)
+	assert.Nil(t, err)
+	assert.Len(t, tree.leaves(), 4)
+}
+
+func TestGenerateTotalLeavesSize(t *testing.T) {
+	h := md5.New()
+	data := createDummyTreeData(5, h.Size(), true)
+	padding := make([]byte, h.Size())
+	expected := NewTree(h)
+	err := expected.Generate(append(append([][]byte{}, data...), padding, padding), 0)
+	assert.Nil(t, err)
+
+	tree := NewTree(h)
+	err = tree.Generate(data, 7)
+	assert.Nil(t, err)
+	assert.Len(t, tree.leaves(), 7)
+	assert.Equal(t, expected.RootHash(), tree.RootHash())
+	verifyGeneratedTree(t, tree, h)
+	assert.Equal(t, 5, tree.blockCount)
+	// appended blocks would follow the padding
+	err = tree.Append(data[:1])
+	assert.Equal(t, "padded trees do not support Append", err.Error())
+	assert.Equal(t, expected.RootHash(), tree.RootHash())
+
+	// with PadToPowerOfTwo the total should be a power of two
+	tree = NewTreeWithOpts(h, TreeOptions{DisableHashLeaves: true, PadToPowerOfTwo: true, PaddingLeaf: testHashes[0]})
+	err = tree.Generate(data, 7)
+	assert.Equal(t, "totalLeavesSize should be a power of two with PadToPowerOfTwo", err.Error())
+	assert.Nil(t, tree.RootHash())
+	err = tree.Generate(data, 16)
+	assert.Nil(t, err)
+	assert.Len(t, tree.leaves(), 16)
+	assert.Equal(t, testHashes[0], tree.leaves()[5].Hash)
+
+	tree = NewTree(h)
+	err = tree.Generate(data, 5)
+	assert.Nil(t, err)
+	assert.Len(t, tree.leaves(), 5)
+	err = tree.Append(data[:1])
+	assert.Nil(t, err)
+	assert.Equal(t, 6, tree.blockCount)
+	err = tree.Generate(data, 4)
+	assert.Equal(t, "Leaves number is bigger than totalLeavesSize", err.Error())
+	err = tree.Generate(data, -1)
+	assert.Equal(t, "totalLeavesSize should not be negative", err.Error())
+}
+
+func TestGenerateOddNodeStrategy(t *testing.T) {
+	h := md5.New()
+	data := createDummyTreeData(3, h.Size(), true)
+	hashPair := func(left, right []byte) []byte {
+		node, err := NewNode(h, concatHashes(left, right, false))
+		assert.Nil(t, err)
+		return node.Hash
+	}
+	zero := make([]byte, h.Size())
+	for strategy, root := range map[OddNodeStrategy][]byte{
+		OddNodePromote:   hashPair(hashPair(data[0], data[1]), data[2]),
+		OddNodeDuplicate: hashPair(hashPair(data[0], data[1]), hashPair(data[2], data[2])),
+		OddNodeZeroHash:  hashPair(hashPair(data[0], data[1]), hashPair(data[2], zero)),
+	} {
+		opts := TreeOptions{DisableHashLeaves: true, OddNodeStrategy: strategy}
+		tree := NewTreeWithOpts(h, opts)
+		err := tree.Generate(data, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, root, tree.RootHash())
+	}
+
+	for _, strategy := range []OddNodeStrategy{OddNodeDuplicate, OddNodeZeroHash} {
+		opts := TreeOptions{DisableHashLeaves: true, OddNodeStrategy: strategy}
+		for _, count := range []int{1, 2, 3, 5, 11} {
+			data := createDummyTreeData(count, h.Size(), true)
+			tree := NewTreeWithOpts(h, opts)
+			err := tree.Generate(data, 0)
+			assert.Nil(t, err)
+			for i := range data {
+				proof, err := tree.GetMerkleProof(uint(i))
+				assert.Nil(t, err)
+				assert.Len(t, proof, int(tree.height()-1))
+				assert.True(t, VerifyInclusion(data[i], uint(i), uint64(count), proof, tree.RootHash(), h, opts))
+
+				partial, _, err := tree.PartialProof(uint(i), 0)
+				assert.Nil(t, err)
+				assert.Equal(t, proof, partial)
+			}
+		}
+	}
+}
+
+func TestOddNodeStrategyUnsupportedProofs(t *testing.T) {
+	h := md5.New()
+	data := createDummyTreeData(5, h.Size(), true)
+	for _, strategy := range []OddNodeStrategy{OddNodeDuplicate, OddNodeZeroHash} {
+		opts := TreeOptions{DisableHashLeaves: true, OddNodeStrategy: strategy}
+		tree := NewTreeWithOpts(h, opts)
+		err := tree.Generate(data, 0)
+		assert.Nil(t, err)
+
+		_, err = tree.GetMerkleMultiProof([]uint{0, 4})
+		assert.Equal(t, "proof needs the OddNodePromote strategy", err.Error())
+		_, err = tree.GetRangeProof(0, 2)
+		assert.Equal(t, "proof needs the OddNodePromote strategy", err.Error())
+		_, err = tree.GetOctopusProof([]uint{0, 4})
+		assert.Equal(t, "proof needs the OddNodePromote strategy", err.Error())
+		_, err = tree.GetAnnotatedMerkleProof(4)
+		assert.Equal(t, "proof needs the OddNodePromote strategy", err.Error())
+		_, err = tree.ConsistencyProof(3, 5)
+		assert.Equal(t, "proof needs the OddNodePromote strategy", err.Error())
+		_, err = tree.RFC9162InclusionProof(4, 5)
+		assert.Equal(t, "proof needs the OddNodePromote strategy", err.Error())
+		_, err = tree.RankProof(4)
+		assert.Equal(t, "proof needs the OddNodePromote strategy", err.Error())
+		_, err = tree.GetMerkleProofWithMarkers(4)
+		assert.Equal(t, "proof needs the OddNodePromote strategy", err.Error())
+
+		// every level has a sibling, duplicated or zero
+		for i := range data {
+			proof, err := tree.GetMerkleProof(uint(i))
+			assert.Nil(t, err)
+			length, err := tree.ProofLen(uint(i))
+			assert.Nil(t, err)
+			assert.Equal(t, len(proof), length)
+		}
+
+		proof, err := tree.GetMerkleProof(4)
+		assert.Nil(t, err)
+		_, err = CombineVerifiedProofs(tree.RootHash(), 5, map[uint][]byte{4: data[4]}, map[uint][]ProofNode{4: proof}, h, opts)
+		assert.Equal(t, "proof needs the OddNodePromote strategy", err.Error())
+		_, err = NewPartialTree(tree.RootHash(), 5, h, opts)
+		assert.Equal(t, "proof needs the OddNodePromote strategy", err.Error())
+	}
+}
+
+func TestTreeAccessors(t *testing.T) {
+	h := md5.New()
+	tree := NewTree(h)
+	assert.Nil(t, tree.Leaves())
+	assert.Equal(t, uint64(0), tree.Height())
+	assert.Nil(t, tree.NodesAtHeight(1))
+
+	data := createDummyTreeData(5, h.Size(), true)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), tree.Height())
+	leaves := tree.Leaves()
+	assert.Len(t, leaves, 5)
+	for i, leaf := range leaves {
+		assert.Equal(t, data[i], leaf.Hash)
+	}
+	assert.Equal(t, leaves, tree.NodesAtHeight(4))
+	assert.Len(t, tree.NodesAtHeight(3), 3)
+	assert.Equal(t, tree.RootHash(), tree.NodesAtHeight(1)[0].Hash)
+	assert.Nil(t, tree.NodesAtHeight(0))
+	assert.Nil(t, tree.NodesAtHeight(5))
+
+	// the returned nodes do not change the tree
+	root := append([]byte(nil), tree.RootHash()...)
+	nodes := tree.NodesAtHeight(1)
+	nodes[0].Hash[0]++
+	assert.Nil(t, nodes[0].Left)
+	assert.Equal(t, root, tree.RootHash())
+}
+
+func TestPartialProof(t *testing.T) {
+	h := md5.New()
+	tree := NewTree(h)
+	_, _, err := tree.PartialProof(0, 0)
+	assert.Equal(t, "Tree is empty", err.Error())
+
+	data := createDummyTreeData(7, h.Size(), true)
+	err = tree.Generate(data, 0)
+	assert.Nil(t, err)
+
+	for i, leaf := range data {
+		for level := uint64(0); level < tree.height(); level++ {
+			proof, intermediate, err := tree.PartialProof(uint(i), level)
+			assert.Nil(t, err)
+			assert.Equal(t, tree.levels[level][i>>(tree.height()-1-level)].Hash, intermediate)
+			ok, err := VerifyProof(leaf, proof, intermediate, h, TreeOptions{})
+			assert.Nil(t, err)
+			assert.True(t, ok, fmt.Sprintf("PartialProof(%d, %d)", i, level))
+		}
+
+		// up to the root the partial proof is the full proof
+		proof, root, err := tree.PartialProof(uint(i), 0)
+		assert.Nil(t, err)
+		expected, err := tree.GetMerkleProof(uint(i))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, proof)
+		assert.Equal(t, tree.RootHash(), root)
+	}
+
+	_, _, err = tree.PartialProof(7, 0)
+	assert.Equal(t, "node index is too big for node count", err.Error())
+	_, _, err = tree.PartialProof(0, 4)
+	assert.Equal(t, "level is out of range", err.Error())
+}
+
+func TestGenerateRecordHashOrder(t *testing.T) {
+	data := createDummyTreeData(5, md5.Size, true)
+	tree := NewTree(md5.New())
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, tree.HashOrder())
+
+	first := NewTreeWithOpts(md5.New(), TreeOptions{RecordHashOrder: true})
+	err = first.Generate(data, 0)
+	assert.Nil(t, err)
+	second := NewTreeWithOpts(md5.New(), TreeOptions{RecordHashOrder: true})
+	err = second.Generate(data, 0)
+	assert.Nil(t, err)
+
+	// 5 Leaf Tree:
+	//             10
+	//        8         9 (7)
+	//   5       6     7 (4)
+	// 0   1   2   3   4
+	expected := []HashPosition{{2, 0}, {2, 1}, {2, 2}, {1, 0}, {1, 1}, {0, 0}}
+	assert.Equal(t, expected, first.HashOrder())
+	assert.Equal(t, first.HashOrder(), second.HashOrder())
+
+	// appending only hashes the right edge again
+	_, _, _, err = first.AppendAndProve(data[0])
+	assert.Nil(t, err)
+	assert.Equal(t, []HashPosition{{2, 2}, {1, 1}, {0, 0}}, first.HashOrder())
+}
+
+func TestCheckpointRoot(t *testing.T) {
+	h := md5.New()
+	tree := NewTree(h)
+	_, err := tree.CheckpointRoot(0)
+	assert.Equal(t, "Tree is empty", err.Error())
+	_, err = tree.CheckpointProof(0, 0)
+	assert.Equal(t, "Tree is empty", err.Error())
+
+	data := createDummyTreeData(11, h.Size(), true)
+	err = tree.Generate(data, 0)
+	assert.Nil(t, err)
+
+	for level := uint64(0); level < tree.height(); level++ {
+		checkpoints, err := tree.CheckpointRoot(level)
+		assert.Nil(t, err)
+		assert.Len(t, checkpoints, len(tree.levels[level]))
+
+		for i, leaf := range data {
+			index := i >> (tree.height() - 1 - level)
+			leafProof, checkpoint, err := tree.PartialProof(uint(i), level)
+			assert.Nil(t, err)
+			assert.Equal(t, checkpoints[index], checkpoint)
+			ok, err := VerifyProof(leaf, leafProof, checkpoint, h, TreeOptions{})
+			assert.Nil(t, err)
+			assert.True(t, ok)
+
+			checkpointProof, err := tree.CheckpointProof(level, index)
+			assert.Nil(t, err)
+			ok, err = VerifyProof(checkpoint, checkpointProof, tree.RootHash(), h, TreeOptions{})
+			assert.Nil(t, err)
+			assert.True(t, ok, fmt.Sprintf("CheckpointProof(%d, %d)", level, index))
+
+			// both parts together make the full proof
+			proof, err := tree.GetMerkleProof(uint(i))
+			assert.Nil(t, err)
+			assert.Equal(t, proof, append(leafProof, checkpointProof...))
+		}
+	}
+
+	_, err = tree.CheckpointRoot(5)
+	assert.Equal(t, "level is out of range", err.Error())
+	_, err = tree.CheckpointProof(5, 0)
+	assert.Equal(t, "level is out of range", err.Error())
+	_, err = tree.CheckpointProof(1, 2)
+	assert.Equal(t, "index is out of range", err.Error())
+}
+
+func TestGetMerkleProofWithMarkers(t *testing.T) {
+	h := md5.New()
+	tree := NewTree(h)
+	_, err := tree.GetMerkleProofWithMarkers(0)
+	assert.Equal(t, "Tree is empty", err.Error())
+
+	// 5 Leaf Tree:
+	//             10
+	//        8         9 (7)
+	//   5       6     7 (4)
+	// 0   1   2   3   4
+	data := createDummyTreeData(5, h.Size(), true)
+	err = tree.Generate(data, 0)
+	assert.Nil(t, err)
+
+	proof, err := tree.GetMerkleProofWithMarkers(4)
+	assert.Nil(t, err)
+	assert.Equal(t, []ProofNodeMarked{
+		{Promoted: true},
+		{Promoted: true},
+		{Left: true, Hash: tree.levels[1][0].Hash},
+	}, proof)
+
+	proof, err = tree.GetMerkleProofWithMarkers(2)
+	assert.Nil(t, err)
+	assert.Equal(t, []ProofNodeMarked{
+		{Left: false, Hash: tree.levels[3][3].Hash},
+		{Left: true, Hash: tree.levels[2][0].Hash},
+		{Left: false, Hash: tree.levels[1][1].Hash},
+	}, proof)
+
+	for i, leaf := range data {
+		proof, err := tree.GetMerkleProofWithMarkers(uint(i))
+		assert.Nil(t, err)
+		assert.Len(t, proof, int(tree.height()-1))
+		ok, err := VerifyMarkedProof(leaf, proof, tree.RootHash(), h, TreeOptions{})
+		assert.Nil(t, err)
+		assert.True(t, ok)
+
+		// without markers the proof is the one of GetMerkleProof
+		expected, err := tree.GetMerkleProof(uint(i))
+		assert.Nil(t, err)
+		unmarked := []ProofNode{}
+		for _, n := range proof {
+			if !n.Promoted {
+				unmarked = append(unmarked, ProofNode{Left: n.Left, Hash: n.Hash})
+			}
+		}
+		assert.Equal(t, expected, unmarked)
+	}
+
+	_, err = tree.GetMerkleProofWithMarkers(5)
+	assert.Equal(t, "node index is too big for node count", err.Error())
+	_, err = VerifyMarkedProof(data[4], []ProofNodeMarked{{Promoted: true, Hash: data[0]}}, tree.RootHash(), h, TreeOptions{})
+	assert.Equal(t, "promoted proof node has a hash", err.Error())
+}
+
+func TestGenerateLeafAccumulator(t *testing.T) {
+	xor := func(acc, leafHash []byte) []byte {
+		result := make([]byte, len(leafHash))
+		copy(result, acc)
+		for i := range leafHash {
+			result[i] ^= leafHash[i]
+		}
+		return result
+	}
+	data := createDummyTreeData(7, md5.Size, true)
+	tree := NewTreeWithOpts(md5.New(), TreeOptions{LeafAccumulator: xor, DisableHashLeaves: true})
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+
+	expected := make([]byte, md5.Size)
+	for _, leaf := range data {
+		for i := range leaf {
+			expected[i] ^= leaf[i]
+		}
+	}
+	assert.Equal(t, expected, tree.Accumulator())
+
+	// the accumulator follows appended leaves
+	_, _, _, err = tree.AppendAndProve(data[0])
+	assert.Nil(t, err)
+	assert.Equal(t, xor(expected, data[0]), tree.Accumulator())
+
+	tree = NewTree(md5.New())
+	err = tree.Generate(data, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, tree.Accumulator())
+}
+
+func TestFirstDivergence(t *testing.T) {
+	data := createDummyTreeData(11, md5.Size, true)
+	tree := NewTree(md5.New())
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+
+	for i := range data {
+		changed := make([][]byte, len(data))
+		copy(changed, data)
+		changed[i] = hashValue(data[i], md5.New())
+		// a later change must not hide the first one
+		if i+2 < len(data) {
+			changed[i+2] = changed[i]
+		}
+		other := NewTree(md5.New())
+		err = other.Generate(changed, 0)
+		assert.Nil(t, err)
+
+		index, diverged, err := tree.FirstDivergence(other)
+		assert.Nil(t, err)
+		assert.True(t, diverged)
+		assert.Equal(t, i, index)
+	}
+
+	same := NewTree(md5.New())
+	err = same.Generate(data, 0)
+	assert.Nil(t, err)
+	index, diverged, err := tree.FirstDivergence(same)
+	assert.Nil(t, err)
+	assert.False(t, diverged)
+	assert.Equal(t, -1, index)
+
+	smaller := NewTree(md5.New())
+	err = smaller.Generate(data[:10], 0)
+	assert.Nil(t, err)
+	_, _, err = tree.FirstDivergence(smaller)
+	assert.Equal(t, "trees have a different number of leaves", err.Error())
+	_, _, err = tree.FirstDivergence(NewTree(md5.New()))
+	assert.Equal(t, "Tree is empty", err.Error())
+
+	kary := NewTreeWithOpts(md5.New(), TreeOptions{DisableHashLeaves: true, Arity: 4})
+	err = kary.Generate(data, 0)
+	assert.Nil(t, err)
+	_, _, err = tree.FirstDivergence(kary)
+	assert.Equal(t, "Binary proofs need an arity of 2, use GetKaryMerkleProof", err.Error())
+	duplicate := NewTreeWithOpts(md5.New(), TreeOptions{DisableHashLeaves: true, OddNodeStrategy: OddNodeDuplicate})
+	err = duplicate.Generate(data, 0)
+	assert.Nil(t, err)
+	_, _, err = tree.FirstDivergence(duplicate)
+	assert.Equal(t, "trees have different options", err.Error())
+}
+
+func TestGenerateMetricsSink(t *testing.T) {
+	type event struct {
+		name  string
+		value int64
+	}
+	events := []event{}
+	sink := func(name string, value int64) {
+		events = append(events, event{name, value})
+	}
+	tree := NewTreeWithOpts(md5.New(), TreeOptions{MetricsSink: sink, DisableHashLeaves: true})
+	err := tree.Generate(createDummyTreeData(5, md5.Size, true), 0)
+	assert.Nil(t, err)
+
+	// 5 Leaf Tree:
+	//             10
+	//        8         9 (7)
+	//   5       6     7 (4)
+	// 0   1   2   3   4
+	expected := []event{
+		{"leaves_hashed", 5},
+		{"level_completed", 2},
+		{"level_completed", 1},
+		{"level_completed", 0},
+		{"hash_ops", 4},
+	}
+	assert.Equal(t, expected, events)
+
+	// no hash_ops event when hashing fails
+	events = []event{}
+	tree = NewTreeWithOpts(NewFailingHash(), TreeOptions{MetricsSink: sink, DisableHashLeaves: true})
+	err = tree.Generate(createDummyTreeData(5, md5.Size, true), 0)
+	assert.NotNil(t, err)
+	assert.Equal(t, []event{{"leaves_hashed", 5}}, events)
+}
+
+func TestGetProofToAncestor(t *testing.T) {
+	h := md5.New()
+	data := createDummyTreeData(6, h.Size(), true)
+	tree := NewTree(h)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+
+	// 6 Leaf Tree:
+	//               11
+	//         9           10 (8)
+	//    6        7       8
+	//  0   1    2   3   4   5
+	proof, err := tree.GetProofToAncestor(5, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []ProofNode{{Left: true, Hash: data[4]}}, proof)
+	ok, err := VerifyProof(data[5], proof, tree.levels[2][2].Hash, h, TreeOptions{})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// the promoted ancestor of leaf 5 one level up has the same hash
+	proof, err = tree.GetProofToAncestor(5, 1)
+	assert.Nil(t, err)
+	assert.Len(t, proof, 1)
+	assert.Equal(t, tree.levels[2][2].Hash, tree.levels[1][1].Hash)
+
+	proof, err = tree.GetProofToAncestor(1, 1)
+	assert.Nil(t, err)
+	ok, err = VerifyProof(data[1], proof, tree.levels[1][0].Hash, h, TreeOptions{})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	_, err = tree.GetProofToAncestor(1, 4)
+	assert.Equal(t, "level is out of range", err.Error())
+}
+
+func TestGetMerkleProofByHash(t *testing.T) {
+	h := sha256.New()
+	data := createDummyTreeData(7, h.Size(), true)
+	data[5] = data[2]
+	tree := NewTreeWithOpts(h, TreeOptions{IndexLeafHashes: true, DisableHashLeaves: true})
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+
+	for i, leaf := range data {
+		proof, err := tree.GetMerkleProofByHash(leaf)
+		assert.Nil(t, err)
+		expectedIndex := uint(i)
+		if i == 5 {
+			expectedIndex = 2
+		}
+		expected, err := tree.GetMerkleProof(expectedIndex)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, proof)
+	}
+
+	_, err = tree.GetMerkleProofByHash(make([]byte, h.Size()))
+	assert.Equal(t, "leaf hash is not in the tree", err.Error())
+
+	_, _, _, err = tree.AppendAndProve(data[0][:16])
+	assert.Nil(t, err)
+	proof, err := tree.GetMerkleProofByHash(data[0][:16])
+	assert.Nil(t, err)
+	expected, err := tree.GetMerkleProof(7)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, proof)
+
+	unindexed := NewTree(h)
+	err = unindexed.Generate(data, 0)
+	assert.Nil(t, err)
+	_, err = unindexed.GetMerkleProofByHash(data[0])
+	assert.Equal(t, "leaf hashes are not indexed", err.Error())
+}
+
+func TestGetAnnotatedMerkleProof(t *testing.T) {
+	h := sha256.New()
+	data := createDummyTreeData(11, h.Size(), true)
+	tree := NewTree(h)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+
+	for i := range data {
+		annotated, err := tree.GetAnnotatedMerkleProof(uint(i))
+		assert.Nil(t, err)
+		proof, err := tree.GetMerkleProof(uint(i))
+		assert.Nil(t, err)
+		assert.Len(t, annotated, len(proof))
+		for j, node := range annotated {
+			assert.Equal(t, proof[j], ProofNode{Hash: node.Hash, Left: node.Left})
+			assert.Equal(t, node.Hash, tree.levels[node.Position.Level][node.Position.Index].Hash)
+		}
+	}
+
+	// leaf 10 is promoted at the leaves level, its first sibling is node 4
+	// of the level above
+	annotated, err := tree.GetAnnotatedMerkleProof(10)
+	assert.Nil(t, err)
+	assert.Equal(t, HashPosition{Level: 3, Index: 4}, annotated[0].Position)
+
+	_, err = tree.GetAnnotatedMerkleProof(11)
+	assert.Equal(t, "node index is too big for node count", err.Error())
+	_, err = NewTree(h).GetAnnotatedMerkleProof(0)
+	assert.Equal(t, "Tree is empty", err.Error())
+}
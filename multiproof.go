@@ -0,0 +1,577 @@
+package merkle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+	"sort"
+)
+
+// MultiProof proves several leaves of a Tree at once. Sibling hashes shared
+// by the paths of the leaves, or computable from the leaves themselves, are
+// only sent once.
+type MultiProof struct {
+	// Number of leaves of the tree
+	TreeSize uint64
+	// Positions of the proven leaves, sorted without duplicates
+	Indices []uint
+	// Sibling hashes needed to compute the root, from the leaves level up and
+	// from left to right within a level
+	Hashes [][]byte
+}
+
+// GetMerkleMultiProof returns a multiproof of the leaves at indices. The
+// indices are sorted and deduplicated in the returned proof, the leaves must
+// be given to VerifyMultiProof in that order.
+func (self *Tree) GetMerkleMultiProof(indices []uint) (*MultiProof, error) {
+	err := self.checkBinary()
+	if err != nil {
+		return nil, err
+	}
+	err = self.checkPromote()
+	if err != nil {
+		return nil, err
+	}
+	leafCount := len(self.leaves())
+	if leafCount == 0 {
+		return nil, errors.New("Tree is empty")
+	}
+	if len(indices) == 0 {
+		return nil, errors.New("no leaf to prove")
+	}
+	known := sortedUniqueIndices(indices)
+	if known[len(known)-1] >= uint(leafCount) {
+		return nil, errors.New("node index is too big for node count")
+	}
+
+	proof := &MultiProof{TreeSize: uint64(leafCount), Indices: known, Hashes: [][]byte{}}
+	for level := self.height() - 1; level > 0; level-- {
+		current := self.levels[level]
+		isKnown := map[uint]bool{}
+		for _, index := range known {
+			isKnown[index] = true
+		}
+		for _, index := range known {
+			sibling := index ^ 1
+			if sibling < uint(len(current)) && !isKnown[sibling] {
+				proof.Hashes = append(proof.Hashes, current[sibling].Hash)
+			}
+		}
+		known = parentIndices(known)
+	}
+	return proof, nil
+}
+
+// VerifyMultiProof checks that the leaves are at the indices of the proof in
+// the tree with the given root. Leaves are ordered as proof.Indices and are
+// stored the way a Tree configured with opts stores them.
+func VerifyMultiProof(leaves [][]byte, proof *MultiProof, root []byte, h hash.Hash, opts TreeOptions) (bool, error) {
+	if len(leaves) == 0 || len(leaves) != len(proof.Indices) {
+		return false, errors.New("leaves do not match the indices of the multiproof")
+	}
+	for i, index := range proof.Indices {
+		if uint64(index) >= proof.TreeSize {
+			return false, errors.New("node index is too big for node count")
+		}
+		if i > 0 && proof.Indices[i-1] >= index {
+			return false, errors.New("indices of the multiproof are not sorted")
+		}
+	}
+
+	known := proof.Indices
+	hashes := map[uint][]byte{}
+	for i, index := range known {
+		leaf, err := leafOf(leaves[i], h, opts)
+		if err != nil {
+			return false, err
+		}
+		hashes[index] = leaf
+	}
+	remaining := proof.Hashes
+	width := proof.TreeSize
+	for width > 1 {
+		parents := map[uint][]byte{}
+		for _, index := range known {
+			if _, ok := parents[index/2]; ok {
+				continue
+			}
+			sibling := index ^ 1
+			if uint64(sibling) >= width {
+				parents[index/2] = hashes[index]
+				continue
+			}
+			siblingHash, ok := hashes[sibling]
+			if !ok {
+				if len(remaining) == 0 {
+					return false, errors.New("multiproof has too few hashes")
+				}
+				siblingHash = remaining[0]
+				remaining = remaining[1:]
+			}
+			data := concatHashes(hashes[index], siblingHash, opts.EnableHashSorting)
+			if index%2 == 1 {
+				data = concatHashes(siblingHash, hashes[index], opts.EnableHashSorting)
+			}
+			node, err := NewNode(h, data)
+			if err != nil {
+				return false, err
+			}
+			parents[index/2] = node.Hash
+		}
+		hashes = parents
+		known = parentIndices(known)
+		width = (width + width%2) / 2
+	}
+	if len(remaining) != 0 {
+		return false, nil
+	}
+	return bytes.Equal(hashes[0], root), nil
+}
+
+// CombineProofs merges the proofs of single leaves of the tree with the given
+// root, generated independently, into a multiproof. The proofs must agree on
+// the hashes they share. The positions of the proof nodes depend on the tree
+// size, which has to be given as well, in a tree which promotes lone nodes.
+// Without the leaves the proofs can not be checked against root, use
+// CombineVerifiedProofs for that.
+func CombineProofs(root []byte, treeSize uint64, proofs map[uint][]ProofNode) (MultiProof, error) {
+	if len(root) == 0 {
+		return MultiProof{}, errors.New("root should not be empty")
+	}
+	if len(proofs) == 0 {
+		return MultiProof{}, errors.New("no leaf to prove")
+	}
+	type position struct {
+		level uint64
+		index uint64
+	}
+	nodes := map[position][]byte{}
+	indices := make([]uint, 0, len(proofs))
+	for index, proof := range proofs {
+		if !matchesProofDirections(proof, uint64(index), treeSize, TreeOptions{}) {
+			return MultiProof{}, fmt.Errorf("proof of leaf %d does not match the tree size", index)
+		}
+
+		i := 0
+		current := uint64(index)
+		for level, width := uint64(0), treeSize; width > 1; level, width = level+1, (width+width%2)/2 {
+			sibling := current ^ 1
+			if sibling < width {
+				pos := position{level, sibling}
+				if hash, ok := nodes[pos]; ok && !bytes.Equal(hash, proof[i].Hash) {
+					return MultiProof{}, fmt.Errorf("proofs disagree on node %d of level %d", sibling, level)
+				}
+				nodes[pos] = proof[i].Hash
+				i++
+			}
+			current = current / 2
+		}
+		indices = append(indices, index)
+	}
+
+	known := sortedUniqueIndices(indices)
+	combined := MultiProof{TreeSize: treeSize, Indices: known, Hashes: [][]byte{}}
+	for level, width := uint64(0), treeSize; width > 1; level, width = level+1, (width+width%2)/2 {
+		isKnown := map[uint]bool{}
+		for _, index := range known {
+			isKnown[index] = true
+		}
+		for _, index := range known {
+			sibling := index ^ 1
+			if uint64(sibling) < width && !isKnown[sibling] {
+				combined.Hashes = append(combined.Hashes, nodes[position{level, uint64(sibling)}])
+			}
+		}
+		known = parentIndices(known)
+	}
+	return combined, nil
+}
+
+// CombineVerifiedProofs merges the proofs as CombineProofs does and checks
+// each proof against root with its leaf, stored the way a Tree configured
+// with opts stores it
+func CombineVerifiedProofs(root []byte, treeSize uint64, leaves map[uint][]byte, proofs map[uint][]ProofNode, h hash.Hash, opts TreeOptions) (MultiProof, error) {
+	err := checkPromoteOptions(opts)
+	if err != nil {
+		return MultiProof{}, err
+	}
+	combined, err := CombineProofs(root, treeSize, proofs)
+	if err != nil {
+		return MultiProof{}, err
+	}
+	for index, proof := range proofs {
+		leafData, ok := leaves[index]
+		if !ok {
+			return MultiProof{}, fmt.Errorf("missing leaf %d", index)
+		}
+		leaf, err := leafOf(leafData, h, opts)
+		if err != nil {
+			return MultiProof{}, err
+		}
+		ok, err = VerifyProof(leaf, proof, root, h, opts)
+		if err != nil {
+			return MultiProof{}, err
+		}
+		if !ok {
+			return MultiProof{}, fmt.Errorf("proof of leaf %d does not verify against the root", index)
+		}
+	}
+	return combined, nil
+}
+
+// OctopusProof is a multiproof whose sibling hashes are in depth-first order:
+// walking down from the root, the left subtree comes before the right one, and
+// the hash of a subtree without proven leaf is given in place of the subtree.
+// A verifier needs a single recursive pass and no intermediate storage.
+type OctopusProof struct {
+	// Number of leaves of the tree
+	TreeSize uint64
+	// Positions of the proven leaves, sorted without duplicates
+	Indices []uint
+	// Roots of the subtrees without proven leaf, in depth-first order
+	Hashes [][]byte
+}
+
+// GetOctopusProof returns the minimal proof of the leaves at indices, in a
+// single walk of the tree from the root. The indices may be unsorted and
+// contain duplicates, the leaves must be given to VerifyOctopusProof in the
+// order of the sorted indices of the returned proof.
+func (self *Tree) GetOctopusProof(indices []uint) (*OctopusProof, error) {
+	err := self.checkBinary()
+	if err != nil {
+		return nil, err
+	}
+	err = self.checkPromote()
+	if err != nil {
+		return nil, err
+	}
+	leafCount := len(self.leaves())
+	if leafCount == 0 {
+		return nil, errors.New("Tree is empty")
+	}
+	if len(indices) == 0 {
+		return nil, errors.New("no leaf to prove")
+	}
+	known := sortedUniqueIndices(indices)
+	if known[len(known)-1] >= uint(leafCount) {
+		return nil, errors.New("node index is too big for node count")
+	}
+
+	proof := &OctopusProof{TreeSize: uint64(leafCount), Indices: known, Hashes: [][]byte{}}
+	self.octopusWalk(0, 0, known, proof)
+	return proof, nil
+}
+
+// VerifyOctopusProof checks that the leaves are at the indices of the proof
+// in the tree with the given root. Leaves are ordered as proof.Indices.
+func VerifyOctopusProof(leaves [][]byte, proof *OctopusProof, root []byte, h hash.Hash, opts TreeOptions) (bool, error) {
+	if len(leaves) == 0 || len(leaves) != len(proof.Indices) {
+		return false, errors.New("leaves do not match the indices of the multiproof")
+	}
+	for i, index := range proof.Indices {
+		if uint64(index) >= proof.TreeSize {
+			return false, errors.New("node index is too big for node count")
+		}
+		if i > 0 && proof.Indices[i-1] >= index {
+			return false, errors.New("indices of the multiproof are not sorted")
+		}
+	}
+
+	// Number of nodes of each level, the first level holds the root
+	widths := []uint64{proof.TreeSize}
+	for width := proof.TreeSize; width > 1; {
+		width = (width + width%2) / 2
+		widths = append([]uint64{width}, widths...)
+	}
+	height := uint64(len(widths))
+	leafHashes := map[uint][]byte{}
+	for i, index := range proof.Indices {
+		leaf, err := leafOf(leaves[i], h, opts)
+		if err != nil {
+			return false, err
+		}
+		leafHashes[index] = leaf
+	}
+
+	remaining := proof.Hashes
+	var compute func(level uint64, index uint, known []uint) ([]byte, error)
+	compute = func(level uint64, index uint, known []uint) ([]byte, error) {
+		if len(known) == 0 {
+			if len(remaining) == 0 {
+				return nil, errors.New("multiproof has too few hashes")
+			}
+			hash := remaining[0]
+			remaining = remaining[1:]
+			return hash, nil
+		}
+		if level == height-1 {
+			return leafHashes[known[0]], nil
+		}
+		left := 2 * index
+		if uint64(left+1) >= widths[level+1] {
+			return compute(level+1, left, known)
+		}
+		split := splitKnown(known, left+1, height-level-2)
+		leftHash, err := compute(level+1, left, known[:split])
+		if err != nil {
+			return nil, err
+		}
+		rightHash, err := compute(level+1, left+1, known[split:])
+		if err != nil {
+			return nil, err
+		}
+		node, err := NewNode(h, concatHashes(leftHash, rightHash, opts.EnableHashSorting))
+		if err != nil {
+			return nil, err
+		}
+		return node.Hash, nil
+	}
+
+	computed, err := compute(0, 0, proof.Indices)
+	if err != nil {
+		return false, err
+	}
+	if len(remaining) != 0 {
+		return false, nil
+	}
+	return bytes.Equal(computed, root), nil
+}
+
+// RangeProof proves the contiguous leaves [Start, End) of a Tree. Only the
+// siblings on the left and right borders of the range are needed.
+type RangeProof struct {
+	TreeSize uint64
+	Start    uint
+	End      uint
+	Hashes   [][]byte
+}
+
+// GetRangeProof returns the proof of the leaves [start, end)
+func (self *Tree) GetRangeProof(start, end uint) (*RangeProof, error) {
+	err := self.checkBinary()
+	if err != nil {
+		return nil, err
+	}
+	err = self.checkPromote()
+	if err != nil {
+		return nil, err
+	}
+	if start >= end {
+		return nil, errors.New("range is empty")
+	}
+	if end > uint(len(self.leaves())) {
+		return nil, errors.New("node index is too big for node count")
+	}
+	indices := make([]uint, 0, end-start)
+	for i := start; i < end; i++ {
+		indices = append(indices, i)
+	}
+	proof, err := self.GetMerkleMultiProof(indices)
+	if err != nil {
+		return nil, err
+	}
+	return &RangeProof{TreeSize: proof.TreeSize, Start: start, End: end, Hashes: proof.Hashes}, nil
+}
+
+// VerifyRangeProof checks that leaves are the leaves [proof.Start, proof.End)
+// of the tree with the given root, stored the way a Tree configured with opts
+// stores them
+func VerifyRangeProof(leaves [][]byte, proof *RangeProof, root []byte, h hash.Hash, opts TreeOptions) (bool, error) {
+	if proof.Start >= proof.End {
+		return false, errors.New("range is empty")
+	}
+	if uint64(proof.End) > proof.TreeSize {
+		return false, errors.New("node index is too big for node count")
+	}
+	if uint64(len(leaves)) != uint64(proof.End-proof.Start) {
+		return false, errors.New("leaves do not match the range of the proof")
+	}
+	indices := make([]uint, 0, proof.End-proof.Start)
+	for i := proof.Start; i < proof.End; i++ {
+		indices = append(indices, i)
+	}
+	return VerifyMultiProof(leaves, &MultiProof{TreeSize: proof.TreeSize, Indices: indices, Hashes: proof.Hashes}, root, h, opts)
+}
+
+// SMTMultiProof proves several leaves of a binary SMT at once. Siblings that
+// are empty subtrees are not sent, the verifier computes them from the empty
+// leaf hash.
+type SMTMultiProof struct {
+	// Number of leaves of the tree, empty or not
+	TotalSize uint64
+	// Number of non empty leaves, which are on the left of the tree
+	NonEmptyLeaves uint64
+	// Positions of the proven leaves, sorted without duplicates
+	Indices []uint
+	// Non empty sibling hashes needed to compute the root, from the leaves
+	// level up and from left to right within a level
+	Hashes [][]byte
+}
+
+// GetMerkleMultiProof returns a multiproof of the leaves at indices, which
+// may be empty leaves. The indices are sorted and deduplicated in the
+// returned proof, the leaves must be given to VerifySMTMultiProof in that
+// order.
+func (self *SMT) GetMerkleMultiProof(indices []uint) (*SMTMultiProof, error) {
+	if len(self.fullNodes) == 0 {
+		return nil, errors.New("SMT tree is not filled")
+	}
+	if self.arity != 2 {
+		return nil, errors.New("Binary proofs need an arity of 2, use GetKaryMerkleProof")
+	}
+	if len(indices) == 0 {
+		return nil, errors.New("no leaf to prove")
+	}
+	totalSize := uint(1) << uint(self.treeHeight-1)
+	known := sortedUniqueIndices(indices)
+	if known[len(known)-1] >= totalSize {
+		return nil, errors.New("Leaf index is out of bounds")
+	}
+
+	proof := &SMTMultiProof{TotalSize: uint64(totalSize), NonEmptyLeaves: uint64(self.countOfNonEmptyLeaves), Indices: known, Hashes: [][]byte{}}
+	for level := 0; level < self.treeHeight-1; level++ {
+		width := uint(self.levelWidth(level))
+		isKnown := map[uint]bool{}
+		for _, index := range known {
+			isKnown[index] = true
+		}
+		for _, index := range known {
+			sibling := index ^ 1
+			if !isKnown[sibling] && sibling < width {
+				proof.Hashes = append(proof.Hashes, self.node(level, int(sibling)))
+			}
+		}
+		known = parentIndices(known)
+	}
+	return proof, nil
+}
+
+// VerifySMTMultiProof checks that the leaves are at the indices of the proof
+// in the SMT with the given root. Leaves are ordered as proof.Indices, a nil
+// leaf stands for an empty leaf.
+func VerifySMTMultiProof(leaves [][]byte, proof *SMTMultiProof, root []byte, emptyHash Hash, hashFunc hash.Hash) (bool, error) {
+	if !isPowerOfTwo(proof.TotalSize) {
+		return false, errors.New("Leaves number of SMT tree should be power of 2")
+	}
+	if proof.NonEmptyLeaves > proof.TotalSize {
+		return false, errors.New("NonEmptyLeaves is bigger than totalSize")
+	}
+	if len(leaves) == 0 || len(leaves) != len(proof.Indices) {
+		return false, errors.New("leaves do not match the indices of the multiproof")
+	}
+	for i, index := range proof.Indices {
+		if uint64(index) >= proof.TotalSize {
+			return false, errors.New("Leaf index is out of bounds")
+		}
+		if i > 0 && proof.Indices[i-1] >= index {
+			return false, errors.New("indices of the multiproof are not sorted")
+		}
+	}
+
+	known := proof.Indices
+	hashes := map[uint][]byte{}
+	for i, index := range known {
+		hashes[index] = leaves[i]
+		if leaves[i] == nil {
+			hashes[index] = emptyHash
+		}
+	}
+	remaining := proof.Hashes
+	empty := []byte(emptyHash)
+	for level := uint(0); proof.TotalSize>>level > 1; level++ {
+		parents := map[uint][]byte{}
+		for _, index := range known {
+			if _, ok := parents[index/2]; ok {
+				continue
+			}
+			sibling := index ^ 1
+			siblingHash, ok := hashes[sibling]
+			if !ok && uint64(sibling)<<level >= proof.NonEmptyLeaves {
+				siblingHash = empty
+			} else if !ok {
+				if len(remaining) == 0 {
+					return false, errors.New("multiproof has too few hashes")
+				}
+				siblingHash = remaining[0]
+				remaining = remaining[1:]
+			}
+			data := concatHashes(hashes[index], siblingHash, false)
+			if index%2 == 1 {
+				data = concatHashes(siblingHash, hashes[index], false)
+			}
+			node, err := NewNode(hashFunc, data)
+			if err != nil {
+				return false, err
+			}
+			parents[index/2] = node.Hash
+		}
+		node, err := NewNode(hashFunc, concatHashes(empty, empty, false))
+		if err != nil {
+			return false, err
+		}
+		empty = node.Hash
+		hashes = parents
+		known = parentIndices(known)
+	}
+	if len(remaining) != 0 {
+		return false, nil
+	}
+	return bytes.Equal(hashes[0], root), nil
+}
+
+// Following are non public
+
+// Returns the indices sorted in increasing order without duplicates
+func sortedUniqueIndices(indices []uint) []uint {
+	sorted := make([]uint, len(indices))
+	copy(sorted, indices)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	unique := sorted[:0]
+	for i, index := range sorted {
+		if i == 0 || index != sorted[i-1] {
+			unique = append(unique, index)
+		}
+	}
+	return unique
+}
+
+// Appends to the proof the siblings needed to prove the known leaves under
+// the node at index of level, known being sorted
+func (self *Tree) octopusWalk(level uint64, index uint, known []uint, proof *OctopusProof) {
+	if len(known) == 0 {
+		proof.Hashes = append(proof.Hashes, self.levels[level][index].Hash)
+		return
+	}
+	height := self.height()
+	if level == height-1 {
+		return
+	}
+	left := 2 * index
+	if left+1 >= uint(len(self.levels[level+1])) {
+		// A lone node is promoted to its parent unchanged
+		self.octopusWalk(level+1, left, known, proof)
+		return
+	}
+	split := splitKnown(known, left+1, height-level-2)
+	self.octopusWalk(level+1, left, known[:split], proof)
+	self.octopusWalk(level+1, left+1, known[split:], proof)
+}
+
+// Returns the number of sorted leaf indices that are before the node at index
+// of a level with the given distance to the leaves
+func splitKnown(known []uint, index uint, toLeaves uint64) int {
+	first := index << toLeaves
+	return sort.Search(len(known), func(i int) bool { return known[i] >= first })
+}
+
+// Returns the sorted indices of the parents of sorted node indices
+func parentIndices(indices []uint) []uint {
+	parents := []uint{}
+	for _, index := range indices {
+		if len(parents) == 0 || parents[len(parents)-1] != index/2 {
+			parents = append(parents, index/2)
+		}
+	}
+	return parents
+}
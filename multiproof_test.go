@@ -0,0 +1,352 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetMerkleMultiProof(t *testing.T) {
+	options := []TreeOptions{
+		{},
+		{EnableHashSorting: true},
+	}
+	for _, opts := range options {
+		h := sha256.New()
+		data := createDummyTreeData(11, h.Size(), true)
+		tree := NewTreeWithOpts(h, opts)
+		err := tree.Generate(data, 0)
+		assert.Nil(t, err)
+
+		inputs := [][]uint{
+			{0},
+			{10},
+			{0, 1},
+			{3, 1, 3},
+			{0, 5, 10},
+			{8, 9, 10},
+			{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+		}
+		for _, indices := range inputs {
+			proof, err := tree.GetMerkleMultiProof(indices)
+			assert.Nil(t, err)
+			leaves := [][]byte{}
+			for _, index := range proof.Indices {
+				leaves = append(leaves, data[index])
+			}
+			ok, err := VerifyMultiProof(leaves, proof, tree.RootHash(), h, opts)
+			assert.Nil(t, err)
+			assert.True(t, ok, fmt.Sprintf("VerifyMultiProof(%v) with %+v", indices, opts))
+
+			// a wrong leaf does not verify
+			leaves[0] = data[(proof.Indices[0]+1)%11]
+			ok, err = VerifyMultiProof(leaves, proof, tree.RootHash(), h, opts)
+			assert.Nil(t, err)
+			assert.False(t, ok)
+		}
+	}
+}
+
+func TestGetMerkleMultiProofSharesHashes(t *testing.T) {
+	h := sha256.New()
+	data := createDummyTreeData(8, h.Size(), true)
+	tree := NewTree(h)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+
+	// siblings of each other, both paths share all upper nodes
+	proof, err := tree.GetMerkleMultiProof([]uint{2, 3})
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{tree.levels[2][0].Hash, tree.levels[1][1].Hash}, proof.Hashes)
+
+	// the whole tree needs no hash
+	proof, err = tree.GetMerkleMultiProof([]uint{0, 1, 2, 3, 4, 5, 6, 7})
+	assert.Nil(t, err)
+	assert.Len(t, proof.Hashes, 0)
+
+	// one hash is missing, or one is extra
+	proof, err = tree.GetMerkleMultiProof([]uint{0, 6})
+	assert.Nil(t, err)
+	assert.Len(t, proof.Hashes, 4)
+	leaves := [][]byte{data[0], data[6]}
+	full := proof.Hashes
+	proof.Hashes = full[:3]
+	_, err = VerifyMultiProof(leaves, proof, tree.RootHash(), h, tree.opts)
+	assert.Equal(t, "multiproof has too few hashes", err.Error())
+	proof.Hashes = append(full, data[1])
+	ok, err := VerifyMultiProof(leaves, proof, tree.RootHash(), h, tree.opts)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestGetMerkleMultiProofInvalidArgument(t *testing.T) {
+	h := sha256.New()
+	tree := NewTree(h)
+	_, err := tree.GetMerkleMultiProof([]uint{0})
+	assert.Equal(t, "Tree is empty", err.Error())
+
+	data := createDummyTreeData(4, h.Size(), true)
+	err = tree.Generate(data, 0)
+	assert.Nil(t, err)
+	_, err = tree.GetMerkleMultiProof(nil)
+	assert.Equal(t, "no leaf to prove", err.Error())
+	_, err = tree.GetMerkleMultiProof([]uint{1, 4})
+	assert.Equal(t, "node index is too big for node count", err.Error())
+
+	proof, err := tree.GetMerkleMultiProof([]uint{1, 2})
+	assert.Nil(t, err)
+	_, err = VerifyMultiProof(data[:1], proof, tree.RootHash(), h, tree.opts)
+	assert.Equal(t, "leaves do not match the indices of the multiproof", err.Error())
+	proof.Indices = []uint{2, 1}
+	_, err = VerifyMultiProof(data[:2], proof, tree.RootHash(), h, tree.opts)
+	assert.Equal(t, "indices of the multiproof are not sorted", err.Error())
+	proof.Indices = []uint{1, 4}
+	_, err = VerifyMultiProof(data[:2], proof, tree.RootHash(), h, tree.opts)
+	assert.Equal(t, "node index is too big for node count", err.Error())
+}
+
+func TestSMTGetMerkleMultiProof(t *testing.T) {
+	hash := hashFunc
+	tree := NewSMT(emptyHash, hash)
+	_, err := tree.GetMerkleMultiProof([]uint{0})
+	assert.Equal(t, "SMT tree is not filled", err.Error())
+
+	err = tree.Generate(testHashes[:5], 16)
+	assert.Nil(t, err)
+
+	inputs := [][]uint{
+		{0},
+		{4},
+		{15},
+		{1, 3},
+		{0, 4, 9},
+		{4, 5, 6, 7},
+		{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	}
+	for _, indices := range inputs {
+		proof, err := tree.GetMerkleMultiProof(indices)
+		assert.Nil(t, err)
+		leaves := [][]byte{}
+		for _, index := range proof.Indices {
+			if index < 5 {
+				leaves = append(leaves, testHashes[index])
+			} else {
+				leaves = append(leaves, nil)
+			}
+		}
+		ok, err := VerifySMTMultiProof(leaves, proof, tree.RootHash(), emptyHash, hash)
+		assert.Nil(t, err)
+		assert.True(t, ok, fmt.Sprintf("VerifySMTMultiProof(%v)", indices))
+
+		leaves[0] = testHashes[10]
+		ok, err = VerifySMTMultiProof(leaves, proof, tree.RootHash(), emptyHash, hash)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	}
+
+	// empty siblings are not sent: the proof of leaf 4 only holds the node
+	// over leaves 0 to 3, where a single proof has 4 nodes
+	proof, err := tree.GetMerkleMultiProof([]uint{4})
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{tree.fullNodes[2][0]}, proof.Hashes)
+
+	_, err = tree.GetMerkleMultiProof([]uint{16})
+	assert.Equal(t, "Leaf index is out of bounds", err.Error())
+	_, err = tree.GetMerkleMultiProof(nil)
+	assert.Equal(t, "no leaf to prove", err.Error())
+	proof.Hashes = nil
+	_, err = VerifySMTMultiProof([][]byte{testHashes[4]}, proof, tree.RootHash(), emptyHash, hash)
+	assert.Equal(t, "multiproof has too few hashes", err.Error())
+	proof.TotalSize = 12
+	_, err = VerifySMTMultiProof([][]byte{testHashes[4]}, proof, tree.RootHash(), emptyHash, hash)
+	assert.Equal(t, "Leaves number of SMT tree should be power of 2", err.Error())
+}
+
+func TestGetRangeProof(t *testing.T) {
+	h := sha256.New()
+	data := createDummyTreeData(13, h.Size(), true)
+	tree := NewTree(h)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+
+	for start := uint(0); start < 13; start++ {
+		for end := start + 1; end <= 13; end++ {
+			proof, err := tree.GetRangeProof(start, end)
+			assert.Nil(t, err)
+			ok, err := VerifyRangeProof(data[start:end], proof, tree.RootHash(), h, tree.opts)
+			assert.Nil(t, err)
+			assert.True(t, ok, fmt.Sprintf("VerifyRangeProof(%d, %d)", start, end))
+
+			// a range shifted by one does not verify
+			if end < 13 {
+				ok, err = VerifyRangeProof(data[start+1:end+1], proof, tree.RootHash(), h, tree.opts)
+				assert.Nil(t, err)
+				assert.False(t, ok)
+			}
+		}
+	}
+
+	// an aligned range only needs the siblings of its subtree
+	proof, err := tree.GetRangeProof(4, 8)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{tree.levels[2][0].Hash, tree.levels[1][1].Hash}, proof.Hashes)
+
+	_, err = tree.GetRangeProof(3, 3)
+	assert.Equal(t, "range is empty", err.Error())
+	_, err = tree.GetRangeProof(12, 14)
+	assert.Equal(t, "node index is too big for node count", err.Error())
+	_, err = tree.GetRangeProof(0, 1<<62)
+	assert.Equal(t, "node index is too big for node count", err.Error())
+	_, err = VerifyRangeProof(data[4:7], proof, tree.RootHash(), h, tree.opts)
+	assert.Equal(t, "leaves do not match the range of the proof", err.Error())
+	proof.End = 1 << 62
+	_, err = VerifyRangeProof(data[4:8], proof, tree.RootHash(), h, tree.opts)
+	assert.Equal(t, "node index is too big for node count", err.Error())
+	proof.End = proof.Start
+	_, err = VerifyRangeProof(nil, proof, tree.RootHash(), h, tree.opts)
+	assert.Equal(t, "range is empty", err.Error())
+}
+
+func TestCombineProofs(t *testing.T) {
+	h := sha256.New()
+	data := createDummyTreeData(11, h.Size(), true)
+	tree := NewTree(h)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+
+	for _, indices := range [][]uint{{0}, {10}, {2, 3}, {0, 5, 10}, {9, 1, 4, 6}} {
+		leaves := map[uint][]byte{}
+		proofs := map[uint][]ProofNode{}
+		for _, index := range indices {
+			proof, err := tree.GetMerkleProof(index)
+			assert.Nil(t, err)
+			proofs[index] = proof
+			leaves[index] = data[index]
+		}
+		combined, err := CombineProofs(tree.RootHash(), 11, proofs)
+		assert.Nil(t, err)
+
+		expected, err := tree.GetMerkleMultiProof(indices)
+		assert.Nil(t, err)
+		assert.Equal(t, *expected, combined)
+		verified, err := CombineVerifiedProofs(tree.RootHash(), 11, leaves, proofs, h, tree.opts)
+		assert.Nil(t, err)
+		assert.Equal(t, combined, verified)
+	}
+}
+
+func TestCombineProofsInconsistent(t *testing.T) {
+	h := sha256.New()
+	data := createDummyTreeData(8, h.Size(), true)
+	tree := NewTree(h)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+	other := NewTree(h)
+	err = other.Generate(createDummyTreeData(8, h.Size(), true), 0)
+	assert.Nil(t, err)
+
+	proof0, err := tree.GetMerkleProof(0)
+	assert.Nil(t, err)
+	proof5, err := tree.GetMerkleProof(5)
+	assert.Nil(t, err)
+	leaves := map[uint][]byte{0: data[0], 5: data[5]}
+
+	_, err = CombineProofs(tree.RootHash(), 8, map[uint][]ProofNode{0: proof0, 5: proof0})
+	assert.Equal(t, "proof of leaf 5 does not match the tree size", err.Error())
+	_, err = CombineProofs(tree.RootHash(), 8, nil)
+	assert.Equal(t, "no leaf to prove", err.Error())
+	_, err = CombineProofs(nil, 8, map[uint][]ProofNode{0: proof0})
+	assert.Equal(t, "root should not be empty", err.Error())
+	// proofs of leaves 0 and 1 share the siblings of levels 1 and 2
+	proof1, err := tree.GetMerkleProof(1)
+	assert.Nil(t, err)
+	proof1[2] = ProofNode{Left: proof1[2].Left, Hash: data[7]}
+	_, err = CombineProofs(tree.RootHash(), 8, map[uint][]ProofNode{0: proof0, 1: proof1})
+	assert.Equal(t, "proofs disagree on node 1 of level 2", err.Error())
+
+	_, err = CombineVerifiedProofs(tree.RootHash(), 8, map[uint][]byte{0: data[0]}, map[uint][]ProofNode{0: proof0, 5: proof5}, h, tree.opts)
+	assert.Equal(t, "missing leaf 5", err.Error())
+	_, err = CombineVerifiedProofs(other.RootHash(), 8, leaves, map[uint][]ProofNode{0: proof0}, h, tree.opts)
+	assert.Equal(t, "proof of leaf 0 does not verify against the root", err.Error())
+}
+
+func TestGetOctopusProof(t *testing.T) {
+	h := sha256.New()
+	for _, count := range []int{1, 2, 5, 8, 11} {
+		data := createDummyTreeData(count, h.Size(), true)
+		tree := NewTree(h)
+		err := tree.Generate(data, 0)
+		assert.Nil(t, err)
+
+		subsets := [][]uint{{0}, {uint(count - 1), 0}, {uint(count / 2), 0, uint(count / 2)}}
+		all := []uint{}
+		for i := count - 1; i >= 0; i-- {
+			all = append(all, uint(i))
+		}
+		subsets = append(subsets, all)
+		for _, indices := range subsets {
+			proof, err := tree.GetOctopusProof(indices)
+			assert.Nil(t, err)
+			multiProof, err := tree.GetMerkleMultiProof(indices)
+			assert.Nil(t, err)
+			assert.Equal(t, multiProof.Indices, proof.Indices)
+			assert.Len(t, proof.Hashes, len(multiProof.Hashes))
+
+			leaves := [][]byte{}
+			for _, index := range proof.Indices {
+				leaves = append(leaves, data[index])
+			}
+			ok, err := VerifyOctopusProof(leaves, proof, tree.RootHash(), h, tree.opts)
+			assert.Nil(t, err)
+			assert.True(t, ok)
+
+			leaves[0] = hashValue(leaves[0], h)
+			ok, err = VerifyOctopusProof(leaves, proof, tree.RootHash(), h, tree.opts)
+			assert.Nil(t, err)
+			assert.False(t, ok)
+		}
+	}
+}
+
+func TestGetOctopusProofOrder(t *testing.T) {
+	h := sha256.New()
+	data := createDummyTreeData(8, h.Size(), true)
+	tree := NewTree(h)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+
+	proof, err := tree.GetOctopusProof([]uint{5, 0})
+	assert.Nil(t, err)
+	assert.Equal(t, []uint{0, 5}, proof.Indices)
+	// Depth-first: leaf 1, node 1 of level 2, leaf 4, node 3 of level 2
+	assert.Equal(t, [][]byte{data[1], tree.levels[2][1].Hash, data[4], tree.levels[2][3].Hash}, proof.Hashes)
+}
+
+func TestGetOctopusProofInvalidArgument(t *testing.T) {
+	h := sha256.New()
+	data := createDummyTreeData(5, h.Size(), true)
+	tree := NewTree(h)
+	_, err := tree.GetOctopusProof([]uint{0})
+	assert.Equal(t, "Tree is empty", err.Error())
+	err = tree.Generate(data, 0)
+	assert.Nil(t, err)
+	_, err = tree.GetOctopusProof(nil)
+	assert.Equal(t, "no leaf to prove", err.Error())
+	_, err = tree.GetOctopusProof([]uint{5})
+	assert.Equal(t, "node index is too big for node count", err.Error())
+
+	proof, err := tree.GetOctopusProof([]uint{1, 3})
+	assert.Nil(t, err)
+	_, err = VerifyOctopusProof([][]byte{data[1]}, proof, tree.RootHash(), h, tree.opts)
+	assert.Equal(t, "leaves do not match the indices of the multiproof", err.Error())
+	_, err = VerifyOctopusProof([][]byte{data[1], data[3]}, &OctopusProof{TreeSize: 5, Indices: []uint{1, 3}}, tree.RootHash(), h, tree.opts)
+	assert.Equal(t, "multiproof has too few hashes", err.Error())
+	_, err = VerifyOctopusProof([][]byte{data[3], data[1]}, &OctopusProof{TreeSize: 5, Indices: []uint{3, 1}}, tree.RootHash(), h, tree.opts)
+	assert.Equal(t, "indices of the multiproof are not sorted", err.Error())
+	proof.Hashes = append(proof.Hashes, data[0])
+	ok, err := VerifyOctopusProof([][]byte{data[1], data[3]}, proof, tree.RootHash(), h, tree.opts)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
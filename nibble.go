@@ -0,0 +1,166 @@
+package merkle
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+)
+
+const nibbleArity = 16
+
+// NibblePathTree is a sparse 16-ary Merkle tree indexed by the nibbles of
+// fixed length keys, as the radix layout of a Merkle-Patricia trie. Empty
+// subtrees are never stored, their hashes are cached per height like in SMT.
+type NibblePathTree struct {
+	// Hashes of the non-empty nodes, keyed by their nibble path
+	nodes             map[string]Hash
+	hashFunc          hash.Hash
+	emptyHash         Hash
+	emptyTreeRootHash []Hash
+	keyLength         int
+}
+
+// NibbleProofNode holds the siblings of a node at one level of a
+// NibblePathTree, ordered by nibble with the proven node left out
+type NibbleProofNode struct {
+	Siblings [][]byte
+}
+
+// NewNibblePathTree creates a tree for keys of keyLength bytes, whose empty
+// leaves hash to emptyHash
+func NewNibblePathTree(keyLength int, emptyHash Hash, hashFunc hash.Hash) (*NibblePathTree, error) {
+	if keyLength <= 0 {
+		return nil, errors.New("Key length should be positive")
+	}
+	tree := &NibblePathTree{nodes: map[string]Hash{}, hashFunc: hashFunc, emptyHash: emptyHash, keyLength: keyLength}
+	emptyTreeRootHash, err := computeNibbleEmptyTreeRootHash(emptyHash, 2*keyLength, hashFunc)
+	if err != nil {
+		return nil, err
+	}
+	tree.emptyTreeRootHash = emptyTreeRootHash
+	return tree, nil
+}
+
+// Insert stores value as the leaf of key and updates the nodes on its path.
+// The tree is left unchanged on error.
+func (self *NibblePathTree) Insert(key, value []byte) error {
+	if len(key) != self.keyLength {
+		return errors.New("Key length mismatch")
+	}
+	path := keyToNibbles(key)
+	hashes := make([]Hash, len(path)+1)
+	hashes[len(path)] = value
+	for depth := len(path) - 1; depth >= 0; depth-- {
+		children := self.childrenHashes(path[:depth])
+		children[path[depth]] = hashes[depth+1]
+		hash, err := hashConcat(self.hashFunc, children...)
+		if err != nil {
+			return err
+		}
+		hashes[depth] = hash
+	}
+	for depth, hash := range hashes {
+		self.nodes[string(path[:depth])] = hash
+	}
+	return nil
+}
+
+// Root returns the root hash of the tree
+func (self *NibblePathTree) Root() []byte {
+	return self.nodeHash([]byte{})
+}
+
+// Prove returns the siblings on the path of key, from the leaf up to the root.
+// Keys that were never inserted are proven against the empty leaf hash.
+func (self *NibblePathTree) Prove(key []byte) ([]NibbleProofNode, error) {
+	if len(key) != self.keyLength {
+		return nil, errors.New("Key length mismatch")
+	}
+	path := keyToNibbles(key)
+	proof := []NibbleProofNode{}
+	for depth := len(path) - 1; depth >= 0; depth-- {
+		children := self.childrenHashes(path[:depth])
+		siblings := make([][]byte, 0, nibbleArity-1)
+		for i, child := range children {
+			if byte(i) != path[depth] {
+				siblings = append(siblings, child)
+			}
+		}
+		proof = append(proof, NibbleProofNode{Siblings: siblings})
+	}
+	return proof, nil
+}
+
+// VerifyNibbleProof checks that leaf is stored under key in the tree with the
+// given root. Non-membership is proven by passing the empty leaf hash. A
+// proof of the wrong shape does not verify.
+func VerifyNibbleProof(key, leaf []byte, proof []NibbleProofNode, root []byte, hashFunc hash.Hash) (bool, error) {
+	path := keyToNibbles(key)
+	if len(proof) != len(path) {
+		return false, nil
+	}
+	current := leaf
+	for i, node := range proof {
+		if len(node.Siblings) != nibbleArity-1 {
+			return false, nil
+		}
+		position := int(path[len(path)-1-i])
+		children := make([][]byte, 0, nibbleArity)
+		children = append(children, node.Siblings[:position]...)
+		children = append(children, current)
+		children = append(children, node.Siblings[position:]...)
+		hash, err := hashConcat(hashFunc, children...)
+		if err != nil {
+			return false, err
+		}
+		current = hash
+	}
+	return bytes.Equal(current, root), nil
+}
+
+// Following are non public function
+
+// Returns the hash of the node at path, falling back to the cached empty
+// subtree hash of its height
+func (self *NibblePathTree) nodeHash(path []byte) []byte {
+	if hash, ok := self.nodes[string(path)]; ok {
+		return hash
+	}
+	return self.emptyTreeRootHash[2*self.keyLength-len(path)]
+}
+
+func (self *NibblePathTree) childrenHashes(path []byte) [][]byte {
+	children := make([][]byte, nibbleArity)
+	childPath := make([]byte, len(path)+1)
+	copy(childPath, path)
+	for i := range children {
+		childPath[len(path)] = byte(i)
+		children[i] = self.nodeHash(childPath)
+	}
+	return children
+}
+
+func computeNibbleEmptyTreeRootHash(emptyHash Hash, height int, hashFunc hash.Hash) ([]Hash, error) {
+	hashes := []Hash{emptyHash}
+	children := make([][]byte, nibbleArity)
+	for i := 0; i < height; i++ {
+		for j := range children {
+			children[j] = hashes[i]
+		}
+		hash, err := hashConcat(hashFunc, children...)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+// Splits every byte of key into its high and low nibble
+func keyToNibbles(key []byte) []byte {
+	nibbles := make([]byte, 0, 2*len(key))
+	for _, b := range key {
+		nibbles = append(nibbles, b>>4, b&0x0F)
+	}
+	return nibbles
+}
@@ -0,0 +1,153 @@
+package merkle
+
+import (
+	"crypto/md5"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyToNibbles(t *testing.T) {
+	assert.Equal(t, []byte{0x0A, 0x0B, 0x00, 0x01}, keyToNibbles([]byte{0xAB, 0x01}))
+	assert.Empty(t, keyToNibbles(nil))
+}
+
+func TestNibblePathTreeInvalidArgument(t *testing.T) {
+	_, err := NewNibblePathTree(0, emptyHash, md5.New())
+	assert.Equal(t, "Key length should be positive", err.Error())
+
+	tree, err := NewNibblePathTree(2, emptyHash, md5.New())
+	assert.Nil(t, err)
+	err = tree.Insert([]byte{1}, testHashes[0])
+	assert.Equal(t, "Key length mismatch", err.Error())
+	_, err = tree.Prove([]byte{1, 2, 3})
+	assert.Equal(t, "Key length mismatch", err.Error())
+}
+
+func TestNibblePathTreeEmptyRoot(t *testing.T) {
+	h := md5.New()
+	tree, err := NewNibblePathTree(1, emptyHash, h)
+	assert.Nil(t, err)
+
+	level := make([][]byte, 16)
+	for i := range level {
+		level[i] = emptyHash
+	}
+	expected, err := hashConcat(h, level...)
+	assert.Nil(t, err)
+	for i := range level {
+		level[i] = expected
+	}
+	expected, err = hashConcat(h, level...)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, tree.Root())
+}
+
+func TestNibblePathTreeInsert(t *testing.T) {
+	h := md5.New()
+	tree, err := NewNibblePathTree(1, emptyHash, h)
+	assert.Nil(t, err)
+	err = tree.Insert([]byte{0x12}, testHashes[0])
+	assert.Nil(t, err)
+
+	empty := make([][]byte, 16)
+	for i := range empty {
+		empty[i] = emptyHash
+	}
+	emptySubtree, _ := hashConcat(h, empty...)
+
+	children := make([][]byte, 16)
+	copy(children, empty)
+	children[2] = testHashes[0]
+	subtree, _ := hashConcat(h, children...)
+
+	for i := range children {
+		children[i] = emptySubtree
+	}
+	children[1] = subtree
+	expected, _ := hashConcat(h, children...)
+	assert.Equal(t, expected, tree.Root())
+}
+
+func TestNibblePathTreeInsertOrder(t *testing.T) {
+	keys := [][]byte{{0x00, 0x01}, {0x00, 0x02}, {0xF0, 0x0F}, {0x12, 0x34}}
+	first, _ := NewNibblePathTree(2, emptyHash, md5.New())
+	second, _ := NewNibblePathTree(2, emptyHash, md5.New())
+	for i := range keys {
+		assert.Nil(t, first.Insert(keys[i], testHashes[i]))
+		j := len(keys) - 1 - i
+		assert.Nil(t, second.Insert(keys[j], testHashes[j]))
+	}
+	assert.Equal(t, first.Root(), second.Root())
+}
+
+func TestNibblePathTreeProofs(t *testing.T) {
+	h := md5.New()
+	keys := [][]byte{{0x00, 0x01}, {0x00, 0x02}, {0xF0, 0x0F}, {0x12, 0x34}}
+	tree, err := NewNibblePathTree(2, emptyHash, h)
+	assert.Nil(t, err)
+	for i, key := range keys {
+		assert.Nil(t, tree.Insert(key, testHashes[i]))
+	}
+	root := tree.Root()
+
+	// membership
+	for i, key := range keys {
+		proof, err := tree.Prove(key)
+		assert.Nil(t, err)
+		assert.Len(t, proof, 4)
+		ok, err := VerifyNibbleProof(key, testHashes[i], proof, root, h)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+
+		// a different leaf does not verify
+		ok, err = VerifyNibbleProof(key, testHashes[i+1], proof, root, h)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	}
+
+	// non-membership
+	absent := []byte{0x00, 0x03}
+	proof, err := tree.Prove(absent)
+	assert.Nil(t, err)
+	ok, err := VerifyNibbleProof(absent, emptyHash, proof, root, h)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = VerifyNibbleProof(absent, testHashes[0], proof, root, h)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// a present key cannot be proven absent
+	proof, err = tree.Prove(keys[0])
+	assert.Nil(t, err)
+	ok, err = VerifyNibbleProof(keys[0], emptyHash, proof, root, h)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// malformed proofs
+	ok, err = VerifyNibbleProof(keys[0], testHashes[0], proof[1:], root, h)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	proof[0].Siblings = proof[0].Siblings[1:]
+	ok, err = VerifyNibbleProof(keys[0], testHashes[0], proof, root, h)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestNibblePathTreeHashError(t *testing.T) {
+	hashCount := 0
+	_, err := NewNibblePathTree(1, emptyHash, NewHashCountErrorDecorator(md5.New(), &hashCount, 1))
+	assert.Equal(t, "Hash error", err.Error())
+
+	// a failed insert leaves the tree unchanged, the hash failing after
+	// the parent of the leaf is hashed
+	tree, err := NewNibblePathTree(2, emptyHash, md5.New())
+	assert.Nil(t, err)
+	root := tree.Root()
+	hashCount = 0
+	tree.hashFunc = NewHashCountErrorDecorator(md5.New(), &hashCount, nibbleArity+1)
+	err = tree.Insert([]byte{0x12, 0x34}, testHashes[0])
+	assert.Equal(t, "Hash error", err.Error())
+	assert.Equal(t, root, tree.Root())
+	assert.Equal(t, 0, len(tree.nodes))
+}
@@ -0,0 +1,104 @@
+package merkle
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+)
+
+// PartialTree holds the part of a Tree known from a root and proofs of some
+// of its leaves, as the partial merkle blocks of Bitcoin. Every added proof
+// is checked against the root before its nodes are kept.
+type PartialTree struct {
+	root     []byte
+	treeSize uint64
+	// Number of nodes of each level, where level 0 holds the root
+	widths   []uint64
+	nodes    map[HashPosition][]byte
+	hashFunc hash.Hash
+	opts     TreeOptions
+}
+
+// NewPartialTree creates a partial tree of treeSize leaves with the given
+// root, knowing no node yet but the root
+func NewPartialTree(root []byte, treeSize uint64, hashFunc hash.Hash, opts TreeOptions) (*PartialTree, error) {
+	err := checkPromoteOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if treeSize == 0 {
+		return nil, errors.New("Empty tree")
+	}
+	height := calculateTreeHeight(treeSize)
+	widths := make([]uint64, height)
+	width := treeSize
+	for level := int(height) - 1; level >= 0; level-- {
+		widths[level] = width
+		width = (width + width%2) / 2
+	}
+	nodes := map[HashPosition][]byte{{Level: 0, Index: 0}: root}
+	return &PartialTree{root: root, treeSize: treeSize, widths: widths, nodes: nodes, hashFunc: hashFunc, opts: opts}, nil
+}
+
+// AddProof adds the leaf data at index, its proof and the nodes they imply on
+// its path. The leaf is stored the way a Tree configured with the options of
+// the partial tree stores it. The tree is left unchanged if the proof does not
+// verify.
+func (self *PartialTree) AddProof(index uint, leafData []byte, proof []ProofNode) error {
+	if !matchesProofDirections(proof, uint64(index), self.treeSize, self.opts) {
+		return errors.New("proof does not match the tree size")
+	}
+	leaf, err := leafOf(leafData, self.hashFunc, self.opts)
+	if err != nil {
+		return err
+	}
+
+	leafLevel := uint64(len(self.widths) - 1)
+	found := map[HashPosition][]byte{{Level: leafLevel, Index: int(index)}: leaf}
+	current := leaf
+	position := uint64(index)
+	next := 0
+	for level := leafLevel; level > 0; level-- {
+		sibling := position ^ 1
+		if sibling < self.widths[level] {
+			n := proof[next]
+			next++
+			found[HashPosition{Level: level, Index: int(sibling)}] = n.Hash
+			data := concatHashes(current, n.Hash, self.opts.EnableHashSorting)
+			if n.Left {
+				data = concatHashes(n.Hash, current, self.opts.EnableHashSorting)
+			}
+			node, err := NewNode(self.hashFunc, data)
+			if err != nil {
+				return err
+			}
+			current = node.Hash
+		}
+		position = position / 2
+		found[HashPosition{Level: level - 1, Index: int(position)}] = current
+	}
+	if !bytes.Equal(current, self.root) {
+		return errors.New("proof does not verify against the root")
+	}
+	for p, hash := range found {
+		self.nodes[p] = hash
+	}
+	return nil
+}
+
+// VerifyLeaf returns true if a proof of the leaf data at index was added
+func (self *PartialTree) VerifyLeaf(index uint, leafData []byte) bool {
+	hash, ok := self.nodes[HashPosition{Level: uint64(len(self.widths) - 1), Index: int(index)}]
+	if !ok {
+		return false
+	}
+	leaf, err := leafOf(leafData, self.hashFunc, self.opts)
+	return err == nil && bytes.Equal(hash, leaf)
+}
+
+// SubRoot returns the hash of the node at index of a level, where level 0
+// holds the root, if it is known from the added proofs
+func (self *PartialTree) SubRoot(level uint64, index int) ([]byte, bool) {
+	hash, ok := self.nodes[HashPosition{Level: level, Index: index}]
+	return hash, ok
+}
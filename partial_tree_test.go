@@ -0,0 +1,76 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartialTree(t *testing.T) {
+	h := sha256.New()
+	data := createDummyTreeData(11, h.Size(), true)
+	tree := NewTree(h)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+
+	partial, err := NewPartialTree(tree.RootHash(), 11, h, tree.opts)
+	assert.Nil(t, err)
+	root, ok := partial.SubRoot(0, 0)
+	assert.True(t, ok)
+	assert.Equal(t, tree.RootHash(), root)
+	assert.False(t, partial.VerifyLeaf(2, data[2]))
+
+	for _, index := range []uint{2, 10} {
+		proof, err := tree.GetMerkleProof(index)
+		assert.Nil(t, err)
+		err = partial.AddProof(index, data[index], proof)
+		assert.Nil(t, err)
+		assert.True(t, partial.VerifyLeaf(index, data[index]))
+	}
+	assert.True(t, partial.VerifyLeaf(3, data[3]))
+	assert.False(t, partial.VerifyLeaf(4, data[4]))
+	assert.False(t, partial.VerifyLeaf(2, data[3]))
+
+	// every known node is the node of the tree
+	for level := uint64(0); level < tree.height(); level++ {
+		for i, n := range tree.levels[level] {
+			hash, ok := partial.SubRoot(level, i)
+			if ok {
+				assert.Equal(t, n.Hash, hash)
+			}
+		}
+	}
+	// node over leaves 0 to 3, and the promoted node over leaf 10
+	hash, ok := partial.SubRoot(1, 0)
+	assert.True(t, ok)
+	assert.Equal(t, tree.levels[1][0].Hash, hash)
+	hash, ok = partial.SubRoot(3, 5)
+	assert.True(t, ok)
+	assert.Equal(t, data[10], hash)
+	_, ok = partial.SubRoot(3, 2)
+	assert.False(t, ok)
+}
+
+func TestPartialTreeRejectsProof(t *testing.T) {
+	h := sha256.New()
+	data := createDummyTreeData(8, h.Size(), true)
+	tree := NewTree(h)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+	partial, err := NewPartialTree(tree.RootHash(), 8, h, tree.opts)
+	assert.Nil(t, err)
+
+	proof, err := tree.GetMerkleProof(1)
+	assert.Nil(t, err)
+	err = partial.AddProof(1, data[2], proof)
+	assert.Equal(t, "proof does not verify against the root", err.Error())
+	assert.False(t, partial.VerifyLeaf(1, data[1]))
+	_, ok := partial.SubRoot(2, 0)
+	assert.False(t, ok)
+
+	err = partial.AddProof(1, data[1], proof[:2])
+	assert.Equal(t, "proof does not match the tree size", err.Error())
+	_, err = NewPartialTree(tree.RootHash(), 0, h, tree.opts)
+	assert.Equal(t, "Empty tree", err.Error())
+}
@@ -0,0 +1,342 @@
+package merkle
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"math"
+	"strings"
+)
+
+// VerifyInclusion checks that the leaf data at index is part of a tree of
+// treeSize leaves with the given root. The leaf is stored the way a Tree
+// configured with opts stores it, and the Left flags of the proof must match
+// the path of index in a tree of that size.
+func VerifyInclusion(leafData []byte, index uint, treeSize uint64, proof []ProofNode, root []byte, h hash.Hash, opts TreeOptions) bool {
+	leaf, err := leafOf(leafData, h, opts)
+	if err != nil {
+		return false
+	}
+	return verifyLeafInclusion(leaf, index, treeSize, proof, root, h, opts)
+}
+
+// VerifyProofWithLeafCheck verifies the inclusion of the leaf data as
+// VerifyInclusion does and runs check over the leaf data, for formats where
+// each leaf carries its own checksum. Both results are returned separately.
+// A nil check always passes.
+func VerifyProofWithLeafCheck(leafData []byte, check func([]byte) bool, index uint, treeSize uint64, proof []ProofNode, root []byte, h hash.Hash, opts TreeOptions) (bool, bool) {
+	proofValid := VerifyInclusion(leafData, index, treeSize, proof, root, h, opts)
+	leafCheckPassed := check == nil || check(leafData)
+	return proofValid, leafCheckPassed
+}
+
+// Verify checks that the leaf data is at the index of the proof in the tree
+// with the given root. The leaf is stored the way a Tree configured with opts
+// stores it, and the nodes must match the path of the leaf in a tree of the
+// recorded size and height.
+func (self *Proof) Verify(leafData, root []byte, h hash.Hash, opts TreeOptions) (bool, error) {
+	if self.LeafCount == 0 {
+		return false, errors.New("Empty tree")
+	}
+	if calculateTreeHeight(self.LeafCount) != self.TreeHeight {
+		return false, errors.New("tree height does not match the leaf count")
+	}
+	if !matchesProofDirections(self.Nodes, uint64(self.LeafIndex), self.LeafCount, opts) {
+		return false, nil
+	}
+	leaf, err := leafOf(leafData, h, opts)
+	if err != nil {
+		return false, err
+	}
+	return VerifyProof(leaf, self.Nodes, root, h, opts)
+}
+
+// VerifyProofAnyLeaf returns the position of the first candidate leaf hash
+// that the proof of index folds into root, without telling the verifier which
+// one is expected. It returns -1 and false when no candidate matches.
+func VerifyProofAnyLeaf(candidateLeafHashes [][]byte, proof []ProofNode, index uint, treeSize uint64, root []byte, h hash.Hash, opts TreeOptions) (int, bool) {
+	if !matchesProofDirections(proof, uint64(index), treeSize, opts) {
+		return -1, false
+	}
+	for i, candidate := range candidateLeafHashes {
+		ok, err := VerifyProof(candidate, proof, root, h, opts)
+		if err != nil {
+			return -1, false
+		}
+		if ok {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// IsMinimalProof returns true if the proof has exactly one node per level
+// where the leaf at index has a sibling in a tree of treeSize leaves. Levels
+// where the node is promoted do not add to the expected length.
+func IsMinimalProof(proof []ProofNode, index uint, treeSize uint64) bool {
+	directions, err := proofDirections(uint64(index), treeSize)
+	if err != nil {
+		return false
+	}
+	return len(proof) == len(directions)
+}
+
+// ImpliedTreeSizeRange returns the smallest and largest tree sizes in which
+// the proof of the leaf at index has as many nodes as proof. As a bigger tree
+// never shortens the path of a leaf, the consistent sizes form a range. Both
+// bounds are 0 when no size fits.
+func ImpliedTreeSizeRange(proof []ProofNode, index uint) (min, max uint64) {
+	if uint64(index) == math.MaxUint64 {
+		return 0, 0
+	}
+	min, ok := smallestSizeWithProofLength(uint64(index), len(proof))
+	if !ok || proofLength(uint64(index), min) != len(proof) {
+		return 0, 0
+	}
+	next, ok := smallestSizeWithProofLength(uint64(index), len(proof)+1)
+	if !ok {
+		return min, math.MaxUint64
+	}
+	return min, next - 1
+}
+
+// VerifyProof folds the proof onto the leaf hash and compares the result
+// with root
+func VerifyProof(leafHash []byte, proof []ProofNode, root []byte, h hash.Hash, opts TreeOptions) (bool, error) {
+	computed, err := rootFromProof(leafHash, proof, h, opts)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(computed, root), nil
+}
+
+// VerifyMarkedProof folds a proof returned by GetMerkleProofWithMarkers onto
+// the leaf hash, carrying the hash up unchanged at promoted levels, and
+// compares the result with root
+func VerifyMarkedProof(leafHash []byte, proof []ProofNodeMarked, root []byte, h hash.Hash, opts TreeOptions) (bool, error) {
+	nodes := make([]ProofNode, 0, len(proof))
+	for _, n := range proof {
+		if n.Promoted {
+			if n.Hash != nil {
+				return false, errors.New("promoted proof node has a hash")
+			}
+			continue
+		}
+		nodes = append(nodes, ProofNode{Left: n.Left, Hash: n.Hash})
+	}
+	return VerifyProof(leafHash, nodes, root, h, opts)
+}
+
+// UpdateRootFromProof returns the root of the tree once the leaf proven by
+// proof is replaced by newLeaf, as the siblings on its path do not change.
+// The old leaf plays no part in the new root: check it against the current
+// root with UpdateRootFromProofChecked. Trees with hash sorting are not
+// supported, nor trees with OddNodeDuplicate, where the duplicated sibling of
+// a lone node changes along with the leaf.
+func UpdateRootFromProof(proof []ProofNode, oldLeaf, newLeaf []byte, h hash.Hash) ([]byte, error) {
+	if oldLeaf == nil || newLeaf == nil {
+		return nil, errors.New("leaves should not be nil")
+	}
+	return rootFromProof(newLeaf, proof, h, TreeOptions{})
+}
+
+// UpdateRootFromProofChecked returns the new root as UpdateRootFromProof
+// does, once the proof folds oldLeaf into oldRoot
+func UpdateRootFromProofChecked(proof []ProofNode, oldRoot, oldLeaf, newLeaf []byte, h hash.Hash) ([]byte, error) {
+	if oldLeaf == nil || newLeaf == nil {
+		return nil, errors.New("leaves should not be nil")
+	}
+	root, err := rootFromProof(oldLeaf, proof, h, TreeOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(root, oldRoot) {
+		return nil, errors.New("proof of the old leaf does not verify against the old root")
+	}
+	return UpdateRootFromProof(proof, oldLeaf, newLeaf, h)
+}
+
+// VerifySortedProof checks a proof of a tree with hash sorting enabled,
+// ordering each pair of hashes by value before hashing them
+func VerifySortedProof(leafHash []byte, proof SortedProof, root []byte, h hash.Hash) (bool, error) {
+	nodes := make([]ProofNode, len(proof))
+	for i, hash := range proof {
+		nodes[i] = ProofNode{Hash: hash}
+	}
+	return VerifyProof(leafHash, nodes, root, h, TreeOptions{EnableHashSorting: true})
+}
+
+// VerifyProofBounded verifies the proof as VerifyProof does, but fails
+// without hashing anything when the proof has more than maxNodes nodes
+func VerifyProofBounded(leafHash []byte, proof []ProofNode, root []byte, maxNodes int, h hash.Hash, opts TreeOptions) (bool, error) {
+	if len(proof) > maxNodes {
+		return false, errors.New("proof exceeds the maximum number of nodes")
+	}
+	return VerifyProof(leafHash, proof, root, h, opts)
+}
+
+// FormatProof lists the proof nodes one per line, with their side and their
+// hash truncated to 8 hex characters, for debugging
+func FormatProof(proof []ProofNode) string {
+	lines := make([]string, len(proof))
+	for i, n := range proof {
+		side := "RIGHT"
+		if n.Left {
+			side = "LEFT"
+		}
+		hash := hex.EncodeToString(n.Hash)
+		if len(hash) > 8 {
+			hash = hash[:8] + "..."
+		}
+		lines[i] = fmt.Sprintf("level %d: %-5s %s", i, side, hash)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// VerifyProofHex decodes a leaf hash, proof and root given as hex strings and
+// verifies them as VerifyProof does
+func VerifyProofHex(leafHashHex string, proofHex []struct {
+	Hash string
+	Left bool
+}, rootHex string, h hash.Hash, opts TreeOptions) (bool, error) {
+	leafHash, err := hex.DecodeString(leafHashHex)
+	if err != nil {
+		return false, fmt.Errorf("invalid leaf hash: %v", err)
+	}
+	if len(leafHash) == 0 {
+		return false, errors.New("invalid leaf hash: empty")
+	}
+	root, err := hex.DecodeString(rootHex)
+	if err != nil {
+		return false, fmt.Errorf("invalid root hash: %v", err)
+	}
+	if len(root) != h.Size() {
+		return false, fmt.Errorf("invalid root hash: expected %d bytes, got %d", h.Size(), len(root))
+	}
+	proof := make([]ProofNode, len(proofHex))
+	for i, n := range proofHex {
+		hash, err := hex.DecodeString(n.Hash)
+		if err != nil {
+			return false, fmt.Errorf("invalid hash of proof node %d: %v", i, err)
+		}
+		if len(hash) == 0 {
+			return false, fmt.Errorf("invalid hash of proof node %d: empty", i)
+		}
+		proof[i] = ProofNode{Left: n.Left, Hash: hash}
+	}
+	return VerifyProof(leafHash, proof, root, h, opts)
+}
+
+// Following are non public
+
+// Checks the inclusion of the leaf hash at index as VerifyInclusion does
+func verifyLeafInclusion(leafHash []byte, index uint, treeSize uint64, proof []ProofNode, root []byte, h hash.Hash, opts TreeOptions) bool {
+	if !matchesProofDirections(proof, uint64(index), treeSize, opts) {
+		return false
+	}
+	ok, err := VerifyProof(leafHash, proof, root, h, opts)
+	return err == nil && ok
+}
+
+// Returns the root obtained by hashing the leaf hash with every proof node
+func rootFromProof(leafHash []byte, proof []ProofNode, h hash.Hash, opts TreeOptions) ([]byte, error) {
+	current := leafHash
+	for _, n := range proof {
+		var data []byte
+		if n.Left {
+			data = concatHashes(n.Hash, current, opts.EnableHashSorting)
+		} else {
+			data = concatHashes(current, n.Hash, opts.EnableHashSorting)
+		}
+		node, err := NewNode(h, data)
+		if err != nil {
+			return nil, err
+		}
+		current = node.Hash
+	}
+	return current, nil
+}
+
+// Returns true if the proof has the length and Left flags of the path of
+// the leaf at index in a tree of treeSize leaves
+func matchesProofDirections(proof []ProofNode, index, treeSize uint64, opts TreeOptions) bool {
+	directions, err := proofDirections(index, treeSize)
+	if opts.OddNodeStrategy != OddNodePromote {
+		directions, err = pairedProofDirections(index, treeSize)
+	}
+	if err != nil || len(directions) != len(proof) {
+		return false
+	}
+	for i, left := range directions {
+		if proof[i].Left != left {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the directions of the proof of the leaf at index as
+// proofDirections does, in a tree where nodes without a sibling are paired
+// with another hash instead of promoted
+func pairedProofDirections(index, treeSize uint64) ([]bool, error) {
+	if index >= treeSize {
+		return nil, errors.New("node index is too big for node count")
+	}
+	directions := []bool{}
+	for lastNodeInLevel := treeSize - 1; lastNodeInLevel > 0; lastNodeInLevel /= 2 {
+		directions = append(directions, index%2 == 1)
+		index /= 2
+	}
+	return directions, nil
+}
+
+// Returns the number of nodes in the proof of the leaf at index in a tree of
+// treeSize leaves
+func proofLength(index, treeSize uint64) int {
+	length := 0
+	for lastNodeInLevel := treeSize - 1; lastNodeInLevel > 0; lastNodeInLevel /= 2 {
+		if !(index == lastNodeInLevel && index%2 == 0) {
+			length++
+		}
+		index /= 2
+	}
+	return length
+}
+
+// Returns the smallest tree size holding the leaf at index in which its
+// proof has at least length nodes, relying on the length never decreasing as
+// the tree grows
+func smallestSizeWithProofLength(index uint64, length int) (uint64, bool) {
+	lo, hi := index+1, uint64(math.MaxUint64)
+	if proofLength(index, hi) < length {
+		return 0, false
+	}
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if proofLength(index, mid) >= length {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return lo, true
+}
+
+// Returns the Left flags of the proof of the leaf at index in a tree of
+// treeSize leaves. Levels where the node is promoted without a sibling are
+// skipped, as GetMerkleProof does.
+func proofDirections(index, treeSize uint64) ([]bool, error) {
+	if index >= treeSize {
+		return nil, errors.New("node index is too big for node count")
+	}
+	directions := []bool{}
+	for lastNodeInLevel := treeSize - 1; lastNodeInLevel > 0; lastNodeInLevel /= 2 {
+		if !(index == lastNodeInLevel && index%2 == 0) {
+			directions = append(directions, index%2 == 1)
+		}
+		index /= 2
+	}
+	return directions, nil
+}
@@ -0,0 +1,529 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProofDirections(t *testing.T) {
+	// 5 Leaf Tree, leaf 4 is promoted twice
+	directions, err := proofDirections(4, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, []bool{true}, directions)
+
+	directions, err = proofDirections(2, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, []bool{false, true, false}, directions)
+
+	directions, err = proofDirections(0, 1)
+	assert.Nil(t, err)
+	assert.Empty(t, directions)
+
+	_, err = proofDirections(5, 5)
+	assert.Equal(t, "node index is too big for node count", err.Error())
+}
+
+func TestVerifyInclusion(t *testing.T) {
+	options := []TreeOptions{
+		{},
+		{EnableHashSorting: true},
+		{DisableHashLeaves: true},
+		{EnableHashSorting: true, DisableHashLeaves: true},
+	}
+	for _, opts := range options {
+		for _, count := range []int{1, 2, 3, 5, 8, 13} {
+			h := sha256.New()
+			data := createDummyTreeData(count, h.Size(), true)
+			tree := NewTreeWithOpts(h, opts)
+			err := tree.Generate(data, 0)
+			assert.Nil(t, err)
+
+			for i := 0; i < count; i++ {
+				proof, err := tree.GetMerkleProof(uint(i))
+				assert.Nil(t, err)
+				assert.True(t, VerifyInclusion(data[i], uint(i), uint64(count), proof, tree.RootHash(), h, opts),
+					fmt.Sprintf("VerifyInclusion(%d) of %d with %+v", i, count, opts))
+			}
+		}
+	}
+}
+
+func TestVerifyInclusionRejects(t *testing.T) {
+	h := md5.New()
+	data := createDummyTreeData(7, h.Size(), true)
+	tree := NewTree(h)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+	root := tree.RootHash()
+
+	proof, err := tree.GetMerkleProof(2)
+	assert.Nil(t, err)
+	assert.True(t, VerifyInclusion(data[2], 2, 7, proof, root, h, TreeOptions{DisableHashLeaves: true}))
+
+	// wrong leaf data
+	assert.False(t, VerifyInclusion(data[3], 2, 7, proof, root, h, TreeOptions{DisableHashLeaves: true}))
+	// wrong index, the directions do not match
+	assert.False(t, VerifyInclusion(data[2], 3, 7, proof, root, h, TreeOptions{DisableHashLeaves: true}))
+	// wrong tree size, the proof length does not match
+	assert.False(t, VerifyInclusion(data[2], 2, 2, proof, root, h, TreeOptions{DisableHashLeaves: true}))
+	// index out of range
+	assert.False(t, VerifyInclusion(data[2], 7, 7, proof, root, h, TreeOptions{DisableHashLeaves: true}))
+	// wrong root
+	assert.False(t, VerifyInclusion(data[2], 2, 7, proof, data[0], h, TreeOptions{DisableHashLeaves: true}))
+}
+
+func TestVerifyProofFailedHash(t *testing.T) {
+	proof := []ProofNode{{Left: true, Hash: []byte{1}}}
+	ok, err := VerifyProof([]byte{0}, proof, []byte{0}, NewFailingHash(), TreeOptions{})
+	assert.False(t, ok)
+	assert.Equal(t, "Failed to write hash", err.Error())
+}
+
+func TestVerifyProofAnyLeaf(t *testing.T) {
+	h := sha256.New()
+	data := createDummyTreeData(6, h.Size(), true)
+	tree := NewTree(h)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+	root := tree.RootHash()
+	proof, err := tree.GetMerkleProof(3)
+	assert.Nil(t, err)
+
+	// one candidate matches
+	candidates := [][]byte{data[0], data[5], data[3], data[1]}
+	i, ok := VerifyProofAnyLeaf(candidates, proof, 3, 6, root, h, TreeOptions{})
+	assert.True(t, ok)
+	assert.Equal(t, 2, i)
+
+	// none matches
+	i, ok = VerifyProofAnyLeaf([][]byte{data[0], data[5]}, proof, 3, 6, root, h, TreeOptions{})
+	assert.False(t, ok)
+	assert.Equal(t, -1, i)
+
+	// no candidates
+	i, ok = VerifyProofAnyLeaf(nil, proof, 3, 6, root, h, TreeOptions{})
+	assert.False(t, ok)
+	assert.Equal(t, -1, i)
+
+	// the proof does not follow the path of the index
+	i, ok = VerifyProofAnyLeaf(candidates, proof, 2, 6, root, h, TreeOptions{})
+	assert.False(t, ok)
+	assert.Equal(t, -1, i)
+}
+
+func TestIsMinimalProof(t *testing.T) {
+	h := md5.New()
+	data := createDummyTreeData(7, h.Size(), true)
+	tree := NewTree(h)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+
+	for i := uint(0); i < 7; i++ {
+		proof, err := tree.GetMerkleProof(i)
+		assert.Nil(t, err)
+		assert.True(t, IsMinimalProof(proof, i, 7))
+
+		// padded
+		padded := append(append([]ProofNode{}, proof...), ProofNode{Hash: data[0]})
+		assert.False(t, IsMinimalProof(padded, i, 7))
+
+		// truncated
+		assert.False(t, IsMinimalProof(proof[:len(proof)-1], i, 7))
+	}
+
+	// leaf 6 is promoted once, its proof is shorter
+	proof, err := tree.GetMerkleProof(6)
+	assert.Nil(t, err)
+	assert.Len(t, proof, 2)
+	assert.False(t, IsMinimalProof(proof, 5, 7))
+
+	// index out of range
+	assert.False(t, IsMinimalProof(proof, 7, 7))
+}
+
+func TestVerifyProofBounded(t *testing.T) {
+	h := md5.New()
+	data := createDummyTreeData(16, h.Size(), true)
+	tree := NewTree(h)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+	proof, err := tree.GetMerkleProof(9)
+	assert.Nil(t, err)
+
+	// within the budget
+	ok, err := VerifyProofBounded(data[9], proof, tree.RootHash(), 4, h, TreeOptions{})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = VerifyProofBounded(data[8], proof, tree.RootHash(), 32, h, TreeOptions{})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// exceeding the budget, no hashing happens
+	ok, err = VerifyProofBounded(data[9], proof, tree.RootHash(), 3, NewFailingHash(), TreeOptions{})
+	assert.Equal(t, "proof exceeds the maximum number of nodes", err.Error())
+	assert.False(t, ok)
+}
+
+func TestFormatProof(t *testing.T) {
+	proof := []ProofNode{
+		{Left: true, Hash: []byte{0xab, 0x12, 0xcd, 0x34, 0xef}},
+		{Left: false, Hash: []byte{0x01, 0x02}},
+		{Left: false, Hash: []byte{0xde, 0xad, 0xbe, 0xef}},
+	}
+	out := FormatProof(proof)
+	lines := strings.Split(out, "\n")
+	assert.Len(t, lines, len(proof))
+	assert.Equal(t, "level 0: LEFT  ab12cd34...", lines[0])
+	assert.Equal(t, "level 1: RIGHT 0102", lines[1])
+	assert.Equal(t, "level 2: RIGHT deadbeef", lines[2])
+	assert.True(t, strings.Contains(out, "LEFT"))
+	assert.True(t, strings.Contains(out, "RIGHT"))
+
+	assert.Equal(t, "", FormatProof(nil))
+}
+
+func TestVerifyProofHex(t *testing.T) {
+	h := sha256.New()
+	data := createDummyTreeData(6, h.Size(), true)
+	tree := NewTree(h)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+	proof, err := tree.GetMerkleProof(4)
+	assert.Nil(t, err)
+
+	proofHex := make([]struct {
+		Hash string
+		Left bool
+	}, len(proof))
+	for i, n := range proof {
+		proofHex[i].Hash = hex.EncodeToString(n.Hash)
+		proofHex[i].Left = n.Left
+	}
+	leafHex := hex.EncodeToString(data[4])
+	rootHex := hex.EncodeToString(tree.RootHash())
+
+	ok, err := VerifyProofHex(leafHex, proofHex, rootHex, h, TreeOptions{})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	ok, err = VerifyProofHex(hex.EncodeToString(data[3]), proofHex, rootHex, h, TreeOptions{})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// malformed hex
+	_, err = VerifyProofHex("zz", proofHex, rootHex, h, TreeOptions{})
+	assert.Equal(t, "invalid leaf hash: encoding/hex: invalid byte: U+007A 'z'", err.Error())
+	_, err = VerifyProofHex("", proofHex, rootHex, h, TreeOptions{})
+	assert.Equal(t, "invalid leaf hash: empty", err.Error())
+	_, err = VerifyProofHex(leafHex, proofHex, rootHex[1:], h, TreeOptions{})
+	assert.Equal(t, "invalid root hash: encoding/hex: odd length hex string", err.Error())
+	_, err = VerifyProofHex(leafHex, proofHex, rootHex[2:], h, TreeOptions{})
+	assert.Equal(t, "invalid root hash: expected 32 bytes, got 31", err.Error())
+	proofHex[1].Hash = "0g"
+	_, err = VerifyProofHex(leafHex, proofHex, rootHex, h, TreeOptions{})
+	assert.Equal(t, "invalid hash of proof node 1: encoding/hex: invalid byte: U+0067 'g'", err.Error())
+	proofHex[1].Hash = ""
+	_, err = VerifyProofHex(leafHex, proofHex, rootHex, h, TreeOptions{})
+	assert.Equal(t, "invalid hash of proof node 1: empty", err.Error())
+}
+
+func TestImpliedTreeSizeRange(t *testing.T) {
+	inputs := []struct {
+		index    uint
+		length   int
+		min, max uint64
+	}{
+		// balanced trees
+		{0, 3, 5, 8},
+		{7, 3, 8, 8},
+		{0, 0, 1, 1},
+		// leaf 4 is promoted up to the level below the root
+		{4, 1, 5, 5},
+		{4, 2, 6, 6},
+		{4, 3, 7, 8},
+		// leaf 8 is promoted at every level of a 9 leaves tree
+		{8, 1, 9, 9},
+		// too short for the index
+		{8, 0, 0, 0},
+		// longer than any tree
+		{0, 65, 0, 0},
+	}
+	for _, in := range inputs {
+		proof := make([]ProofNode, in.length)
+		min, max := ImpliedTreeSizeRange(proof, in.index)
+		assert.Equal(t, in.min, min, fmt.Sprintf("ImpliedTreeSizeRange(%d, %d) min", in.length, in.index))
+		assert.Equal(t, in.max, max, fmt.Sprintf("ImpliedTreeSizeRange(%d, %d) max", in.length, in.index))
+	}
+
+	// matches the proofs of generated trees
+	h := md5.New()
+	for size := 1; size <= 20; size++ {
+		tree := NewTree(h)
+		err := tree.Generate(createDummyTreeData(size, h.Size(), true), 0)
+		assert.Nil(t, err)
+		for i := 0; i < size; i++ {
+			proof, err := tree.GetMerkleProof(uint(i))
+			assert.Nil(t, err)
+			min, max := ImpliedTreeSizeRange(proof, uint(i))
+			assert.True(t, min <= uint64(size) && uint64(size) <= max)
+		}
+	}
+}
+
+func TestVerifyProofWithLeafCheck(t *testing.T) {
+	// leaves end with the CRC32 of their payload
+	withCRC := func(payload []byte) []byte {
+		sum := make([]byte, 4)
+		binary.BigEndian.PutUint32(sum, crc32.ChecksumIEEE(payload))
+		return append(payload, sum...)
+	}
+	check := func(leaf []byte) bool {
+		if len(leaf) < 4 {
+			return false
+		}
+		payload := leaf[:len(leaf)-4]
+		return binary.BigEndian.Uint32(leaf[len(leaf)-4:]) == crc32.ChecksumIEEE(payload)
+	}
+
+	h := sha256.New()
+	data := [][]byte{
+		withCRC([]byte("alpha")),
+		withCRC([]byte("beta")),
+		withCRC([]byte("gamma")),
+		// corrupted before being committed to
+		append([]byte("delta"), 0, 0, 0, 0),
+	}
+	tree := NewTree(h)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+
+	proof, err := tree.GetMerkleProof(1)
+	assert.Nil(t, err)
+	proofValid, leafCheckPassed := VerifyProofWithLeafCheck(data[1], check, 1, 4, proof, tree.RootHash(), h, TreeOptions{DisableHashLeaves: true})
+	assert.True(t, proofValid)
+	assert.True(t, leafCheckPassed)
+
+	// valid proof, failing checksum
+	proof, err = tree.GetMerkleProof(3)
+	assert.Nil(t, err)
+	proofValid, leafCheckPassed = VerifyProofWithLeafCheck(data[3], check, 3, 4, proof, tree.RootHash(), h, TreeOptions{DisableHashLeaves: true})
+	assert.True(t, proofValid)
+	assert.False(t, leafCheckPassed)
+
+	// valid checksum, invalid proof
+	other := withCRC([]byte("epsilon"))
+	proofValid, leafCheckPassed = VerifyProofWithLeafCheck(other, check, 3, 4, proof, tree.RootHash(), h, TreeOptions{DisableHashLeaves: true})
+	assert.False(t, proofValid)
+	assert.True(t, leafCheckPassed)
+
+	proofValid, leafCheckPassed = VerifyProofWithLeafCheck(data[3], nil, 3, 4, proof, tree.RootHash(), h, TreeOptions{DisableHashLeaves: true})
+	assert.True(t, proofValid)
+	assert.True(t, leafCheckPassed)
+}
+
+func TestUpdateRootFromProof(t *testing.T) {
+	h := sha256.New()
+	for _, count := range []int{1, 2, 5, 8, 11} {
+		data := createDummyTreeData(count, h.Size(), true)
+		tree := NewTree(h)
+		err := tree.Generate(data, 0)
+		assert.Nil(t, err)
+
+		for i := range data {
+			proof, err := tree.GetMerkleProof(uint(i))
+			assert.Nil(t, err)
+			newLeaf := hashValue(data[i], h)
+			root, err := UpdateRootFromProof(proof, data[i], newLeaf, h)
+			assert.Nil(t, err)
+
+			updated := make([][]byte, count)
+			copy(updated, data)
+			updated[i] = newLeaf
+			expected := NewTree(h)
+			err = expected.Generate(updated, 0)
+			assert.Nil(t, err)
+			assert.Equal(t, expected.RootHash(), root, fmt.Sprintf("UpdateRootFromProof(%d) of %d", i, count))
+		}
+	}
+
+	// the old leaf must be the leaf proven against the old root
+	data := createDummyTreeData(5, h.Size(), true)
+	tree := NewTree(h)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+	proof, err := tree.GetMerkleProof(2)
+	assert.Nil(t, err)
+	root, err := UpdateRootFromProofChecked(proof, tree.RootHash(), data[2], data[4], h)
+	assert.Nil(t, err)
+	expected, err := UpdateRootFromProof(proof, data[2], data[4], h)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, root)
+	_, err = UpdateRootFromProofChecked(proof, tree.RootHash(), data[3], data[4], h)
+	assert.Equal(t, "proof of the old leaf does not verify against the old root", err.Error())
+	_, err = UpdateRootFromProofChecked(proof, data[0], data[2], data[4], h)
+	assert.Equal(t, "proof of the old leaf does not verify against the old root", err.Error())
+	_, err = UpdateRootFromProofChecked(proof, tree.RootHash(), nil, data[4], h)
+	assert.Equal(t, "leaves should not be nil", err.Error())
+
+	_, err = UpdateRootFromProof(nil, nil, []byte{1}, h)
+	assert.Equal(t, "leaves should not be nil", err.Error())
+	_, err = UpdateRootFromProof([]ProofNode{{Hash: []byte{1}}}, []byte{1}, []byte{2}, NewFailingHash())
+	assert.Equal(t, "Failed to write hash", err.Error())
+}
+
+func TestProofVerify(t *testing.T) {
+	h := sha256.New()
+	data := createDummyTreeData(11, h.Size(), true)
+	tree := NewTree(h)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+
+	for i := range data {
+		proof, err := tree.GetProof(uint(i))
+		assert.Nil(t, err)
+		assert.Equal(t, uint(i), proof.LeafIndex)
+		assert.Equal(t, uint64(11), proof.LeafCount)
+		assert.Equal(t, uint64(5), proof.TreeHeight)
+		ok, err := proof.Verify(data[i], tree.RootHash(), h, tree.opts)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	}
+
+	// the same nodes recorded for another tree size do not verify
+	proof, err := tree.GetProof(10)
+	assert.Nil(t, err)
+	proof.LeafCount = 12
+	ok, err := proof.Verify(data[10], tree.RootHash(), h, tree.opts)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	proof.LeafCount = 17
+	_, err = proof.Verify(data[10], tree.RootHash(), h, tree.opts)
+	assert.Equal(t, "tree height does not match the leaf count", err.Error())
+	proof.LeafCount = 0
+	_, err = proof.Verify(data[10], tree.RootHash(), h, tree.opts)
+	assert.Equal(t, "Empty tree", err.Error())
+	_, err = NewTree(h).GetProof(0)
+	assert.Equal(t, "Tree is empty", err.Error())
+}
+
+func TestVerifySortedProof(t *testing.T) {
+	h := sha256.New()
+	data := createDummyTreeData(11, h.Size(), true)
+	tree := NewTreeWithHashSortingEnable(h)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+
+	for i := range data {
+		proof, err := tree.GetSortedMerkleProof(uint(i))
+		assert.Nil(t, err)
+		ok, err := VerifySortedProof(data[i], proof, tree.RootHash(), h)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		ok, err = VerifySortedProof(data[(i+1)%11], proof, tree.RootHash(), h)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	}
+
+	unsorted := NewTree(h)
+	err = unsorted.Generate(data, 0)
+	assert.Nil(t, err)
+	_, err = unsorted.GetSortedMerkleProof(0)
+	assert.Equal(t, "hash sorting is not enabled", err.Error())
+	_, err = tree.GetSortedMerkleProof(11)
+	assert.Equal(t, "node index is too big for node count", err.Error())
+}
+
+func TestVerifiersHashLeaves(t *testing.T) {
+	h := sha256.New()
+	data := createDummyTreeData(11, h.Size(), true)
+	opts := TreeOptions{}
+	tree := NewTreeWithOpts(h, opts)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+	// the data is not the leaf, so verifying it as a raw leaf fails
+	raw := TreeOptions{DisableHashLeaves: true}
+
+	proof, err := tree.GetProof(3)
+	assert.Nil(t, err)
+	ok, err := proof.Verify(data[3], tree.RootHash(), h, opts)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = proof.Verify(data[3], tree.RootHash(), h, raw)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	encoded, err := proof.MarshalBinary()
+	assert.Nil(t, err)
+	ok, err = VerifyProofStream(bytes.NewReader(encoded), data[3], tree.RootHash(), h, opts)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = VerifyProofStream(bytes.NewReader(encoded), data[3], tree.RootHash(), h, raw)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	chained := &ChainedProof{Links: []Proof{*proof}}
+	ok, err = chained.Verify(data[3], tree.RootHash(), h, opts)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = chained.Verify(data[3], tree.RootHash(), h, raw)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	multiProof, err := tree.GetMerkleMultiProof([]uint{3, 10})
+	assert.Nil(t, err)
+	ok, err = VerifyMultiProof([][]byte{data[3], data[10]}, multiProof, tree.RootHash(), h, opts)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = VerifyMultiProof([][]byte{data[3], data[10]}, multiProof, tree.RootHash(), h, raw)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	rangeProof, err := tree.GetRangeProof(2, 5)
+	assert.Nil(t, err)
+	ok, err = VerifyRangeProof(data[2:5], rangeProof, tree.RootHash(), h, opts)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = VerifyRangeProof(data[2:5], rangeProof, tree.RootHash(), h, raw)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	octopusProof, err := tree.GetOctopusProof([]uint{3, 10})
+	assert.Nil(t, err)
+	ok, err = VerifyOctopusProof([][]byte{data[3], data[10]}, octopusProof, tree.RootHash(), h, opts)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = VerifyOctopusProof([][]byte{data[3], data[10]}, octopusProof, tree.RootHash(), h, raw)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	leaves := map[uint][]byte{3: data[3]}
+	proofs := map[uint][]ProofNode{3: proof.Nodes}
+	combined, err := CombineVerifiedProofs(tree.RootHash(), 11, leaves, proofs, h, opts)
+	assert.Nil(t, err)
+	ok, err = VerifyMultiProof([][]byte{data[3]}, &combined, tree.RootHash(), h, opts)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, err = CombineVerifiedProofs(tree.RootHash(), 11, leaves, proofs, h, raw)
+	assert.Equal(t, "proof of leaf 3 does not verify against the root", err.Error())
+
+	partial, err := NewPartialTree(tree.RootHash(), 11, h, opts)
+	assert.Nil(t, err)
+	err = partial.AddProof(3, data[3], proof.Nodes)
+	assert.Nil(t, err)
+	assert.True(t, partial.VerifyLeaf(3, data[3]))
+	assert.False(t, partial.VerifyLeaf(3, tree.leaves()[3].Hash))
+	partial, err = NewPartialTree(tree.RootHash(), 11, h, raw)
+	assert.Nil(t, err)
+	err = partial.AddProof(3, data[3], proof.Nodes)
+	assert.Equal(t, "proof does not verify against the root", err.Error())
+}
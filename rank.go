@@ -0,0 +1,99 @@
+package merkle
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+)
+
+// RankProof proves that a leaf of a tree with sorted leaves is the k-th
+// smallest one. Besides the proof of the leaf, it holds the neighbouring
+// leaves with their proofs, showing that the leaf is in sorted order at its
+// position.
+type RankProof struct {
+	Index    uint
+	TreeSize uint64
+	Leaf     []byte
+	Proof    []ProofNode
+	// Leaf at Index-1 and its proof, nil for the first leaf
+	Previous      []byte
+	PreviousProof []ProofNode
+	// Leaf at Index+1 and its proof, nil for the last leaf
+	Next      []byte
+	NextProof []ProofNode
+}
+
+// RankProof returns the proof that the leaf at index has rank index. The
+// leaves of the tree must be sorted by value.
+func (self *Tree) RankProof(index uint) (*RankProof, error) {
+	err := self.checkBinary()
+	if err != nil {
+		return nil, err
+	}
+	err = self.checkPromote()
+	if err != nil {
+		return nil, err
+	}
+	leaves := self.leaves()
+	if len(leaves) == 0 {
+		return nil, errors.New("Tree is empty")
+	}
+	if index >= uint(len(leaves)) {
+		return nil, errors.New("node index is too big for node count")
+	}
+	for i := 1; i < len(leaves); i++ {
+		if bytes.Compare(leaves[i-1].Hash, leaves[i].Hash) > 0 {
+			return nil, errors.New("tree leaves are not sorted")
+		}
+	}
+
+	proof, err := self.GetMerkleProof(index)
+	if err != nil {
+		return nil, err
+	}
+	rank := &RankProof{Index: index, TreeSize: uint64(len(leaves)), Leaf: leaves[index].Hash, Proof: proof}
+	if index > 0 {
+		rank.Previous = leaves[index-1].Hash
+		rank.PreviousProof, err = self.GetMerkleProof(index - 1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if index+1 < uint(len(leaves)) {
+		rank.Next = leaves[index+1].Hash
+		rank.NextProof, err = self.GetMerkleProof(index + 1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rank, nil
+}
+
+// Verify checks that the leaf has the given rank in the tree with root: the
+// leaf and its neighbours are included at consecutive positions, and they
+// are in sorted order
+func (self *RankProof) Verify(rank uint, root []byte, h hash.Hash, opts TreeOptions) bool {
+	if rank != self.Index {
+		return false
+	}
+	if !verifyLeafInclusion(self.Leaf, self.Index, self.TreeSize, self.Proof, root, h, opts) {
+		return false
+	}
+	if self.Index > 0 {
+		if self.Previous == nil || bytes.Compare(self.Previous, self.Leaf) > 0 {
+			return false
+		}
+		if !verifyLeafInclusion(self.Previous, self.Index-1, self.TreeSize, self.PreviousProof, root, h, opts) {
+			return false
+		}
+	}
+	if uint64(self.Index)+1 < self.TreeSize {
+		if self.Next == nil || bytes.Compare(self.Leaf, self.Next) > 0 {
+			return false
+		}
+		if !verifyLeafInclusion(self.Next, self.Index+1, self.TreeSize, self.NextProof, root, h, opts) {
+			return false
+		}
+	}
+	return true
+}
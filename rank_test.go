@@ -0,0 +1,61 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRankProof(t *testing.T) {
+	h := sha256.New()
+	data := createDummyTreeData(9, h.Size(), true)
+	sort.Slice(data, func(i, j int) bool {
+		return bytes.Compare(data[i], data[j]) < 0
+	})
+	tree := NewTree(h)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+
+	for _, index := range []uint{0, 3, 7, 8} {
+		proof, err := tree.RankProof(index)
+		assert.Nil(t, err)
+		assert.Equal(t, data[index], proof.Leaf)
+		assert.True(t, proof.Verify(index, tree.RootHash(), h, TreeOptions{}))
+
+		// a wrong rank is rejected
+		assert.False(t, proof.Verify(index+1, tree.RootHash(), h, TreeOptions{}))
+		proof.Index = (index + 1) % 9
+		assert.False(t, proof.Verify(proof.Index, tree.RootHash(), h, TreeOptions{}))
+	}
+
+	// neighbours out of order are rejected
+	proof, err := tree.RankProof(4)
+	assert.Nil(t, err)
+	proof.Previous, proof.Next = proof.Next, proof.Previous
+	assert.False(t, proof.Verify(4, tree.RootHash(), h, TreeOptions{}))
+	proof, err = tree.RankProof(4)
+	assert.Nil(t, err)
+	proof.Next = nil
+	assert.False(t, proof.Verify(4, tree.RootHash(), h, TreeOptions{}))
+
+	_, err = tree.RankProof(9)
+	assert.Equal(t, "node index is too big for node count", err.Error())
+}
+
+func TestRankProofUnsortedTree(t *testing.T) {
+	h := sha256.New()
+	data := createDummyTreeData(4, h.Size(), true)
+	sort.Slice(data, func(i, j int) bool {
+		return bytes.Compare(data[i], data[j]) > 0
+	})
+	tree := NewTree(h)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+	_, err = tree.RankProof(0)
+	assert.Equal(t, "tree leaves are not sorted", err.Error())
+	_, err = NewTree(h).RankProof(0)
+	assert.Equal(t, "Tree is empty", err.Error())
+}
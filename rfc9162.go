@@ -0,0 +1,141 @@
+package merkle
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// RFC9162Proof is an inclusion proof laid out as the InclusionProofDataV2
+// structure of RFC 9162 (Certificate Transparency Version 2.0)
+type RFC9162Proof struct {
+	// DER encoded OID of the log, may be left empty
+	LogID     []byte
+	TreeSize  uint64
+	LeafIndex uint64
+	// Sibling hashes ordered from the leaf up to the root
+	InclusionPath [][]byte
+}
+
+// RFC9162InclusionProof returns the inclusion path of the leaf at leafIndex in
+// the tree made of the first treeSize leaves, as described in section 2.1.3.1
+// of RFC 9162. The leaves are expected to be hashed already, the hash function
+// of the tree is only used for the interior nodes.
+func (self *Tree) RFC9162InclusionProof(leafIndex, treeSize uint64) (*RFC9162Proof, error) {
+	err := self.checkBinary()
+	if err != nil {
+		return nil, err
+	}
+	err = self.checkPromote()
+	if err != nil {
+		return nil, err
+	}
+	leafCount := uint64(len(self.leaves()))
+	if leafCount == 0 {
+		return nil, errors.New("Tree is empty")
+	}
+	if treeSize == 0 || treeSize > leafCount {
+		return nil, errors.New("tree size is out of range")
+	}
+	if leafIndex >= treeSize {
+		return nil, errors.New("node index is too big for node count")
+	}
+
+	path, err := self.rfc9162Path(leafIndex, 0, treeSize)
+	if err != nil {
+		return nil, err
+	}
+	return &RFC9162Proof{TreeSize: treeSize, LeafIndex: leafIndex, InclusionPath: path}, nil
+}
+
+// MarshalBinary encodes the proof following the TLS presentation language
+// definition of InclusionProofDataV2, which is the body of a TransItem of type
+// inclusion_proof_v2
+func (self *RFC9162Proof) MarshalBinary() ([]byte, error) {
+	if len(self.LogID) > 127 {
+		return nil, errors.New("LogID is too long")
+	}
+	pathLen := 0
+	for _, h := range self.InclusionPath {
+		if len(h) > 255 {
+			return nil, errors.New("node hash is too long")
+		}
+		pathLen += 1 + len(h)
+	}
+	if pathLen > 0xFFFF {
+		return nil, errors.New("inclusion path is too long")
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(byte(len(self.LogID)))
+	buf.Write(self.LogID)
+	var scratch [8]byte
+	binary.BigEndian.PutUint64(scratch[:], self.TreeSize)
+	buf.Write(scratch[:])
+	binary.BigEndian.PutUint64(scratch[:], self.LeafIndex)
+	buf.Write(scratch[:])
+	binary.BigEndian.PutUint16(scratch[:2], uint16(pathLen))
+	buf.Write(scratch[:2])
+	for _, h := range self.InclusionPath {
+		buf.WriteByte(byte(len(h)))
+		buf.Write(h)
+	}
+	return buf.Bytes(), nil
+}
+
+// Following are non public
+
+// Returns the inclusion path of leaf m within the leaves [start, end), as the
+// PATH(m, D_n) function of RFC 9162
+func (self *Tree) rfc9162Path(m, start, end uint64) ([][]byte, error) {
+	n := end - start
+	if n == 1 {
+		return [][]byte{}, nil
+	}
+	k := nextPowerOfTwo(n) >> 1
+	if m < k {
+		path, err := self.rfc9162Path(m, start, start+k)
+		if err != nil {
+			return nil, err
+		}
+		sibling, err := self.subtreeHash(start+k, end)
+		if err != nil {
+			return nil, err
+		}
+		return append(path, sibling), nil
+	}
+	path, err := self.rfc9162Path(m-k, start+k, end)
+	if err != nil {
+		return nil, err
+	}
+	sibling, err := self.subtreeHash(start, start+k)
+	if err != nil {
+		return nil, err
+	}
+	return append(path, sibling), nil
+}
+
+// Returns the hash of the subtree spanning the leaves [start, end), as the
+// MTH function of RFC 9162. Complete subtrees are read from the tree, the
+// others are hashed from their complete parts.
+func (self *Tree) subtreeHash(start, end uint64) ([]byte, error) {
+	n := end - start
+	if isPowerOfTwo(n) && start%n == 0 {
+		level := uint64(len(self.levels)) - 1 - logBaseTwo(n)
+		return self.levels[level][start/n].Hash, nil
+	}
+	k := nextPowerOfTwo(n) >> 1
+	left, err := self.subtreeHash(start, start+k)
+	if err != nil {
+		return nil, err
+	}
+	right, err := self.subtreeHash(start+k, end)
+	if err != nil {
+		return nil, err
+	}
+	node, err := self.generateNode(left, right)
+	if err != nil {
+		return nil, err
+	}
+	return node.Hash, nil
+}
@@ -0,0 +1,224 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// PrefixHash: writes a fixed prefix before the data, used to get the domain
+// separated hashing of RFC 6962 and RFC 9162
+
+type PrefixHash struct {
+	hash.Hash
+	Prefix []byte
+}
+
+func (self PrefixHash) Write(p []byte) (int, error) {
+	_, err := self.Hash.Write(self.Prefix)
+	if err != nil {
+		return 0, err
+	}
+	return self.Hash.Write(p)
+}
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Leaf inputs of the RFC 6962 / RFC 9162 test vectors
+var rfcLeafInputs = []string{
+	"",
+	"00",
+	"10",
+	"2021",
+	"3031",
+	"40414243",
+	"5051525354555657",
+	"606162636465666768696a6b6c6d6e6f",
+}
+
+func rfcLeafHashes() [][]byte {
+	leaves := make([][]byte, len(rfcLeafInputs))
+	for i, in := range rfcLeafInputs {
+		leaves[i] = hashValue(append([]byte{0x00}, mustDecodeHex(in)...), sha256.New())
+	}
+	return leaves
+}
+
+func newRFCTree(t *testing.T) *Tree {
+	tree := NewTree(PrefixHash{Hash: sha256.New(), Prefix: []byte{0x01}})
+	err := tree.Generate(rfcLeafHashes(), 0)
+	assert.Nil(t, err)
+	return tree
+}
+
+func TestRFC9162RootHash(t *testing.T) {
+	tree := newRFCTree(t)
+	assert.Equal(t, mustDecodeHex("5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328"), tree.RootHash())
+}
+
+func TestRFC9162InclusionProof(t *testing.T) {
+	tree := newRFCTree(t)
+
+	inputs := []struct {
+		leafIndex uint64
+		treeSize  uint64
+		path      []string
+	}{
+		{0, 1, []string{}},
+		{0, 8, []string{
+			"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+		}},
+		{5, 8, []string{
+			"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+			"ca854ea128ed050b41b35ffc1b87b8eb2bde461e9e3b5596ece6b9d5975a0ae0",
+			"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+		}},
+		{2, 3, []string{
+			"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+		}},
+		{1, 5, []string{
+			"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+		}},
+	}
+	for _, in := range inputs {
+		proof, err := tree.RFC9162InclusionProof(in.leafIndex, in.treeSize)
+		assert.Nil(t, err)
+		assert.Equal(t, in.leafIndex, proof.LeafIndex)
+		assert.Equal(t, in.treeSize, proof.TreeSize)
+		expected := make([][]byte, len(in.path))
+		for i, p := range in.path {
+			expected[i] = mustDecodeHex(p)
+		}
+		assert.Equal(t, expected, proof.InclusionPath)
+	}
+}
+
+func TestRFC9162InclusionProofMatchesGetMerkleProof(t *testing.T) {
+	tree := newRFCTree(t)
+	for i := uint(0); i < 8; i++ {
+		proof, err := tree.GetMerkleProof(i)
+		assert.Nil(t, err)
+		rfcProof, err := tree.RFC9162InclusionProof(uint64(i), 8)
+		assert.Nil(t, err)
+		assert.Equal(t, len(proof), len(rfcProof.InclusionPath))
+		for j, n := range proof {
+			assert.Equal(t, n.Hash, rfcProof.InclusionPath[j])
+		}
+	}
+}
+
+func TestRFC9162InclusionProofInvalidArgument(t *testing.T) {
+	tree := NewTree(sha256.New())
+	_, err := tree.RFC9162InclusionProof(0, 1)
+	assert.Equal(t, "Tree is empty", err.Error())
+
+	tree = newRFCTree(t)
+	_, err = tree.RFC9162InclusionProof(0, 0)
+	assert.Equal(t, "tree size is out of range", err.Error())
+	_, err = tree.RFC9162InclusionProof(0, 9)
+	assert.Equal(t, "tree size is out of range", err.Error())
+	_, err = tree.RFC9162InclusionProof(3, 3)
+	assert.Equal(t, "node index is too big for node count", err.Error())
+}
+
+func TestRFC9162ProofMarshalBinary(t *testing.T) {
+	proof := &RFC9162Proof{
+		LogID:         []byte{0x06, 0x01},
+		TreeSize:      3,
+		LeafIndex:     2,
+		InclusionPath: [][]byte{{0xaa, 0xbb}, {0xcc}},
+	}
+	data, err := proof.MarshalBinary()
+	assert.Nil(t, err)
+	expected := []byte{
+		0x02, 0x06, 0x01,
+		0, 0, 0, 0, 0, 0, 0, 3,
+		0, 0, 0, 0, 0, 0, 0, 2,
+		0x00, 0x05,
+		0x02, 0xaa, 0xbb,
+		0x01, 0xcc,
+	}
+	assert.Equal(t, expected, data)
+
+	proof.LogID = make([]byte, 128)
+	_, err = proof.MarshalBinary()
+	assert.Equal(t, "LogID is too long", err.Error())
+
+	proof.LogID = nil
+	proof.InclusionPath = [][]byte{make([]byte, 256)}
+	_, err = proof.MarshalBinary()
+	assert.Equal(t, "node hash is too long", err.Error())
+}
+
+func TestTreeConsistencyProof(t *testing.T) {
+	tree := newRFCTree(t)
+	h := tree.hashFunc
+	roots := map[uint64][]byte{}
+	for size := uint64(1); size <= 8; size++ {
+		prefix := NewTree(h)
+		err := prefix.Generate(rfcLeafHashes()[:size], 0)
+		assert.Nil(t, err)
+		roots[size] = prefix.RootHash()
+	}
+
+	// consistency proofs of RFC 6962 test vectors
+	inputs := []struct {
+		size uint64
+		path []string
+	}{
+		{1, []string{
+			"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+		}},
+		{6, []string{
+			"0ebc5d3437fbe2db158b9f126a1d118e308181031d0a949f8dededebc558ef6a",
+			"ca854ea128ed050b41b35ffc1b87b8eb2bde461e9e3b5596ece6b9d5975a0ae0",
+			"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+		}},
+	}
+	for _, in := range inputs {
+		proof, err := tree.ConsistencyProof(in.size, 8)
+		assert.Nil(t, err)
+		assert.Equal(t, len(in.path), len(proof))
+		for i, p := range in.path {
+			assert.Equal(t, mustDecodeHex(p), proof[i].Hash)
+		}
+	}
+
+	for oldSize := uint64(1); oldSize <= 8; oldSize++ {
+		for newSize := oldSize; newSize <= 8; newSize++ {
+			proof, err := tree.ConsistencyProof(oldSize, newSize)
+			assert.Nil(t, err)
+			ok, err := VerifyConsistencyProof(oldSize, newSize, roots[oldSize], roots[newSize], proof, h, TreeOptions{})
+			assert.Nil(t, err)
+			assert.True(t, ok, fmt.Sprintf("VerifyConsistencyProof(%d, %d)", oldSize, newSize))
+			if oldSize > 1 {
+				ok, err = VerifyConsistencyProof(oldSize, newSize, roots[oldSize-1], roots[newSize], proof, h, TreeOptions{})
+				assert.Nil(t, err)
+				assert.False(t, ok)
+			}
+		}
+	}
+
+	_, err := tree.ConsistencyProof(2, 9)
+	assert.Equal(t, "tree size is out of range", err.Error())
+	_, err = tree.ConsistencyProof(3, 2)
+	assert.Equal(t, "older size is bigger than the newer one", err.Error())
+	_, err = NewTree(h).ConsistencyProof(0, 0)
+	assert.Equal(t, "Tree is empty", err.Error())
+}
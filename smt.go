@@ -6,8 +6,13 @@ in the LICENSE file.
 package merkle
 
 import (
+	"bytes"
 	"errors"
 	"hash"
+	"math/bits"
+	"runtime"
+	"sync"
+	"sync/atomic"
 )
 
 // A Sparse Merkle Tree which support all empty leaves lies in right
@@ -18,10 +23,91 @@ type SMT struct {
 	emptyTreeRootHash     []Hash
 	treeHeight            int
 	countOfNonEmptyLeaves int
+	arity                 int
+	history               *smtHistory
+	// Nodes of each level keyed by index, for a tree built by GenerateSparse.
+	// The levels of fullNodes are then left nil and only tell that the tree
+	// is filled.
+	sparseNodes []map[int]Hash
+	// Creates a hash instance per hash computation, if set
+	newHash func() hash.Hash
+	// Levels of the tree before the last Reset, reused by Generate
+	spareLevels [][]Hash
+	// Counters of Stats, updated atomically
+	hashCount      uint64
+	bytesHashed    uint64
+	emptyCacheHits uint64
 }
 
+// KaryProofNode holds the siblings of a node at one level of a k-ary SMT or
+// Tree, ordered by position with the proven node left out
+type KaryProofNode struct {
+	Siblings [][]byte
+}
+
+// Leaf is a leaf given to SMT.GenerateLeaves, either already hashed or raw
+// data that the tree hashes
+type Leaf struct {
+	Data      []byte
+	PreHashed bool
+}
+
+// NewSMT creates a binary sparse tree whose empty leaves are emptyHash. Any
+// default value can be used, such as the hash of a zero record, the hashes of
+// the empty subtrees are derived from it.
 func NewSMT(emptyHash Hash, hashFunc hash.Hash) *SMT {
-	return &SMT{fullNodes: [][]Hash{}, emptyTreeRootHash: []Hash{emptyHash}, emptyHash: emptyHash, hashFunc: hashFunc}
+	return NewSMTWithArity(2, hashFunc, emptyHash)
+}
+
+// NewSMTWithArity creates a sparse tree where every internal node has arity
+// children. The number of leaves given to Generate must be a power of arity.
+func NewSMTWithArity(arity int, nonLeafHash hash.Hash, emptyLeaf []byte) *SMT {
+	return &SMT{fullNodes: [][]Hash{}, emptyTreeRootHash: []Hash{emptyLeaf}, emptyHash: emptyLeaf, hashFunc: nonLeafHash, arity: arity}
+}
+
+// NewSMTWithHashConstructor creates a sparse tree like NewSMTWithArity that
+// hashes with a new instance from newHash in every operation. Trees created
+// from the same constructor share no hash state and a tree can verify proofs
+// from several goroutines at once.
+func NewSMTWithHashConstructor(arity int, newHash func() hash.Hash, emptyLeaf []byte) *SMT {
+	tree := NewSMTWithArity(arity, newHash(), emptyLeaf)
+	tree.newHash = newHash
+	return tree
+}
+
+// EmptyLeaf returns the default value of the empty leaves
+func (self *SMT) EmptyLeaf() []byte {
+	return self.emptyHash
+}
+
+// GetLeafHash returns the leaf at index as committed by the tree, the empty
+// leaf for indices past the non-empty leaves
+func (self *SMT) GetLeafHash(index uint) ([]byte, error) {
+	if len(self.fullNodes) == 0 {
+		return nil, errors.New("SMT tree is not filled")
+	}
+	if uint64(index) >= self.totalSize() {
+		return nil, errors.New("Leaf index is out of bounds")
+	}
+	return self.node(0, int(index)), nil
+}
+
+// EmptySubtreeRoot returns the root hash of a subtree of the given height
+// whose leaves are all empty, height 0 being a single empty leaf
+func (self *SMT) EmptySubtreeRoot(height int) ([]byte, error) {
+	if height < 0 {
+		return nil, errors.New("Height should not be negative")
+	}
+	if self.arity < 2 {
+		return nil, errors.New("Arity of SMT tree should be at least 2")
+	}
+	if height >= len(self.emptyTreeRootHash) {
+		err := self.computeEmptyLeavesSubTreeHash(self.hasher(), height+1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return self.emptyTreeRootHash[height], nil
 }
 
 func (self *SMT) RootHash() []byte {
@@ -29,53 +115,420 @@ func (self *SMT) RootHash() []byte {
 		return nil
 	}
 	if self.countOfNonEmptyLeaves == 0 {
-		return self.emptyTreeRootHash[len(self.emptyTreeRootHash)-1]
+		return self.emptyTreeRootHash[self.treeHeight-1]
 	}
-	return self.fullNodes[self.treeHeight-1][0]
+	return self.node(self.treeHeight-1, 0)
 }
 
-func (self *SMT) Generate(leaves [][]byte, totalSize int) error {
-	if len(self.fullNodes) != 0 {
-		return errors.New("SMT tree already filled")
+// Clone returns a copy of the tree that the changes to the tree do not
+// affect, and the other way around. Hashes are immutable and shared. The
+// copy hashes with its own instance if the tree was created by
+// NewSMTWithHashConstructor, with the same instance otherwise.
+func (self *SMT) Clone() *SMT {
+	clone := &SMT{
+		hashFunc:              self.hashFunc,
+		emptyHash:             self.emptyHash,
+		emptyTreeRootHash:     append([]Hash{}, self.emptyTreeRootHash...),
+		treeHeight:            self.treeHeight,
+		countOfNonEmptyLeaves: self.countOfNonEmptyLeaves,
+		arity:                 self.arity,
+		newHash:               self.newHash,
+		hashCount:             atomic.LoadUint64(&self.hashCount),
+		bytesHashed:           atomic.LoadUint64(&self.bytesHashed),
+		emptyCacheHits:        atomic.LoadUint64(&self.emptyCacheHits),
 	}
-	if !isPowerOfTwo(uint64(totalSize)) {
-		return errors.New("Leaves number of SMT tree should be power of 2")
+	if self.newHash != nil {
+		clone.hashFunc = self.newHash()
+	}
+	clone.fullNodes = make([][]Hash, len(self.fullNodes))
+	for level, hashes := range self.fullNodes {
+		if hashes != nil {
+			clone.fullNodes[level] = append([]Hash{}, hashes...)
+		}
+	}
+	if self.sparseNodes != nil {
+		clone.sparseNodes = make([]map[int]Hash, len(self.sparseNodes))
+		for level, hashes := range self.sparseNodes {
+			clone.sparseNodes[level] = make(map[int]Hash, len(hashes))
+			for index, hash := range hashes {
+				clone.sparseNodes[level][index] = hash
+			}
+		}
+	}
+	if self.history != nil {
+		clone.history = &smtHistory{
+			roots:  append([]Hash{}, self.history.roots...),
+			counts: append([]int{}, self.history.counts...),
+			undo:   append([][]smtChange{}, self.history.undo...),
+		}
+	}
+	return clone
+}
+
+// Reset empties the tree so that it can be generated again. The memory of the
+// levels is kept for the next Generate, as is the cache of empty subtree
+// hashes. The history, if any, is dropped.
+func (self *SMT) Reset() {
+	if len(self.fullNodes) != 0 && self.sparseNodes == nil {
+		self.spareLevels = self.fullNodes
+	}
+	self.fullNodes = [][]Hash{}
+	self.sparseNodes = nil
+	self.treeHeight = 0
+	self.countOfNonEmptyLeaves = 0
+	self.history = nil
+}
+
+func (self *SMT) Generate(leaves [][]byte, totalSize int) error {
+	h := self.hasher()
+	err := self.checkTotalSize(uint64(totalSize))
+	if err != nil {
+		return err
 	}
 	count := len(leaves)
 	if count > totalSize {
 		return errors.New("NonEmptyLeaves is bigger than totalSize")
 	}
-	self.treeHeight = int(logBaseTwo(uint64(totalSize)) + 1)
+	self.treeHeight = int(logBase(uint64(totalSize), uint64(self.arity)) + 1)
 	self.countOfNonEmptyLeaves = len(leaves)
 
 	noOfEmtpyLeaves := totalSize - len(leaves)
 	maxEmtySubTreeHeight := 0
-	for i := noOfEmtpyLeaves; i > 0; i = i >> 1 {
+	for i := noOfEmtpyLeaves; i > 0; i = i / self.arity {
 		maxEmtySubTreeHeight++
 	}
-	err := self.computeEmptyLeavesSubTreeHash(maxEmtySubTreeHeight)
+	err = self.computeEmptyLeavesSubTreeHash(h, maxEmtySubTreeHeight)
 	if err != nil {
 		return err
 	}
 
-	hashes := []Hash{}
+	hashes := self.levelBuffer(0)
 	for i := 0; i < count; i++ {
 		hashes = append(hashes, leaves[i])
 	}
 	self.fullNodes = append(self.fullNodes, hashes)
 
-	err = self.computeAllLevelNodes(leaves)
+	err = self.computeAllLevelNodes(h, leaves)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GenerateFromValues hashes each value into a leaf, spreading the values over
+// a worker per CPU with a hash instance from newHash each, then generates the
+// tree from the leaves as Generate
+func (self *SMT) GenerateFromValues(values [][]byte, totalSize int, newHash func() hash.Hash) error {
+	leaves := make([][]byte, len(values))
+	errs := make([]error, len(values))
+	workers := runtime.NumCPU()
+	if workers > len(values) {
+		workers = len(values)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			h := newHash()
+			for i := w; i < len(values); i += workers {
+				leaves[i], errs[i] = hashConcat(h, values[i])
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return self.Generate(leaves, totalSize)
+}
+
+// GenerateFromChannel generates the tree from the leaves received on leaves
+// until it is closed, as Generate does with a slice. Each parent is hashed as
+// soon as its children are known, so the leaves never need to be collected
+// first. On error the rest of the channel is drained, not to block the
+// sender.
+func (self *SMT) GenerateFromChannel(leaves <-chan []byte, totalSize uint64) error {
+	err := self.generateFromChannel(leaves, totalSize)
+	if err != nil {
+		for range leaves {
+		}
+	}
+	return err
+}
+
+// GenerateLeaves generates the tree as Generate from leaves that are either
+// hashed already or hashed first with the hash function of the tree
+func (self *SMT) GenerateLeaves(leaves []Leaf, totalSize int) error {
+	h := self.hasher()
+	hashes := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		if leaf.Data == nil {
+			return errors.New("leaves should not be nil")
+		}
+		if leaf.PreHashed {
+			hashes[i] = leaf.Data
+			continue
+		}
+		hash, err := self.parentHash(h, leaf.Data)
+		if err != nil {
+			return err
+		}
+		hashes[i] = hash
+	}
+	return self.Generate(hashes, totalSize)
+}
+
+// GeneratePadded generates the tree like Generate, rounding totalSize up to
+// the next power of the arity. The leaves added by the rounding are empty.
+func (self *SMT) GeneratePadded(leaves [][]byte, totalSize int) error {
+	if totalSize > 0 && self.arity >= 2 {
+		padded, err := nextPowerOf(uint64(totalSize), uint64(self.arity))
+		if err != nil {
+			return err
+		}
+		if int(padded) < 0 || uint64(int(padded)) != padded {
+			return errors.New("next power overflows")
+		}
+		totalSize = int(padded)
+	}
+	return self.Generate(leaves, totalSize)
+}
+
+// GenerateSparse builds the tree from leaves placed at arbitrary indices,
+// every other leaf being empty. Only the nodes on the paths of the given
+// leaves are hashed and stored, the other nodes take the cached empty
+// subtree hashes. Memory and hashing thus grow with the number of leaves
+// times the height of the tree, whatever the indices.
+func (self *SMT) GenerateSparse(leaves map[uint64][]byte, totalSize uint64) error {
+	h := self.hasher()
+	err := self.checkTotalSize(totalSize)
+	if err != nil {
+		return err
+	}
+	count := 0
+	for index, leaf := range leaves {
+		if index >= totalSize {
+			return errors.New("Leaf index is out of bounds")
+		}
+		if leaf == nil {
+			return errors.New("leaves should not be nil")
+		}
+		if int(index) >= count {
+			count = int(index) + 1
+		}
+	}
+	treeHeight := int(logBase(totalSize, uint64(self.arity)) + 1)
+	err = self.computeEmptyLeavesSubTreeHash(h, treeHeight)
+	if err != nil {
+		return err
+	}
+
+	self.treeHeight = treeHeight
+	self.fullNodes = make([][]Hash, treeHeight)
+	self.sparseNodes = make([]map[int]Hash, treeHeight)
+	self.sparseNodes[0] = make(map[int]Hash, len(leaves))
+	for index, leaf := range leaves {
+		self.sparseNodes[0][int(index)] = leaf
+	}
+	for level := 0; level < treeHeight-1; level++ {
+		parents := map[int]Hash{}
+		for index := range self.sparseNodes[level] {
+			parent := index / self.arity
+			if _, ok := parents[parent]; ok {
+				continue
+			}
+			children := make([]Hash, 0, self.arity)
+			for i := parent * self.arity; i < (parent+1)*self.arity; i++ {
+				children = append(children, self.node(level, i))
+			}
+			hash, err := self.parentHash(h, children...)
+			if err != nil {
+				self.Reset()
+				return err
+			}
+			parents[parent] = hash
+		}
+		self.sparseNodes[level+1] = parents
+	}
+	self.countOfNonEmptyLeaves = count
+	return nil
+}
+
+// UpdateLeaf replaces the non-empty leaf at index and recomputes only the
+// nodes on its path to the root. On error the tree is left unchanged.
+func (self *SMT) UpdateLeaf(index uint, newLeaf []byte) error {
+	if len(self.fullNodes) == 0 {
+		return errors.New("SMT tree is not filled")
+	}
+	if newLeaf == nil {
+		return errors.New("leaves should not be nil")
+	}
+	if index >= uint(self.countOfNonEmptyLeaves) {
+		return errors.New("Leaf is empty, only non-empty leaves can be updated")
+	}
+
+	h := self.hasher()
+	path, err := self.pathHashes(h, int(index), newLeaf)
 	if err != nil {
 		return err
 	}
+	for level, hash := range path {
+		self.setNode(level, int(index), hash)
+		index = index / uint(self.arity)
+	}
+	self.commitVersion()
 	return nil
 }
 
-// Leaf mumber begins with 0
+// DeleteLeaf resets the leaf at index to the empty leaf and updates the nodes
+// on its path. Trailing empty leaves are then dropped, so an emptied tree
+// falls back to the cached empty subtree hashes. Deleting an empty leaf does
+// nothing.
+func (self *SMT) DeleteLeaf(index uint) error {
+	if len(self.fullNodes) == 0 {
+		return errors.New("SMT tree is not filled")
+	}
+	if uint64(index) >= self.totalSize() {
+		return errors.New("Leaf index is out of bounds")
+	}
+	if index >= uint(self.countOfNonEmptyLeaves) {
+		return nil
+	}
+	h := self.hasher()
+	err := self.computeEmptyLeavesSubTreeHash(h, self.treeHeight)
+	if err != nil {
+		return err
+	}
+	path, err := self.pathHashes(h, int(index), self.emptyHash)
+	if err != nil {
+		return err
+	}
+	for level, hash := range path {
+		self.setNode(level, int(index), hash)
+		index = index / uint(self.arity)
+	}
+
+	count := self.nonEmptyPrefix()
+	width := count
+	for level := range self.fullNodes {
+		self.truncateLevel(level, width)
+		width = (width + self.arity - 1) / self.arity
+	}
+	self.countOfNonEmptyLeaves = count
+	self.commitVersion()
+	return nil
+}
+
+// AppendLeaf sets the first empty leaf after the non-empty ones and returns
+// its index. Only the nodes on its path are hashed, their siblings being the
+// stored nodes on the left and empty subtrees on the right.
+func (self *SMT) AppendLeaf(leaf []byte) (uint, error) {
+	if len(self.fullNodes) == 0 {
+		return 0, errors.New("SMT tree is not filled")
+	}
+	if leaf == nil {
+		return 0, errors.New("leaves should not be nil")
+	}
+	index := self.countOfNonEmptyLeaves
+	if uint64(index) >= self.totalSize() {
+		return 0, errors.New("SMT tree is full")
+	}
+	h := self.hasher()
+	err := self.computeEmptyLeavesSubTreeHash(h, self.treeHeight)
+	if err != nil {
+		return 0, err
+	}
+	path, err := self.pathHashes(h, index, leaf)
+	if err != nil {
+		return 0, err
+	}
+	i := index
+	for level, hash := range path {
+		if i == self.levelWidth(level) {
+			self.appendNode(level, hash)
+		} else {
+			self.setNode(level, i, hash)
+		}
+		i = i / self.arity
+	}
+	self.countOfNonEmptyLeaves++
+	self.commitVersion()
+	return uint(index), nil
+}
+
+// UpdateLeaves replaces several non-empty leaves, keyed by index, computing
+// each common ancestor once. On error the tree is left unchanged.
+func (self *SMT) UpdateLeaves(updates map[uint][]byte) error {
+	if len(self.fullNodes) == 0 {
+		return errors.New("SMT tree is not filled")
+	}
+	changed := map[int]Hash{}
+	for index, leaf := range updates {
+		if leaf == nil {
+			return errors.New("leaves should not be nil")
+		}
+		if index >= uint(self.countOfNonEmptyLeaves) {
+			return errors.New("Leaf is empty, only non-empty leaves can be updated")
+		}
+		changed[int(index)] = leaf
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	// New hashes of each level, from the leaves up
+	h := self.hasher()
+	levels := []map[int]Hash{changed}
+	for level := 0; level < self.treeHeight-1; level++ {
+		parents := map[int]Hash{}
+		for index := range changed {
+			parent := index / self.arity
+			if _, ok := parents[parent]; ok {
+				continue
+			}
+			children := make([]Hash, 0, self.arity)
+			for i := parent * self.arity; i < (parent+1)*self.arity; i++ {
+				if hash, ok := changed[i]; ok {
+					children = append(children, hash)
+				} else {
+					children = append(children, self.node(level, i))
+				}
+			}
+			hash, err := self.parentHash(h, children...)
+			if err != nil {
+				return err
+			}
+			parents[parent] = hash
+		}
+		levels = append(levels, parents)
+		changed = parents
+	}
+
+	for level, hashes := range levels {
+		for index, hash := range hashes {
+			self.setNode(level, index, hash)
+		}
+	}
+	self.commitVersion()
+	return nil
+}
+
+// GetMerkleProof returns the proof of the leaf at leafNo, which may be an
+// empty leaf. Leaf number begins with 0.
 func (self *SMT) GetMerkleProof(leafNo uint) ([]ProofNode, error) {
 	if len(self.fullNodes) == 0 {
 		return nil, errors.New("SMT tree is not filled")
 	}
+	if self.arity != 2 {
+		return nil, errors.New("Binary proofs need an arity of 2, use GetKaryMerkleProof")
+	}
+	if uint64(leafNo) >= self.totalSize() {
+		return nil, errors.New("Leaf index is out of bounds")
+	}
 
 	proofs := []ProofNode{}
 	level := int(self.treeHeight - 1)
@@ -88,13 +541,284 @@ func (self *SMT) GetMerkleProof(leafNo uint) ([]ProofNode, error) {
 	return proofs, nil
 }
 
+// VerifyProof checks that leaf is at leafIndex in this tree with the proof
+// returned by GetMerkleProof. A nil leaf stands for an empty leaf.
+func (self *SMT) VerifyProof(leafIndex uint, leaf []byte, proof []ProofNode) (bool, error) {
+	if len(self.fullNodes) == 0 {
+		return false, errors.New("SMT tree is not filled")
+	}
+	if self.arity != 2 {
+		return false, errors.New("Binary proofs need an arity of 2, use GetKaryMerkleProof")
+	}
+	if leafIndex >= uint(1)<<uint(self.treeHeight-1) {
+		return false, errors.New("Leaf index is out of bounds")
+	}
+	if len(proof) != self.treeHeight-1 {
+		return false, nil
+	}
+	return VerifySMTProof(self.RootHash(), leafIndex, leaf, proof, self.emptyHash, self.hasher())
+}
+
+// VerifySMTProof checks a proof returned by SMT.GetMerkleProof knowing only
+// the root, the empty leaf hash and the hash function of the tree. The Left
+// flags of the proof must match the position of leafIndex, and a nil leaf
+// stands for an empty leaf.
+func VerifySMTProof(root []byte, leafIndex uint, leaf []byte, proof []ProofNode, emptyHash Hash, hashFunc hash.Hash) (bool, error) {
+	if len(proof) < bits.Len(leafIndex) {
+		return false, nil
+	}
+	index := leafIndex
+	for _, n := range proof {
+		if n.Left != (index%2 == 1) {
+			return false, nil
+		}
+		index = index / 2
+	}
+	if leaf == nil {
+		leaf = emptyHash
+	}
+	return VerifyProof(leaf, proof, root, hashFunc, TreeOptions{})
+}
+
+// GetNonMembershipProof returns the proof that the leaf at index is empty
+func (self *SMT) GetNonMembershipProof(index uint) ([]ProofNode, error) {
+	if len(self.fullNodes) == 0 {
+		return nil, errors.New("SMT tree is not filled")
+	}
+	if index >= uint(1)<<uint(self.treeHeight-1) {
+		return nil, errors.New("Leaf index is out of bounds")
+	}
+	if index < uint(self.countOfNonEmptyLeaves) && !bytes.Equal(self.node(0, int(index)), self.emptyHash) {
+		return nil, errors.New("Leaf is not empty")
+	}
+	return self.GetMerkleProof(index)
+}
+
+// VerifyNonMembershipProof checks that the leaf at index is empty in the SMT
+// with the given root
+func VerifyNonMembershipProof(root []byte, index uint, proof []ProofNode, emptyHash Hash, hashFunc hash.Hash) (bool, error) {
+	return VerifySMTProof(root, index, nil, proof, emptyHash, hashFunc)
+}
+
+// GetKaryMerkleProof returns, for every level from the leaves up, the
+// siblings of the node on the path of the leaf. Leaf number begins with 0.
+func (self *SMT) GetKaryMerkleProof(leafNo uint) ([]KaryProofNode, error) {
+	if len(self.fullNodes) == 0 {
+		return nil, errors.New("SMT tree is not filled")
+	}
+	if uint64(leafNo) >= self.totalSize() {
+		return nil, errors.New("Leaf index is out of bounds")
+	}
+
+	proofs := []KaryProofNode{}
+	index := int(leafNo)
+	for i := self.treeHeight - 1; i > 0; i-- {
+		first := (index / self.arity) * self.arity
+		siblings := make([][]byte, 0, self.arity-1)
+		for j := first; j < first+self.arity; j++ {
+			if j != index {
+				siblings = append(siblings, self.node(self.treeHeight-1-i, j))
+			}
+		}
+		proofs = append(proofs, KaryProofNode{Siblings: siblings})
+		index = index / self.arity
+	}
+	return proofs, nil
+}
+
+// ProveEmptyRange proves that no leaf of [start, end] is set. The range is
+// covered by the smallest aligned subtree holding both ends, which must only
+// hold empty leaves, and the returned proof takes the cached empty-subtree
+// hash of that subtree up to the root. Verify it with VerifyEmptyRange.
+func (self *SMT) ProveEmptyRange(start, end uint) ([]ProofNode, error) {
+	if len(self.fullNodes) == 0 {
+		return nil, errors.New("SMT tree is not filled")
+	}
+	if self.arity != 2 {
+		return nil, errors.New("Binary proofs need an arity of 2, use GetKaryMerkleProof")
+	}
+	totalSize := uint(1) << uint(self.treeHeight-1)
+	if start > end || end >= totalSize {
+		return nil, errors.New("Range is out of bounds")
+	}
+	height := emptyRangeHeight(start, end)
+	index := start >> height
+	if index<<height < uint(self.countOfNonEmptyLeaves) {
+		return nil, errors.New("Range is not covered by an empty subtree")
+	}
+
+	proofs := []ProofNode{}
+	for level := height; level < uint(self.treeHeight-1); level++ {
+		hash := self.node(int(level), int(index^1))
+		proofs = append(proofs, ProofNode{Hash: hash, Left: index%2 == 1})
+		index = index / 2
+	}
+	return proofs, nil
+}
+
+// VerifyEmptyRange checks a proof returned by ProveEmptyRange for [start,
+// end] against the root of a binary SMT of totalSize leaves
+func VerifyEmptyRange(start, end uint, totalSize int, proof []ProofNode, root []byte, emptyHash Hash, hashFunc hash.Hash) (bool, error) {
+	if !isPowerOfTwo(uint64(totalSize)) {
+		return false, errors.New("Leaves number of SMT tree should be power of 2")
+	}
+	if start > end || end >= uint(totalSize) {
+		return false, errors.New("Range is out of bounds")
+	}
+	height := emptyRangeHeight(start, end)
+	if uint64(len(proof)) != logBaseTwo(uint64(totalSize))-uint64(height) {
+		return false, nil
+	}
+	index := start >> height
+	for _, n := range proof {
+		if n.Left != (index%2 == 1) {
+			return false, nil
+		}
+		index = index / 2
+	}
+
+	subtree := []byte(emptyHash)
+	for i := uint(0); i < height; i++ {
+		node, err := NewNode(hashFunc, concatHashes(subtree, subtree, false))
+		if err != nil {
+			return false, err
+		}
+		subtree = node.Hash
+	}
+	return VerifyProof(subtree, proof, root, hashFunc, TreeOptions{})
+}
+
 // Following are non public function
 
-func (self *SMT) computeEmptyLeavesSubTreeHash(maxHeight int) error {
-	lastLevelHash := self.emptyHash
+// Returns the height of the smallest aligned subtree holding start and end
+func emptyRangeHeight(start, end uint) uint {
+	height := uint(0)
+	for start>>height != end>>height {
+		height++
+	}
+	return height
+}
+
+func (self *SMT) generateFromChannel(leaves <-chan []byte, totalSize uint64) error {
+	h := self.hasher()
+	err := self.checkTotalSize(totalSize)
+	if err != nil {
+		return err
+	}
+	treeHeight := int(logBase(totalSize, uint64(self.arity)) + 1)
+	err = self.computeEmptyLeavesSubTreeHash(h, treeHeight)
+	if err != nil {
+		return err
+	}
+
+	levels := make([][]Hash, treeHeight)
+	// Adds a node to a level, and its parent once its group is complete
+	var push func(level int, hash Hash) error
+	push = func(level int, hash Hash) error {
+		levels[level] = append(levels[level], hash)
+		if level == treeHeight-1 || len(levels[level])%self.arity != 0 {
+			return nil
+		}
+		parent, err := self.parentHash(h, levels[level][len(levels[level])-self.arity:]...)
+		if err != nil {
+			return err
+		}
+		return push(level+1, parent)
+	}
+
+	count := uint64(0)
+	for leaf := range leaves {
+		if leaf == nil {
+			return errors.New("leaves should not be nil")
+		}
+		count++
+		if count > totalSize {
+			return errors.New("NonEmptyLeaves is bigger than totalSize")
+		}
+		err = push(0, leaf)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The last group of each level is completed with empty subtrees
+	for level := 0; level < treeHeight-1; level++ {
+		rest := len(levels[level]) % self.arity
+		if rest == 0 {
+			continue
+		}
+		children := make([]Hash, 0, self.arity)
+		children = append(children, levels[level][len(levels[level])-rest:]...)
+		for len(children) < self.arity {
+			children = append(children, self.emptySubtree(level))
+		}
+		parent, err := self.parentHash(h, children...)
+		if err != nil {
+			return err
+		}
+		err = push(level+1, parent)
+		if err != nil {
+			return err
+		}
+	}
+	for level := range levels {
+		if levels[level] == nil {
+			levels[level] = []Hash{}
+		}
+	}
+
+	self.fullNodes = levels
+	self.treeHeight = treeHeight
+	self.countOfNonEmptyLeaves = int(count)
+	return nil
+}
+
+// Returns an empty level reusing the memory of the level of a previous tree,
+// if any
+func (self *SMT) levelBuffer(level int) []Hash {
+	if level >= len(self.spareLevels) || self.spareLevels[level] == nil {
+		return []Hash{}
+	}
+	buffer := self.spareLevels[level][:0]
+	self.spareLevels[level] = nil
+	return buffer
+}
+
+// Returns the number of leaves of the generated tree, empty ones included
+func (self *SMT) totalSize() uint64 {
+	size := uint64(1)
+	for i := 1; i < self.treeHeight; i++ {
+		size *= uint64(self.arity)
+	}
+	return size
+}
+
+// Checks that a tree of totalSize leaves can be generated
+func (self *SMT) checkTotalSize(totalSize uint64) error {
+	if len(self.fullNodes) != 0 {
+		return errors.New("SMT tree already filled")
+	}
+	if self.arity < 2 {
+		return errors.New("Arity of SMT tree should be at least 2")
+	}
+	if self.arity == 2 && !isPowerOfTwo(totalSize) {
+		return errors.New("Leaves number of SMT tree should be power of 2")
+	}
+	if !isPowerOf(totalSize, uint64(self.arity)) {
+		return errors.New("Leaves number of SMT tree should be power of arity")
+	}
+	return nil
+}
+
+func (self *SMT) computeEmptyLeavesSubTreeHash(h hash.Hash, maxHeight int) error {
+	lastLevelHash := self.emptyTreeRootHash[len(self.emptyTreeRootHash)-1]
 	var err error
-	for i := 1; i < maxHeight; i++ {
-		lastLevelHash, err = self.parentHash(lastLevelHash, lastLevelHash)
+	children := make([]Hash, self.arity)
+	for i := len(self.emptyTreeRootHash); i < maxHeight; i++ {
+		for j := range children {
+			children[j] = lastLevelHash
+		}
+		lastLevelHash, err = self.parentHash(h, children...)
 		if err != nil {
 			return err
 		}
@@ -103,9 +827,9 @@ func (self *SMT) computeEmptyLeavesSubTreeHash(maxHeight int) error {
 	return nil
 }
 
-func (self *SMT) computeAllLevelNodes(leaves [][]byte) error {
+func (self *SMT) computeAllLevelNodes(h hash.Hash, leaves [][]byte) error {
 	for i := self.treeHeight; i > 1; i-- {
-		err := self.computeNodesAt(i - 1)
+		err := self.computeNodesAt(h, i-1)
 		if err != nil {
 			return err
 		}
@@ -113,21 +837,26 @@ func (self *SMT) computeAllLevelNodes(leaves [][]byte) error {
 	return nil
 }
 
-func (self *SMT) computeNodesAt(level int) error {
+func (self *SMT) computeNodesAt(h hash.Hash, level int) error {
 	lastLevelNodesHash := self.fullNodes[self.treeHeight-1-level]
 	count := len(lastLevelNodesHash)
-	hashes := []Hash{}
-	countRoundToEven := (count / 2) * 2
-	for i := 0; i < countRoundToEven; i += 2 {
-		hash, err := self.parentHash(lastLevelNodesHash[i], lastLevelNodesHash[i+1])
+	hashes := self.levelBuffer(len(self.fullNodes))
+	countRoundToArity := (count / self.arity) * self.arity
+	for i := 0; i < countRoundToArity; i += self.arity {
+		hash, err := self.parentHash(h, lastLevelNodesHash[i:i+self.arity]...)
 		if err != nil {
 			return err
 		}
 		hashes = append(hashes, hash)
 	}
-	if count%2 != 0 {
-		siblingEmptyTreeHash := self.emptyTreeRootHash[self.treeHeight-1-level]
-		hash, err := self.parentHash(lastLevelNodesHash[count-1], siblingEmptyTreeHash)
+	if count%self.arity != 0 {
+		children := make([]Hash, 0, self.arity)
+		children = append(children, lastLevelNodesHash[countRoundToArity:]...)
+		siblingEmptyTreeHash := self.emptySubtree(self.treeHeight - 1 - level)
+		for len(children) < self.arity {
+			children = append(children, siblingEmptyTreeHash)
+		}
+		hash, err := self.parentHash(h, children...)
 		if err != nil {
 			return err
 		}
@@ -137,37 +866,130 @@ func (self *SMT) computeNodesAt(level int) error {
 	return nil
 }
 
+// Returns the hashes of the nodes on the path of the leaf at index, from the
+// leaf up to the root, if the leaf was replaced by leaf
+func (self *SMT) pathHashes(h hash.Hash, index int, leaf Hash) ([]Hash, error) {
+	path := []Hash{leaf}
+	for level := 0; level < self.treeHeight-1; level++ {
+		first := index - index%self.arity
+		children := make([]Hash, 0, self.arity)
+		for i := first; i < first+self.arity; i++ {
+			if i == index {
+				children = append(children, path[level])
+			} else {
+				children = append(children, self.node(level, i))
+			}
+		}
+		hash, err := self.parentHash(h, children...)
+		if err != nil {
+			return nil, err
+		}
+		path = append(path, hash)
+		index = index / self.arity
+	}
+	return path, nil
+}
+
 func (self *SMT) proofNodeAt(index int, level int) ProofNode {
+	return ProofNode{Hash: self.node(self.treeHeight-1-level, index^1), Left: index%2 == 1}
+}
 
-	hashes := self.fullNodes[int(self.treeHeight)-1-level]
-	var hash Hash
-	left := false
-	if index%2 == 1 {
-		left = true
-	}
-	if left {
-		hash = hashes[index-1]
-	} else {
-		if len(hashes)-1 < index+1 {
-			hash = self.emptyTreeRootHash[int(self.treeHeight)-1-level]
-		} else {
-			hash = hashes[index+1]
+// Returns the node at index of level, counting levels from the leaves, with
+// the cached empty subtree hash where no node is stored
+func (self *SMT) node(level, index int) Hash {
+	if hash, ok := self.storedNode(level, index); ok {
+		return hash
+	}
+	return self.emptySubtree(level)
+}
+
+// Returns the node stored at index of level, if any
+func (self *SMT) storedNode(level, index int) (Hash, bool) {
+	if self.sparseNodes != nil {
+		hash, ok := self.sparseNodes[level][index]
+		return hash, ok
+	}
+	if index < len(self.fullNodes[level]) {
+		return self.fullNodes[level][index], true
+	}
+	return nil, false
+}
+
+// Stores a node, without recording it in the history
+func (self *SMT) storeNode(level, index int, hash Hash) {
+	if self.sparseNodes != nil {
+		self.sparseNodes[level][index] = hash
+		return
+	}
+	self.fullNodes[level][index] = hash
+}
+
+// Returns the indices of the stored nodes of a level, in no given order for
+// a sparse tree
+func (self *SMT) storedIndices(level int) []int {
+	indices := []int{}
+	if self.sparseNodes != nil {
+		for index := range self.sparseNodes[level] {
+			indices = append(indices, index)
 		}
+		return indices
 	}
-	return ProofNode{Hash: hash, Left: left}
+	for index := range self.fullNodes[level] {
+		indices = append(indices, index)
+	}
+	return indices
 }
 
-func (self *SMT) parentHash(item1 Hash, item2 Hash) ([]byte, error) {
-	hash := self.hashFunc
-	defer hash.Reset()
+// Returns the number of nodes of a level over the non-empty leaves, the
+// nodes past them being empty subtrees
+func (self *SMT) levelWidth(level int) int {
+	if self.sparseNodes == nil {
+		return len(self.fullNodes[level])
+	}
+	width := self.countOfNonEmptyLeaves
+	for i := 0; i < level; i++ {
+		width = (width + self.arity - 1) / self.arity
+	}
+	return width
+}
 
-	_, err := hash.Write(item1)
-	if err != nil {
-		return []byte{}, err
+// Returns the number of leaves up to the last one which is not the empty leaf
+func (self *SMT) nonEmptyPrefix() int {
+	count := 0
+	if self.sparseNodes != nil {
+		for index, hash := range self.sparseNodes[0] {
+			if index >= count && !bytes.Equal(hash, self.emptyHash) {
+				count = index + 1
+			}
+		}
+		return count
 	}
-	_, err = hash.Write(item2)
-	if err != nil {
-		return []byte{}, err
+	count = self.countOfNonEmptyLeaves
+	for count > 0 && bytes.Equal(self.fullNodes[0][count-1], self.emptyHash) {
+		count--
+	}
+	return count
+}
+
+// Returns the hash instance of an operation, to be passed to the hash
+// computations of the operation
+func (self *SMT) hasher() hash.Hash {
+	if self.newHash != nil {
+		return self.newHash()
+	}
+	return self.hashFunc
+}
+
+func (self *SMT) parentHash(hash hash.Hash, items ...Hash) ([]byte, error) {
+	defer hash.Reset()
+	atomic.AddUint64(&self.hashCount, 1)
+
+	for _, item := range items {
+		_, err := hash.Write(item)
+		if err != nil {
+			return []byte{}, err
+		}
+		atomic.AddUint64(&self.bytesHashed, uint64(len(item)))
 	}
 	return hash.Sum(nil), nil
 }
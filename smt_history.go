@@ -0,0 +1,152 @@
+package merkle
+
+import (
+	"errors"
+)
+
+// The roots of the versions of an SMT and how to undo each version
+type smtHistory struct {
+	// Root hash and number of non-empty leaves of each version
+	roots  []Hash
+	counts []int
+	// undo[v] restores version v from version v+1, in reverse order
+	undo [][]smtChange
+	// Changes of the update in progress
+	pending []smtChange
+}
+
+// The previous state of a node, or of the length of a level if tail is set
+type smtChange struct {
+	level  int
+	index  int
+	hash   Hash
+	length int
+	tail   []Hash
+}
+
+// EnableHistory starts recording the roots of the tree as version 0 for the
+// current state, each UpdateLeaf, UpdateLeaves, DeleteLeaf or AppendLeaf that
+// changes the tree then adds a version. Recording again restarts from version 0.
+func (self *SMT) EnableHistory() error {
+	if len(self.fullNodes) == 0 {
+		return errors.New("SMT tree is not filled")
+	}
+	self.history = &smtHistory{roots: []Hash{self.RootHash()}, counts: []int{self.countOfNonEmptyLeaves}, undo: [][]smtChange{}}
+	return nil
+}
+
+// Version returns the latest version of the tree
+func (self *SMT) Version() (uint64, error) {
+	if self.history == nil {
+		return 0, errors.New("SMT history is not enabled")
+	}
+	return uint64(len(self.history.roots) - 1), nil
+}
+
+// RootAt returns the root hash of the tree at the given version
+func (self *SMT) RootAt(version uint64) ([]byte, error) {
+	if self.history == nil {
+		return nil, errors.New("SMT history is not enabled")
+	}
+	if version >= uint64(len(self.history.roots)) {
+		return nil, errors.New("Version not found")
+	}
+	return self.history.roots[version], nil
+}
+
+// Rollback restores the tree as it was at the given version and forgets the
+// later versions
+func (self *SMT) Rollback(version uint64) error {
+	if self.history == nil {
+		return errors.New("SMT history is not enabled")
+	}
+	if version >= uint64(len(self.history.roots)) {
+		return errors.New("Version not found")
+	}
+	for v := len(self.history.undo) - 1; v >= int(version); v-- {
+		changes := self.history.undo[v]
+		for i := len(changes) - 1; i >= 0; i-- {
+			change := changes[i]
+			if change.tail == nil {
+				self.storeNode(change.level, change.index, change.hash)
+				continue
+			}
+			// The level was either truncated, losing its tail, or grown
+			current := self.fullNodes[change.level]
+			restored := make([]Hash, change.length)
+			copied := copy(restored, current)
+			copy(restored[copied:], change.tail)
+			self.fullNodes[change.level] = restored
+		}
+	}
+	self.countOfNonEmptyLeaves = self.history.counts[version]
+	self.history.roots = self.history.roots[:version+1]
+	self.history.counts = self.history.counts[:version+1]
+	self.history.undo = self.history.undo[:version]
+	return nil
+}
+
+// Following are non public
+
+// Sets a node, recording its previous hash when the history is enabled
+func (self *SMT) setNode(level, index int, hash Hash) {
+	if self.history != nil {
+		previous, ok := self.storedNode(level, index)
+		if !ok {
+			previous = self.emptyTreeRootHash[level]
+		}
+		self.history.pending = append(self.history.pending, smtChange{level: level, index: index, hash: previous})
+	}
+	self.storeNode(level, index, hash)
+}
+
+// Adds a node at the end of a level, recording the previous length of the
+// level when the history is enabled
+func (self *SMT) appendNode(level int, hash Hash) {
+	if self.sparseNodes != nil {
+		self.setNode(level, self.levelWidth(level), hash)
+		return
+	}
+	if self.history != nil {
+		self.history.pending = append(self.history.pending, smtChange{level: level, length: len(self.fullNodes[level]), tail: []Hash{}})
+	}
+	self.fullNodes[level] = append(self.fullNodes[level], hash)
+}
+
+// Drops the nodes of a level from width on, recording them when the history
+// is enabled
+func (self *SMT) truncateLevel(level, width int) {
+	if self.sparseNodes != nil {
+		for index, hash := range self.sparseNodes[level] {
+			if index < width {
+				continue
+			}
+			if self.history != nil {
+				self.history.pending = append(self.history.pending, smtChange{level: level, index: index, hash: hash})
+			}
+			delete(self.sparseNodes[level], index)
+		}
+		return
+	}
+	nodes := self.fullNodes[level]
+	if width >= len(nodes) {
+		return
+	}
+	if self.history != nil {
+		tail := make([]Hash, len(nodes)-width)
+		copy(tail, nodes[width:])
+		self.history.pending = append(self.history.pending, smtChange{level: level, length: len(nodes), tail: tail})
+	}
+	self.fullNodes[level] = nodes[:width:width]
+}
+
+// Records the state after an update as a new version
+func (self *SMT) commitVersion() {
+	if self.history == nil {
+		return
+	}
+	self.history.roots = append(self.history.roots, self.RootHash())
+	self.history.counts = append(self.history.counts, self.countOfNonEmptyLeaves)
+	self.history.undo = append(self.history.undo, self.history.pending)
+	self.history.pending = nil
+}
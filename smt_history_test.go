@@ -0,0 +1,92 @@
+package merkle
+
+import (
+	"crypto/md5"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSMTHistory(t *testing.T) {
+	h := md5.New()
+	data := createDummyTreeData(6, h.Size(), true)
+	tree := NewSMT(emptyHash, h)
+	err := tree.Generate(data, 8)
+	assert.Nil(t, err)
+	err = tree.EnableHistory()
+	assert.Nil(t, err)
+
+	states := []*SMT{}
+	snapshot := func() {
+		encoded, err := tree.Marshal()
+		assert.Nil(t, err)
+		state, err := UnmarshalSMT(encoded, h)
+		assert.Nil(t, err)
+		states = append(states, state)
+	}
+	snapshot()
+	err = tree.UpdateLeaf(1, testHashes[9])
+	assert.Nil(t, err)
+	snapshot()
+	err = tree.UpdateLeaves(map[uint][]byte{0: testHashes[10], 4: testHashes[11]})
+	assert.Nil(t, err)
+	snapshot()
+	err = tree.DeleteLeaf(5)
+	assert.Nil(t, err)
+	err = tree.DeleteLeaf(4)
+	assert.Nil(t, err)
+	snapshot()
+	// deleting an empty leaf adds no version
+	err = tree.DeleteLeaf(7)
+	assert.Nil(t, err)
+
+	version, err := tree.Version()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), version)
+	for v, state := range []int{0, 1, 2, 4} {
+		root, err := tree.RootAt(uint64(state))
+		assert.Nil(t, err)
+		assert.Equal(t, states[v].RootHash(), root)
+	}
+
+	err = tree.Rollback(2)
+	assert.Nil(t, err)
+	assert.Equal(t, states[2].fullNodes, tree.fullNodes)
+	assert.Equal(t, states[2].RootHash(), tree.RootHash())
+	assert.Equal(t, 6, tree.countOfNonEmptyLeaves)
+	_, err = tree.RootAt(3)
+	assert.Equal(t, "Version not found", err.Error())
+
+	// new versions follow the rolled back one
+	err = tree.UpdateLeaf(2, testHashes[12])
+	assert.Nil(t, err)
+	version, err = tree.Version()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), version)
+
+	err = tree.Rollback(0)
+	assert.Nil(t, err)
+	assert.Equal(t, states[0].fullNodes, tree.fullNodes)
+	assert.Equal(t, states[0].RootHash(), tree.RootHash())
+}
+
+func TestSMTHistoryInvalidArgument(t *testing.T) {
+	h := md5.New()
+	tree := NewSMT(emptyHash, h)
+	err := tree.EnableHistory()
+	assert.Equal(t, "SMT tree is not filled", err.Error())
+	err = tree.Generate(createDummyTreeData(3, h.Size(), true), 4)
+	assert.Nil(t, err)
+
+	_, err = tree.Version()
+	assert.Equal(t, "SMT history is not enabled", err.Error())
+	_, err = tree.RootAt(0)
+	assert.Equal(t, "SMT history is not enabled", err.Error())
+	err = tree.Rollback(0)
+	assert.Equal(t, "SMT history is not enabled", err.Error())
+
+	err = tree.EnableHistory()
+	assert.Nil(t, err)
+	err = tree.Rollback(1)
+	assert.Equal(t, "Version not found", err.Error())
+}
@@ -0,0 +1,133 @@
+package merkle
+
+import (
+	"bytes"
+	"errors"
+)
+
+// MergeSMT returns a tree holding the non-empty leaves of both trees, which
+// must have the same shape and empty leaf and no non-empty leaf at the same
+// index. A node whose subtree is empty in one of the trees is taken from the
+// other tree without hashing, only the nodes with non-empty leaves from both
+// trees are hashed again. The result hashes as a.
+func MergeSMT(a, b *SMT) (*SMT, error) {
+	err := checkSameShape(a, b)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := NewSMTWithArity(a.arity, a.hashFunc, a.emptyHash)
+	merged.newHash = a.newHash
+	merged.treeHeight = a.treeHeight
+	h := merged.hasher()
+	err = merged.computeEmptyLeavesSubTreeHash(h, merged.treeHeight)
+	if err != nil {
+		return nil, err
+	}
+	// Returns the node at index of a level of a tree, and false for an empty
+	// subtree
+	node := func(tree *SMT, level, index int) (Hash, bool) {
+		hash, ok := tree.storedNode(level, index)
+		if !ok || bytes.Equal(hash, merged.emptyTreeRootHash[level]) {
+			return nil, false
+		}
+		return hash, true
+	}
+	// A sparse tree only merges over the stored nodes of both trees
+	sparse := a.sparseNodes != nil || b.sparseNodes != nil
+	merged.fullNodes = make([][]Hash, merged.treeHeight)
+	if sparse {
+		merged.sparseNodes = make([]map[int]Hash, merged.treeHeight)
+	}
+
+	for level := 0; level < merged.treeHeight; level++ {
+		width := a.levelWidth(level)
+		if b.levelWidth(level) > width {
+			width = b.levelWidth(level)
+		}
+		indices := append(a.storedIndices(level), b.storedIndices(level)...)
+		if sparse {
+			merged.sparseNodes[level] = map[int]Hash{}
+		} else {
+			merged.fullNodes[level] = make([]Hash, width)
+			for i := range merged.fullNodes[level] {
+				merged.fullNodes[level][i] = merged.emptyTreeRootHash[level]
+			}
+		}
+		for _, i := range indices {
+			aHash, aOk := node(a, level, i)
+			bHash, bOk := node(b, level, i)
+			switch {
+			case aOk && bOk && level == 0:
+				return nil, errors.New("Trees have non-empty leaves at the same index")
+			case aOk && bOk:
+				if _, ok := node(merged, level, i); ok {
+					continue
+				}
+				children := make([]Hash, 0, merged.arity)
+				for j := i * merged.arity; j < (i+1)*merged.arity; j++ {
+					children = append(children, merged.node(level-1, j))
+				}
+				hash, err := merged.parentHash(h, children...)
+				if err != nil {
+					return nil, err
+				}
+				merged.storeNode(level, i, hash)
+			case aOk:
+				merged.storeNode(level, i, aHash)
+			case bOk:
+				merged.storeNode(level, i, bHash)
+			}
+		}
+	}
+	merged.countOfNonEmptyLeaves = a.countOfNonEmptyLeaves
+	if b.countOfNonEmptyLeaves > merged.countOfNonEmptyLeaves {
+		merged.countOfNonEmptyLeaves = b.countOfNonEmptyLeaves
+	}
+	return merged, nil
+}
+
+// Diff returns the indices of the leaves which differ from the leaves of
+// other, in increasing order. Both trees must have the same shape and empty
+// leaf. Only the subtrees whose roots differ are walked.
+func (self *SMT) Diff(other *SMT) ([]uint, error) {
+	err := checkSameShape(self, other)
+	if err != nil {
+		return nil, err
+	}
+	diff := []uint{}
+	self.diffAt(other, self.treeHeight-1, 0, &diff)
+	return diff, nil
+}
+
+// Following are non public
+
+// Returns an error unless both trees are filled, with the same arity, height
+// and empty leaf
+func checkSameShape(a, b *SMT) error {
+	if len(a.fullNodes) == 0 || len(b.fullNodes) == 0 {
+		return errors.New("SMT tree is not filled")
+	}
+	if a.arity != b.arity || a.treeHeight != b.treeHeight {
+		return errors.New("Trees have a different shape")
+	}
+	if !bytes.Equal(a.emptyHash, b.emptyHash) {
+		return errors.New("Trees have different empty leaves")
+	}
+	return nil
+}
+
+// Appends to diff the indices of the differing leaves under the node at index
+// of level
+func (self *SMT) diffAt(other *SMT, level, index int, diff *[]uint) {
+	if bytes.Equal(self.node(level, index), other.node(level, index)) {
+		return
+	}
+	if level == 0 {
+		*diff = append(*diff, uint(index))
+		return
+	}
+	for i := index * self.arity; i < (index+1)*self.arity; i++ {
+		self.diffAt(other, level-1, i, diff)
+	}
+}
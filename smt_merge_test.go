@@ -0,0 +1,142 @@
+package merkle
+
+import (
+	"crypto/md5"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeSMT(t *testing.T) {
+	h := md5.New()
+	for _, arity := range []int{2, 4} {
+		a := NewSMTWithArity(arity, h, emptyHash)
+		err := a.Generate(testHashes[:5], 16)
+		assert.Nil(t, err)
+		b := NewSMTWithArity(arity, h, emptyHash)
+		err = b.GenerateSparse(map[uint64][]byte{5: testHashes[5], 6: testHashes[6], 12: testHashes[12]}, 16)
+		assert.Nil(t, err)
+
+		merged, err := MergeSMT(a, b)
+		assert.Nil(t, err)
+		expected := NewSMTWithArity(arity, h, emptyHash)
+		err = expected.GenerateSparse(map[uint64][]byte{0: testHashes[0], 1: testHashes[1], 2: testHashes[2], 3: testHashes[3], 4: testHashes[4], 5: testHashes[5], 6: testHashes[6], 12: testHashes[12]}, 16)
+		assert.Nil(t, err)
+		assert.Equal(t, expected.fullNodes, merged.fullNodes)
+		assert.Equal(t, expected.sparseNodes, merged.sparseNodes)
+		assert.Equal(t, expected.RootHash(), merged.RootHash())
+
+		reversed, err := MergeSMT(b, a)
+		assert.Nil(t, err)
+		assert.Equal(t, expected.RootHash(), reversed.RootHash())
+
+		// trees generated densely merge into a dense tree
+		c := NewSMTWithArity(arity, h, emptyHash)
+		err = c.Generate([][]byte{emptyHash, emptyHash, emptyHash, emptyHash, emptyHash, testHashes[5], testHashes[6]}, 16)
+		assert.Nil(t, err)
+		merged, err = MergeSMT(a, c)
+		assert.Nil(t, err)
+		expected = NewSMTWithArity(arity, h, emptyHash)
+		err = expected.Generate(testHashes[:7], 16)
+		assert.Nil(t, err)
+		assert.Equal(t, expected.fullNodes, merged.fullNodes)
+	}
+}
+
+func TestMergeSMTReusesSubtrees(t *testing.T) {
+	h := md5.New()
+	a := NewSMT(emptyHash, h)
+	err := a.Generate(testHashes[:4], 16)
+	assert.Nil(t, err)
+	b := NewSMT(emptyHash, h)
+	err = b.GenerateSparse(map[uint64][]byte{8: testHashes[8], 9: testHashes[9]}, 16)
+	assert.Nil(t, err)
+
+	count := 0
+	a.hashFunc = NewHashCountDecorator(h, &count)
+	merged, err := MergeSMT(a, b)
+	assert.Nil(t, err)
+	// 4 empty subtree hashes, then only the root joins the two trees
+	assert.Equal(t, 5, count)
+	proof, err := merged.GetMerkleProof(9)
+	assert.Nil(t, err)
+	ok, err := merged.VerifyProof(9, testHashes[9], proof)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func TestMergeSMTInvalidArgument(t *testing.T) {
+	h := md5.New()
+	a := NewSMT(emptyHash, h)
+	b := NewSMT(emptyHash, h)
+	_, err := MergeSMT(a, b)
+	assert.Equal(t, "SMT tree is not filled", err.Error())
+
+	err = a.Generate(testHashes[:3], 8)
+	assert.Nil(t, err)
+	err = b.Generate(testHashes[:1], 16)
+	assert.Nil(t, err)
+	_, err = MergeSMT(a, b)
+	assert.Equal(t, "Trees have a different shape", err.Error())
+
+	b = NewSMT(testHashes[15], h)
+	err = b.Generate(nil, 8)
+	assert.Nil(t, err)
+	_, err = MergeSMT(a, b)
+	assert.Equal(t, "Trees have different empty leaves", err.Error())
+
+	b = NewSMT(emptyHash, h)
+	err = b.GenerateSparse(map[uint64][]byte{2: testHashes[5]}, 8)
+	assert.Nil(t, err)
+	_, err = MergeSMT(a, b)
+	assert.Equal(t, "Trees have non-empty leaves at the same index", err.Error())
+}
+
+func TestSMTDiff(t *testing.T) {
+	h := md5.New()
+	for _, arity := range []int{2, 4} {
+		a := NewSMTWithArity(arity, h, emptyHash)
+		err := a.Generate(testHashes[:10], 16)
+		assert.Nil(t, err)
+		b := NewSMTWithArity(arity, h, emptyHash)
+		err = b.Generate(testHashes[:10], 16)
+		assert.Nil(t, err)
+		diff, err := a.Diff(b)
+		assert.Nil(t, err)
+		assert.Empty(t, diff)
+
+		err = b.UpdateLeaf(3, testHashes[15])
+		assert.Nil(t, err)
+		err = b.DeleteLeaf(9)
+		assert.Nil(t, err)
+		_, err = b.AppendLeaf(testHashes[15])
+		assert.Nil(t, err)
+		diff, err = a.Diff(b)
+		assert.Nil(t, err)
+		assert.Equal(t, []uint{3, 9}, diff)
+		diff, err = b.Diff(a)
+		assert.Nil(t, err)
+		assert.Equal(t, []uint{3, 9}, diff)
+
+		full := NewSMTWithArity(arity, h, emptyHash)
+		err = full.Generate(testHashes, 16)
+		assert.Nil(t, err)
+		diff, err = a.Diff(full)
+		assert.Nil(t, err)
+		assert.Equal(t, []uint{10, 11, 12, 13, 14, 15}, diff)
+	}
+}
+
+func TestSMTDiffInvalidArgument(t *testing.T) {
+	h := md5.New()
+	a := NewSMT(emptyHash, h)
+	b := NewSMT(emptyHash, h)
+	_, err := a.Diff(b)
+	assert.Equal(t, "SMT tree is not filled", err.Error())
+	err = a.Generate(testHashes[:3], 8)
+	assert.Nil(t, err)
+	err = b.Generate(testHashes[:3], 16)
+	assert.Nil(t, err)
+	_, err = a.Diff(b)
+	assert.Equal(t, "Trees have a different shape", err.Error())
+}
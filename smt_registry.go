@@ -0,0 +1,65 @@
+package merkle
+
+import (
+	"crypto"
+	"errors"
+	"math"
+	"sync"
+)
+
+// Ladders of empty subtree hashes shared by the SMTs of NewSMTWithAlgorithm,
+// keyed by hash algorithm, arity and empty leaf
+var emptyHashRegistry = struct {
+	sync.Mutex
+	ladders map[emptyHashKey][]Hash
+}{ladders: map[emptyHashKey][]Hash{}}
+
+type emptyHashKey struct {
+	algorithm crypto.Hash
+	arity     int
+	emptyLeaf string
+}
+
+// NewSMTWithAlgorithm creates a SMT hashing its nodes with algorithm. The
+// empty subtree hashes are computed once per algorithm, arity and empty leaf
+// and shared by every SMT created this way.
+func NewSMTWithAlgorithm(arity int, algorithm crypto.Hash, emptyLeaf []byte) (*SMT, error) {
+	if !algorithm.Available() {
+		return nil, errors.New("Hash algorithm is not available")
+	}
+	if arity < 2 {
+		return nil, errors.New("Arity of SMT tree should be at least 2")
+	}
+	ladder, err := emptyHashLadder(arity, algorithm, emptyLeaf)
+	if err != nil {
+		return nil, err
+	}
+	tree := NewSMTWithArity(arity, algorithm.New(), emptyLeaf)
+	tree.emptyTreeRootHash = append([]Hash{}, ladder...)
+	return tree, nil
+}
+
+// Following are non public methods
+
+// Returns the registered ladder, computing it up to the height of the
+// largest tree whose leaves can be counted on 64 bits
+func emptyHashLadder(arity int, algorithm crypto.Hash, emptyLeaf []byte) ([]Hash, error) {
+	key := emptyHashKey{algorithm: algorithm, arity: arity, emptyLeaf: string(emptyLeaf)}
+	emptyHashRegistry.Lock()
+	defer emptyHashRegistry.Unlock()
+
+	if ladder, ok := emptyHashRegistry.ladders[key]; ok {
+		return ladder, nil
+	}
+	maxHeight := 1
+	for size := uint64(1); size <= math.MaxUint64/uint64(arity); size *= uint64(arity) {
+		maxHeight++
+	}
+	tree := NewSMTWithArity(arity, algorithm.New(), append([]byte{}, emptyLeaf...))
+	err := tree.computeEmptyLeavesSubTreeHash(tree.hashFunc, maxHeight)
+	if err != nil {
+		return nil, err
+	}
+	emptyHashRegistry.ladders[key] = tree.emptyTreeRootHash
+	return tree.emptyTreeRootHash, nil
+}
@@ -0,0 +1,52 @@
+package merkle
+
+import (
+	"crypto"
+	"crypto/md5"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSMTWithAlgorithm(t *testing.T) {
+	for _, arity := range []int{2, 4} {
+		tree := NewSMTWithArity(arity, md5.New(), emptyHash)
+		err := tree.Generate(testHashes[:5], 16)
+		assert.Nil(t, err)
+
+		shared, err := NewSMTWithAlgorithm(arity, crypto.MD5, emptyHash)
+		assert.Nil(t, err)
+		count := 0
+		shared.hashFunc = NewHashCountDecorator(shared.hashFunc, &count)
+		err = shared.Generate(testHashes[:5], 16)
+		assert.Nil(t, err)
+		assert.Equal(t, tree.RootHash(), shared.RootHash())
+		// the empty subtree hashes come from the registry
+		nonEmpty := 0
+		for _, level := range shared.fullNodes[1:] {
+			nonEmpty += len(level)
+		}
+		assert.Equal(t, nonEmpty, count)
+	}
+
+	// trees of the same algorithm share the ladder but not its slice
+	a, err := NewSMTWithAlgorithm(2, crypto.MD5, emptyHash)
+	assert.Nil(t, err)
+	b, err := NewSMTWithAlgorithm(2, crypto.MD5, emptyHash)
+	assert.Nil(t, err)
+	assert.Len(t, a.emptyTreeRootHash, 64)
+	assert.Equal(t, a.emptyTreeRootHash, b.emptyTreeRootHash)
+	a.emptyTreeRootHash[1] = nil
+	assert.NotNil(t, b.emptyTreeRootHash[1])
+
+	other, err := NewSMTWithAlgorithm(2, crypto.MD5, testHashes[0])
+	assert.Nil(t, err)
+	assert.NotEqual(t, b.emptyTreeRootHash[1], other.emptyTreeRootHash[1])
+}
+
+func TestSMTWithAlgorithmInvalidArgument(t *testing.T) {
+	_, err := NewSMTWithAlgorithm(2, crypto.Hash(0), emptyHash)
+	assert.Equal(t, "Hash algorithm is not available", err.Error())
+	_, err = NewSMTWithAlgorithm(1, crypto.MD5, emptyHash)
+	assert.Equal(t, "Arity of SMT tree should be at least 2", err.Error())
+}
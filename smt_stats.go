@@ -0,0 +1,57 @@
+package merkle
+
+import (
+	"bytes"
+	"sync/atomic"
+)
+
+// SMTStats tells how much hashing an SMT did and how much the cache of empty
+// subtree hashes saved
+type SMTStats struct {
+	// Number of hashes computed
+	HashCount uint64
+	// Number of bytes written to the hash function
+	BytesHashed uint64
+	// Number of times a cached empty subtree hash was used in place of a
+	// node
+	EmptyCacheHits uint64
+	// Number of stored nodes of each level that are not an empty subtree,
+	// from the leaves up
+	NonEmptyNodes []int
+}
+
+// Stats returns the counters of the tree since its creation or the last
+// ResetStats, and its current number of non-empty nodes per level
+func (self *SMT) Stats() SMTStats {
+	stats := SMTStats{
+		HashCount:      atomic.LoadUint64(&self.hashCount),
+		BytesHashed:    atomic.LoadUint64(&self.bytesHashed),
+		EmptyCacheHits: atomic.LoadUint64(&self.emptyCacheHits),
+		NonEmptyNodes:  make([]int, len(self.fullNodes)),
+	}
+	for level := range self.fullNodes {
+		for _, index := range self.storedIndices(level) {
+			hash, _ := self.storedNode(level, index)
+			if level >= len(self.emptyTreeRootHash) || !bytes.Equal(hash, self.emptyTreeRootHash[level]) {
+				stats.NonEmptyNodes[level]++
+			}
+		}
+	}
+	return stats
+}
+
+// ResetStats sets the counters of the tree back to zero
+func (self *SMT) ResetStats() {
+	atomic.StoreUint64(&self.hashCount, 0)
+	atomic.StoreUint64(&self.bytesHashed, 0)
+	atomic.StoreUint64(&self.emptyCacheHits, 0)
+}
+
+// Following are non public
+
+// Returns the cached hash of an empty subtree of the given height, counting
+// the use
+func (self *SMT) emptySubtree(height int) Hash {
+	atomic.AddUint64(&self.emptyCacheHits, 1)
+	return self.emptyTreeRootHash[height]
+}
@@ -0,0 +1,33 @@
+package merkle
+
+import (
+	"crypto/md5"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSMTStats(t *testing.T) {
+	h := md5.New()
+	tree := NewSMT(emptyHash, h)
+	err := tree.Generate(createDummyTreeData(5, h.Size(), true), 8)
+	assert.Nil(t, err)
+
+	stats := tree.Stats()
+	// 1 empty subtree hash, then 3 + 2 + 1 nodes
+	assert.Equal(t, uint64(7), stats.HashCount)
+	assert.Equal(t, uint64(7*2*h.Size()), stats.BytesHashed)
+	// the empty leaf next to leaf 4, the empty pair next to its parent
+	assert.Equal(t, uint64(2), stats.EmptyCacheHits)
+	assert.Equal(t, []int{5, 3, 2, 1}, stats.NonEmptyNodes)
+
+	tree.ResetStats()
+	_, err = tree.GetMerkleProof(7)
+	assert.Nil(t, err)
+	// only leaf 6 is not stored among the siblings of leaf 7
+	assert.Equal(t, SMTStats{EmptyCacheHits: 1, NonEmptyNodes: []int{5, 3, 2, 1}}, tree.Stats())
+
+	err = tree.DeleteLeaf(4)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{4, 2, 1, 1}, tree.Stats().NonEmptyNodes)
+}
@@ -3,8 +3,10 @@ package merkle
 import (
 	"crypto/md5"
 	"errors"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"hash"
+	"sync"
 	"testing"
 )
 
@@ -358,3 +360,945 @@ func TestGetMerkleProofs2(t *testing.T) {
 
 	assert.Equal(t, expectedProof, proof)
 }
+
+func hashValues(items [][]byte, hash hash.Hash) []byte {
+	defer hash.Reset()
+	for _, item := range items {
+		hash.Write(item)
+	}
+	return hash.Sum(nil)
+}
+
+func TestSMTWithArityInvalidArgument(t *testing.T) {
+	tree := NewSMTWithArity(4, hashFunc, emptyHash)
+	err := tree.Generate(testHashes, 8)
+	assert.Equal(t, "Leaves number of SMT tree should be power of arity", err.Error())
+
+	tree = NewSMTWithArity(1, hashFunc, emptyHash)
+	err = tree.Generate(testHashes, 16)
+	assert.Equal(t, "Arity of SMT tree should be at least 2", err.Error())
+}
+
+func TestSMTWithArity(t *testing.T) {
+	hash := hashFunc
+	items := testHashes[:5]
+	tree := NewSMTWithArity(4, hash, emptyHash)
+	err := tree.Generate(items, 16)
+	assert.Nil(t, err)
+
+	emptySubtree := hashValues([][]byte{emptyHash, emptyHash, emptyHash, emptyHash}, hash)
+	node0 := hashValues(testHashes[:4], hash)
+	node1 := hashValues([][]byte{testHashes[4], emptyHash, emptyHash, emptyHash}, hash)
+	expectedRoot := hashValues([][]byte{node0, node1, emptySubtree, emptySubtree}, hash)
+	assert.Equal(t, expectedRoot, tree.RootHash())
+
+	// arity 2 keeps producing the binary root
+	binary := NewSMTWithArity(2, hash, emptyHash)
+	err = binary.Generate(items, 16)
+	assert.Nil(t, err)
+	expected := NewSMT(emptyHash, hash)
+	err = expected.Generate(items, 16)
+	assert.Nil(t, err)
+	assert.Equal(t, expected.RootHash(), binary.RootHash())
+}
+
+func TestSMTWithArityEmptyLeaves(t *testing.T) {
+	hash := hashFunc
+	tree := NewSMTWithArity(4, hash, emptyHash)
+	err := tree.Generate(nil, 16)
+	assert.Nil(t, err)
+
+	emptySubtree := hashValues([][]byte{emptyHash, emptyHash, emptyHash, emptyHash}, hash)
+	expectedRoot := hashValues([][]byte{emptySubtree, emptySubtree, emptySubtree, emptySubtree}, hash)
+	assert.Equal(t, expectedRoot, tree.RootHash())
+}
+
+func TestGetKaryMerkleProof(t *testing.T) {
+	hash := hashFunc
+	items := testHashes[:5]
+	tree := NewSMTWithArity(4, hash, emptyHash)
+	_, err := tree.GetKaryMerkleProof(0)
+	assert.Equal(t, "SMT tree is not filled", err.Error())
+
+	err = tree.Generate(items, 16)
+	assert.Nil(t, err)
+
+	_, err = tree.GetMerkleProof(0)
+	assert.Equal(t, "Binary proofs need an arity of 2, use GetKaryMerkleProof", err.Error())
+
+	emptySubtree := hashValues([][]byte{emptyHash, emptyHash, emptyHash, emptyHash}, hash)
+	node0 := hashValues(testHashes[:4], hash)
+
+	// a 16 leaves binary tree would need 4 proof nodes
+	proof, err := tree.GetKaryMerkleProof(5)
+	assert.Nil(t, err)
+	expectedProof := []KaryProofNode{
+		{Siblings: [][]byte{testHashes[4], emptyHash, emptyHash}},
+		{Siblings: [][]byte{node0, emptySubtree, emptySubtree}},
+	}
+	assert.Equal(t, expectedProof, proof)
+
+	// the proof of an existing leaf folds to the root
+	proof, err = tree.GetKaryMerkleProof(2)
+	assert.Nil(t, err)
+	assert.Len(t, proof, 2)
+	current := testHashes[2]
+	index := 2
+	for _, node := range proof {
+		position := index % 4
+		children := append([][]byte{}, node.Siblings[:position]...)
+		children = append(children, current)
+		children = append(children, node.Siblings[position:]...)
+		current = hashValues(children, hash)
+		index = index / 4
+	}
+	assert.Equal(t, tree.RootHash(), current)
+}
+
+func TestProveEmptyRange(t *testing.T) {
+	hash := hashFunc
+	tree := NewSMT(emptyHash, hash)
+	_, err := tree.ProveEmptyRange(0, 0)
+	assert.Equal(t, "SMT tree is not filled", err.Error())
+
+	err = tree.Generate(testHashes[:5], 16)
+	assert.Nil(t, err)
+
+	inputs := []struct {
+		start, end uint
+		length     int
+	}{
+		{8, 15, 1},
+		{6, 7, 3},
+		{12, 13, 3},
+		{5, 5, 4},
+		{10, 11, 3},
+	}
+	for _, in := range inputs {
+		proof, err := tree.ProveEmptyRange(in.start, in.end)
+		assert.Nil(t, err)
+		assert.Len(t, proof, in.length)
+		ok, err := VerifyEmptyRange(in.start, in.end, 16, proof, tree.RootHash(), emptyHash, hash)
+		assert.Nil(t, err)
+		assert.True(t, ok, fmt.Sprintf("VerifyEmptyRange(%d, %d)", in.start, in.end))
+	}
+
+	// the proof of a range does not verify another range
+	proof, err := tree.ProveEmptyRange(6, 7)
+	assert.Nil(t, err)
+	ok, err := VerifyEmptyRange(12, 13, 16, proof, tree.RootHash(), emptyHash, hash)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = VerifyEmptyRange(6, 7, 32, proof, tree.RootHash(), emptyHash, hash)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// a range holding a set leaf is rejected
+	_, err = tree.ProveEmptyRange(4, 7)
+	assert.Equal(t, "Range is not covered by an empty subtree", err.Error())
+	_, err = tree.ProveEmptyRange(0, 15)
+	assert.Equal(t, "Range is not covered by an empty subtree", err.Error())
+	_, err = tree.ProveEmptyRange(7, 6)
+	assert.Equal(t, "Range is out of bounds", err.Error())
+	_, err = tree.ProveEmptyRange(8, 16)
+	assert.Equal(t, "Range is out of bounds", err.Error())
+	_, err = VerifyEmptyRange(6, 7, 12, proof, tree.RootHash(), emptyHash, hash)
+	assert.Equal(t, "Leaves number of SMT tree should be power of 2", err.Error())
+
+	// all ranges of an empty tree are empty
+	tree = NewSMT(emptyHash, hash)
+	err = tree.Generate(nil, 8)
+	assert.Nil(t, err)
+	proof, err = tree.ProveEmptyRange(0, 7)
+	assert.Nil(t, err)
+	assert.Len(t, proof, 0)
+	ok, err = VerifyEmptyRange(0, 7, 8, proof, tree.RootHash(), emptyHash, hash)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func TestSMTVerifyProof(t *testing.T) {
+	hash := hashFunc
+	tree := NewSMT(emptyHash, hash)
+	_, err := tree.VerifyProof(0, testHashes[0], nil)
+	assert.Equal(t, "SMT tree is not filled", err.Error())
+
+	err = tree.Generate(testHashes[:5], 8)
+	assert.Nil(t, err)
+
+	for i := uint(0); i < 8; i++ {
+		var leaf []byte
+		if i < 5 {
+			leaf = testHashes[i]
+		}
+		proof, err := tree.GetMerkleProof(i)
+		assert.Nil(t, err)
+		ok, err := tree.VerifyProof(i, leaf, proof)
+		assert.Nil(t, err)
+		assert.True(t, ok, fmt.Sprintf("VerifyProof(%d)", i))
+		ok, err = VerifySMTProof(tree.RootHash(), i, leaf, proof, emptyHash, hash)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+
+		// another leaf, or the same leaf at another index, does not verify
+		ok, err = tree.VerifyProof(i, testHashes[7], proof)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+		ok, err = tree.VerifyProof(i^1, leaf, proof)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	}
+
+	proof, err := tree.GetMerkleProof(2)
+	assert.Nil(t, err)
+	ok, err := tree.VerifyProof(2, testHashes[2], proof[:2])
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = VerifySMTProof(tree.RootHash(), 6, testHashes[2], proof[:2], emptyHash, hash)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	_, err = tree.VerifyProof(8, testHashes[2], proof)
+	assert.Equal(t, "Leaf index is out of bounds", err.Error())
+}
+
+func TestGetNonMembershipProof(t *testing.T) {
+	hash := hashFunc
+	tree := NewSMT(emptyHash, hash)
+	_, err := tree.GetNonMembershipProof(0)
+	assert.Equal(t, "SMT tree is not filled", err.Error())
+
+	err = tree.Generate(testHashes[:5], 16)
+	assert.Nil(t, err)
+
+	for i := uint(5); i < 16; i++ {
+		proof, err := tree.GetNonMembershipProof(i)
+		assert.Nil(t, err)
+		ok, err := VerifyNonMembershipProof(tree.RootHash(), i, proof, emptyHash, hash)
+		assert.Nil(t, err)
+		assert.True(t, ok, fmt.Sprintf("VerifyNonMembershipProof(%d)", i))
+	}
+
+	// the proof of a set leaf does not show it is empty
+	proof, err := tree.GetMerkleProof(3)
+	assert.Nil(t, err)
+	ok, err := VerifyNonMembershipProof(tree.RootHash(), 3, proof, emptyHash, hash)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	_, err = tree.GetNonMembershipProof(4)
+	assert.Equal(t, "Leaf is not empty", err.Error())
+	_, err = tree.GetNonMembershipProof(16)
+	assert.Equal(t, "Leaf index is out of bounds", err.Error())
+}
+
+func TestSMTUpdateLeaf(t *testing.T) {
+	for _, arity := range []int{2, 4} {
+		h := md5.New()
+		emptyHash := make([]byte, h.Size())
+		data := createDummyTreeData(11, h.Size(), true)
+		tree := NewSMTWithArity(arity, h, emptyHash)
+		err := tree.Generate(data, 16)
+		assert.Nil(t, err)
+
+		for _, index := range []int{0, 5, 10} {
+			data[index] = hashValue(data[index], h)
+			err = tree.UpdateLeaf(uint(index), data[index])
+			assert.Nil(t, err)
+
+			expected := NewSMTWithArity(arity, h, emptyHash)
+			err = expected.Generate(data, 16)
+			assert.Nil(t, err)
+			assert.Equal(t, expected.RootHash(), tree.RootHash())
+			assert.Equal(t, expected.fullNodes, tree.fullNodes)
+		}
+	}
+}
+
+func TestSMTUpdateLeafInvalidArgument(t *testing.T) {
+	h := md5.New()
+	emptyHash := make([]byte, h.Size())
+	tree := NewSMT(emptyHash, h)
+	err := tree.UpdateLeaf(0, emptyHash)
+	assert.Equal(t, "SMT tree is not filled", err.Error())
+
+	data := createDummyTreeData(3, h.Size(), true)
+	err = tree.Generate(data, 4)
+	assert.Nil(t, err)
+	root := tree.RootHash()
+	err = tree.UpdateLeaf(3, emptyHash)
+	assert.Equal(t, "Leaf is empty, only non-empty leaves can be updated", err.Error())
+	err = tree.UpdateLeaf(0, nil)
+	assert.Equal(t, "leaves should not be nil", err.Error())
+
+	tree.hashFunc = NewFailingHash()
+	err = tree.UpdateLeaf(0, emptyHash)
+	assert.NotNil(t, err)
+	assert.Equal(t, root, tree.RootHash())
+	assert.Equal(t, Hash(data[0]), tree.fullNodes[0][0])
+}
+
+func TestSMTUpdateLeaves(t *testing.T) {
+	for _, arity := range []int{2, 4} {
+		h := md5.New()
+		emptyHash := make([]byte, h.Size())
+		data := createDummyTreeData(11, h.Size(), true)
+		tree := NewSMTWithArity(arity, h, emptyHash)
+		err := tree.Generate(data, 16)
+		assert.Nil(t, err)
+
+		updates := map[uint][]byte{}
+		for _, index := range []uint{0, 1, 6, 10} {
+			data[index] = hashValue(data[index], h)
+			updates[index] = data[index]
+		}
+		err = tree.UpdateLeaves(updates)
+		assert.Nil(t, err)
+
+		expected := NewSMTWithArity(arity, h, emptyHash)
+		err = expected.Generate(data, 16)
+		assert.Nil(t, err)
+		assert.Equal(t, expected.fullNodes, tree.fullNodes)
+		assert.Equal(t, expected.RootHash(), tree.RootHash())
+	}
+}
+
+func TestSMTUpdateLeavesSharesAncestors(t *testing.T) {
+	h := md5.New()
+	emptyHash := make([]byte, h.Size())
+	tree := NewSMT(emptyHash, h)
+	err := tree.Generate(createDummyTreeData(8, h.Size(), true), 8)
+	assert.Nil(t, err)
+
+	count := 0
+	tree.hashFunc = NewHashCountDecorator(h, &count)
+	err = tree.UpdateLeaves(map[uint][]byte{0: emptyHash, 1: emptyHash, 2: emptyHash})
+	assert.Nil(t, err)
+	// 2 hashes on the level of the leaves, then one per level
+	assert.Equal(t, 4, count)
+}
+
+func TestSMTUpdateLeavesInvalidArgument(t *testing.T) {
+	h := md5.New()
+	emptyHash := make([]byte, h.Size())
+	tree := NewSMT(emptyHash, h)
+	err := tree.UpdateLeaves(map[uint][]byte{0: emptyHash})
+	assert.Equal(t, "SMT tree is not filled", err.Error())
+
+	data := createDummyTreeData(3, h.Size(), true)
+	err = tree.Generate(data, 4)
+	assert.Nil(t, err)
+	root := tree.RootHash()
+	err = tree.UpdateLeaves(map[uint][]byte{0: emptyHash, 3: emptyHash})
+	assert.Equal(t, "Leaf is empty, only non-empty leaves can be updated", err.Error())
+	err = tree.UpdateLeaves(map[uint][]byte{0: nil})
+	assert.Equal(t, "leaves should not be nil", err.Error())
+
+	tree.hashFunc = NewFailingHash()
+	err = tree.UpdateLeaves(map[uint][]byte{0: emptyHash, 2: emptyHash})
+	assert.NotNil(t, err)
+	assert.Equal(t, root, tree.RootHash())
+	assert.Equal(t, Hash(data[0]), tree.fullNodes[0][0])
+}
+
+func TestSMTEmptySubtreeRoot(t *testing.T) {
+	h := md5.New()
+	// a default leaf other than the hash of an empty value
+	defaultLeaf := hashValue([]byte("zero amount"), h)
+	tree := NewSMT(defaultLeaf, h)
+	assert.Equal(t, defaultLeaf, tree.EmptyLeaf())
+
+	expected := []byte(defaultLeaf)
+	for height := 0; height < 5; height++ {
+		root, err := tree.EmptySubtreeRoot(height)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, root)
+		expected = hash2Value(expected, expected, h)
+	}
+	_, err := tree.EmptySubtreeRoot(-1)
+	assert.Equal(t, "Height should not be negative", err.Error())
+
+	// the cache extended above is reused by Generate
+	err = tree.Generate(nil, 8)
+	assert.Nil(t, err)
+	root, err := tree.EmptySubtreeRoot(3)
+	assert.Nil(t, err)
+	assert.Equal(t, root, tree.RootHash())
+
+	tree = NewSMT(defaultLeaf, h)
+	err = tree.Generate([][]byte{testHashes[0]}, 4)
+	assert.Nil(t, err)
+	emptyPair := hash2Value(defaultLeaf, defaultLeaf, h)
+	assert.Equal(t, hash2Value(hash2Value(testHashes[0], defaultLeaf, h), emptyPair, h), tree.RootHash())
+}
+
+func TestSMTGenerateSparse(t *testing.T) {
+	for _, arity := range []int{2, 4} {
+		h := md5.New()
+		leaves := map[uint64][]byte{1: testHashes[1], 6: testHashes[6], 9: testHashes[9]}
+		tree := NewSMTWithArity(arity, h, emptyHash)
+		err := tree.GenerateSparse(leaves, 16)
+		assert.Nil(t, err)
+
+		dense := make([][]byte, 10)
+		for i := range dense {
+			dense[i] = emptyHash
+			if leaf, ok := leaves[uint64(i)]; ok {
+				dense[i] = leaf
+			}
+		}
+		expected := NewSMTWithArity(arity, h, emptyHash)
+		err = expected.Generate(dense, 16)
+		assert.Nil(t, err)
+		assert.Equal(t, expected.RootHash(), tree.RootHash())
+		for level := range expected.fullNodes {
+			assert.Equal(t, expected.levelWidth(level), tree.levelWidth(level))
+			for i := range expected.fullNodes[level] {
+				assert.Equal(t, expected.fullNodes[level][i], tree.node(level, i))
+			}
+		}
+	}
+}
+
+func TestSMTGenerateSparseLargeIndex(t *testing.T) {
+	h := md5.New()
+	tree := NewSMT(emptyHash, h)
+	count := 0
+	tree.hashFunc = NewHashCountDecorator(h, &count)
+	last := uint64(1)<<40 - 1
+	err := tree.GenerateSparse(map[uint64][]byte{3: testHashes[3], last: testHashes[15]}, 1<<40)
+	assert.Nil(t, err)
+	// 40 empty subtree hashes, then 2 paths of 40 nodes sharing the root
+	assert.Equal(t, 40+79, count)
+	for _, level := range tree.sparseNodes[:40] {
+		assert.Len(t, level, 2)
+	}
+
+	for index, leaf := range map[uint64][]byte{3: testHashes[3], last: testHashes[15], 1 << 20: nil} {
+		proof, err := tree.GetMerkleProof(uint(index))
+		assert.Nil(t, err)
+		ok, err := VerifySMTProof(tree.RootHash(), uint(index), leaf, proof, emptyHash, h)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	}
+
+	err = tree.EnableHistory()
+	assert.Nil(t, err)
+	root := tree.RootHash()
+	err = tree.UpdateLeaf(3, testHashes[4])
+	assert.Nil(t, err)
+	err = tree.DeleteLeaf(uint(last))
+	assert.Nil(t, err)
+	assert.Equal(t, 4, tree.countOfNonEmptyLeaves)
+	expected := NewSMT(emptyHash, h)
+	err = expected.GenerateSparse(map[uint64][]byte{3: testHashes[4]}, 1<<40)
+	assert.Nil(t, err)
+	assert.Equal(t, expected.RootHash(), tree.RootHash())
+	index, err := tree.AppendLeaf(testHashes[5])
+	assert.Nil(t, err)
+	assert.Equal(t, uint(4), index)
+
+	err = tree.Rollback(0)
+	assert.Nil(t, err)
+	assert.Equal(t, root, tree.RootHash())
+	leaf, err := tree.GetLeafHash(uint(last))
+	assert.Nil(t, err)
+	assert.Equal(t, testHashes[15], leaf)
+
+	data, err := tree.Marshal()
+	assert.Nil(t, err)
+	restored, err := UnmarshalSMT(data, h)
+	assert.Nil(t, err)
+	assert.Equal(t, root, restored.RootHash())
+	clone := restored.Clone()
+	err = clone.UpdateLeaf(3, testHashes[6])
+	assert.Nil(t, err)
+	assert.Equal(t, root, restored.RootHash())
+}
+
+func TestSMTGenerateSparseGaps(t *testing.T) {
+	h := md5.New()
+	tree := NewSMT(emptyHash, h)
+	count := 0
+	tree.hashFunc = NewHashCountDecorator(h, &count)
+	err := tree.GenerateSparse(map[uint64][]byte{0: testHashes[0], 7: testHashes[7]}, 8)
+	assert.Nil(t, err)
+	// 3 empty subtree hashes, then the 2 paths share the root
+	assert.Equal(t, 8, count)
+
+	proof, err := tree.GetNonMembershipProof(3)
+	assert.Nil(t, err)
+	ok, err := VerifyNonMembershipProof(tree.RootHash(), 3, proof, emptyHash, h)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, err = tree.GetNonMembershipProof(7)
+	assert.Equal(t, "Leaf is not empty", err.Error())
+
+	empty := NewSMT(emptyHash, h)
+	err = empty.GenerateSparse(nil, 8)
+	assert.Nil(t, err)
+	expected := NewSMT(emptyHash, h)
+	err = expected.Generate(nil, 8)
+	assert.Nil(t, err)
+	assert.Equal(t, expected.RootHash(), empty.RootHash())
+}
+
+func TestSMTGenerateSparseInvalidArgument(t *testing.T) {
+	h := md5.New()
+	tree := NewSMT(emptyHash, h)
+	err := tree.GenerateSparse(map[uint64][]byte{8: testHashes[0]}, 8)
+	assert.Equal(t, "Leaf index is out of bounds", err.Error())
+	err = tree.GenerateSparse(map[uint64][]byte{0: nil}, 8)
+	assert.Equal(t, "leaves should not be nil", err.Error())
+	err = tree.GenerateSparse(nil, 6)
+	assert.Equal(t, "Leaves number of SMT tree should be power of 2", err.Error())
+	err = tree.GenerateSparse(nil, 8)
+	assert.Nil(t, err)
+	err = tree.GenerateSparse(nil, 8)
+	assert.Equal(t, "SMT tree already filled", err.Error())
+}
+
+func TestSMTDeleteLeaf(t *testing.T) {
+	for _, arity := range []int{2, 4} {
+		h := md5.New()
+		data := createDummyTreeData(7, h.Size(), true)
+		tree := NewSMTWithArity(arity, h, emptyHash)
+		err := tree.Generate(data, 16)
+		assert.Nil(t, err)
+
+		// a leaf in the middle stays stored as an empty leaf
+		err = tree.DeleteLeaf(2)
+		assert.Nil(t, err)
+		data[2] = emptyHash
+		expected := NewSMTWithArity(arity, h, emptyHash)
+		err = expected.Generate(data, 16)
+		assert.Nil(t, err)
+		assert.Equal(t, expected.fullNodes, tree.fullNodes)
+		assert.Equal(t, 7, tree.countOfNonEmptyLeaves)
+
+		// deleting the last leaves shrinks the tree
+		err = tree.DeleteLeaf(6)
+		assert.Nil(t, err)
+		expected = NewSMTWithArity(arity, h, emptyHash)
+		err = expected.Generate(data[:6], 16)
+		assert.Nil(t, err)
+		assert.Equal(t, expected.fullNodes, tree.fullNodes)
+		assert.Equal(t, expected.RootHash(), tree.RootHash())
+
+		err = tree.DeleteLeaf(12)
+		assert.Nil(t, err)
+		assert.Equal(t, expected.RootHash(), tree.RootHash())
+
+		for _, index := range []uint{0, 1, 3, 4, 5} {
+			err = tree.DeleteLeaf(index)
+			assert.Nil(t, err)
+		}
+		assert.Equal(t, 0, tree.countOfNonEmptyLeaves)
+		expected = NewSMTWithArity(arity, h, emptyHash)
+		err = expected.Generate(nil, 16)
+		assert.Nil(t, err)
+		assert.Equal(t, expected.RootHash(), tree.RootHash())
+	}
+}
+
+func TestSMTDeleteLeafInvalidArgument(t *testing.T) {
+	h := md5.New()
+	tree := NewSMT(emptyHash, h)
+	err := tree.DeleteLeaf(0)
+	assert.Equal(t, "SMT tree is not filled", err.Error())
+	err = tree.Generate(createDummyTreeData(3, h.Size(), true), 4)
+	assert.Nil(t, err)
+	err = tree.DeleteLeaf(4)
+	assert.Equal(t, "Leaf index is out of bounds", err.Error())
+}
+
+func TestSMTGetMerkleProofEmptyRegion(t *testing.T) {
+	h := md5.New()
+	for _, count := range []int{0, 1, 3, 5, 8} {
+		tree := NewSMT(emptyHash, h)
+		data := createDummyTreeData(count, h.Size(), true)
+		err := tree.Generate(data, 8)
+		assert.Nil(t, err)
+
+		for i := 0; i < 8; i++ {
+			proof, err := tree.GetMerkleProof(uint(i))
+			assert.Nil(t, err)
+			assert.Len(t, proof, 3)
+			var leaf []byte
+			if i < count {
+				leaf = data[i]
+			}
+			ok, err := VerifySMTProof(tree.RootHash(), uint(i), leaf, proof, emptyHash, h)
+			assert.Nil(t, err)
+			assert.True(t, ok)
+		}
+
+		_, err = tree.GetMerkleProof(8)
+		assert.Equal(t, "Leaf index is out of bounds", err.Error())
+	}
+
+	tree := NewSMTWithArity(4, h, emptyHash)
+	err := tree.Generate(createDummyTreeData(5, h.Size(), true), 16)
+	assert.Nil(t, err)
+	proof, err := tree.GetKaryMerkleProof(15)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{emptyHash, emptyHash, emptyHash}, proof[0].Siblings)
+	_, err = tree.GetKaryMerkleProof(16)
+	assert.Equal(t, "Leaf index is out of bounds", err.Error())
+}
+
+func TestSMTGeneratePadded(t *testing.T) {
+	h := md5.New()
+	data := createDummyTreeData(5, h.Size(), true)
+	for _, c := range []struct {
+		arity, totalSize, padded int
+	}{{2, 5, 8}, {2, 6, 8}, {2, 8, 8}, {3, 10, 27}, {4, 1, 1}} {
+		tree := NewSMTWithArity(c.arity, h, emptyHash)
+		err := tree.GeneratePadded(data[:1], c.totalSize)
+		assert.Nil(t, err)
+		expected := NewSMTWithArity(c.arity, h, emptyHash)
+		err = expected.Generate(data[:1], c.padded)
+		assert.Nil(t, err)
+		assert.Equal(t, expected.RootHash(), tree.RootHash())
+		assert.Equal(t, uint64(c.padded), tree.totalSize())
+	}
+
+	tree := NewSMT(emptyHash, h)
+	err := tree.GeneratePadded(data, 3)
+	assert.Equal(t, "NonEmptyLeaves is bigger than totalSize", err.Error())
+	err = tree.GeneratePadded(nil, 0)
+	assert.Equal(t, "Leaves number of SMT tree should be power of 2", err.Error())
+	err = NewSMTWithArity(3, h, emptyHash).GeneratePadded(data, int(^uint(0)>>1))
+	assert.Equal(t, "next power overflows", err.Error())
+}
+
+func TestSMTGetLeafHash(t *testing.T) {
+	h := md5.New()
+	tree := NewSMT(emptyHash, h)
+	_, err := tree.GetLeafHash(0)
+	assert.Equal(t, "SMT tree is not filled", err.Error())
+
+	data := createDummyTreeData(5, h.Size(), true)
+	err = tree.Generate(data, 8)
+	assert.Nil(t, err)
+	for i := 0; i < 8; i++ {
+		leaf, err := tree.GetLeafHash(uint(i))
+		assert.Nil(t, err)
+		proof, err := tree.GetMerkleProof(uint(i))
+		assert.Nil(t, err)
+		ok, err := tree.VerifyProof(uint(i), leaf, proof)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		if i < 5 {
+			assert.Equal(t, data[i], leaf)
+		} else {
+			assert.Equal(t, emptyHash, leaf)
+		}
+	}
+	_, err = tree.GetLeafHash(8)
+	assert.Equal(t, "Leaf index is out of bounds", err.Error())
+}
+
+func TestSMTGenerateFromValues(t *testing.T) {
+	h := md5.New()
+	values := [][]byte{}
+	leaves := [][]byte{}
+	for i := 0; i < 100; i++ {
+		value := []byte(fmt.Sprintf("field %d", i))
+		values = append(values, value)
+		leaves = append(leaves, hashValue(value, h))
+	}
+	tree := NewSMT(emptyHash, h)
+	err := tree.GenerateFromValues(values, 128, md5.New)
+	assert.Nil(t, err)
+	expected := NewSMT(emptyHash, h)
+	err = expected.Generate(leaves, 128)
+	assert.Nil(t, err)
+	assert.Equal(t, expected.fullNodes, tree.fullNodes)
+
+	empty := NewSMT(emptyHash, h)
+	err = empty.GenerateFromValues(nil, 4, md5.New)
+	assert.Nil(t, err)
+
+	failing := NewSMT(emptyHash, h)
+	err = failing.GenerateFromValues(values, 128, func() hash.Hash {
+		count := 0
+		return NewHashCountErrorDecorator(md5.New(), &count, 3)
+	})
+	assert.Equal(t, "Hash error", err.Error())
+	assert.Nil(t, failing.RootHash())
+}
+
+func TestSMTGenerateFromChannel(t *testing.T) {
+	h := md5.New()
+	for _, arity := range []int{2, 4} {
+		for _, count := range []int{0, 1, 5, 16} {
+			data := createDummyTreeData(count, h.Size(), true)
+			leaves := make(chan []byte)
+			go func() {
+				for _, leaf := range data {
+					leaves <- leaf
+				}
+				close(leaves)
+			}()
+			tree := NewSMTWithArity(arity, h, emptyHash)
+			err := tree.GenerateFromChannel(leaves, 16)
+			assert.Nil(t, err)
+
+			expected := NewSMTWithArity(arity, h, emptyHash)
+			err = expected.Generate(data, 16)
+			assert.Nil(t, err)
+			assert.Equal(t, expected.fullNodes, tree.fullNodes)
+			assert.Equal(t, expected.RootHash(), tree.RootHash())
+		}
+	}
+}
+
+func TestSMTGenerateFromChannelInvalidArgument(t *testing.T) {
+	h := md5.New()
+	send := func(leaves ...[]byte) <-chan []byte {
+		channel := make(chan []byte)
+		go func() {
+			for _, leaf := range leaves {
+				channel <- leaf
+			}
+			close(channel)
+		}()
+		return channel
+	}
+
+	tree := NewSMT(emptyHash, h)
+	err := tree.GenerateFromChannel(send(testHashes[:5]...), 4)
+	assert.Equal(t, "NonEmptyLeaves is bigger than totalSize", err.Error())
+	err = tree.GenerateFromChannel(send(testHashes[0], nil, testHashes[1]), 4)
+	assert.Equal(t, "leaves should not be nil", err.Error())
+	err = tree.GenerateFromChannel(send(testHashes[0]), 3)
+	assert.Equal(t, "Leaves number of SMT tree should be power of 2", err.Error())
+	assert.Nil(t, tree.RootHash())
+
+	tree.hashFunc = NewFailingHash()
+	err = tree.GenerateFromChannel(send(testHashes[:4]...), 4)
+	assert.NotNil(t, err)
+	assert.Nil(t, tree.RootHash())
+}
+
+func TestSMTAppendLeaf(t *testing.T) {
+	h := md5.New()
+	for _, arity := range []int{2, 4} {
+		data := createDummyTreeData(16, h.Size(), true)
+		tree := NewSMTWithArity(arity, h, emptyHash)
+		err := tree.Generate(nil, 16)
+		assert.Nil(t, err)
+
+		for i, leaf := range data {
+			index, err := tree.AppendLeaf(leaf)
+			assert.Nil(t, err)
+			assert.Equal(t, uint(i), index)
+
+			expected := NewSMTWithArity(arity, h, emptyHash)
+			err = expected.Generate(data[:i+1], 16)
+			assert.Nil(t, err)
+			assert.Equal(t, expected.fullNodes, tree.fullNodes)
+			assert.Equal(t, expected.RootHash(), tree.RootHash())
+		}
+		_, err = tree.AppendLeaf(emptyHash)
+		assert.Equal(t, "SMT tree is full", err.Error())
+	}
+}
+
+func TestSMTAppendLeafAfterDelete(t *testing.T) {
+	h := md5.New()
+	data := createDummyTreeData(6, h.Size(), true)
+	tree := NewSMT(emptyHash, h)
+	err := tree.Generate(data, 8)
+	assert.Nil(t, err)
+	err = tree.EnableHistory()
+	assert.Nil(t, err)
+	root := tree.RootHash()
+
+	err = tree.DeleteLeaf(5)
+	assert.Nil(t, err)
+	err = tree.DeleteLeaf(4)
+	assert.Nil(t, err)
+	index, err := tree.AppendLeaf(data[4])
+	assert.Nil(t, err)
+	assert.Equal(t, uint(4), index)
+	index, err = tree.AppendLeaf(data[5])
+	assert.Nil(t, err)
+	assert.Equal(t, uint(5), index)
+	assert.Equal(t, root, tree.RootHash())
+
+	_, err = tree.AppendLeaf(data[0])
+	assert.Nil(t, err)
+	err = tree.Rollback(3)
+	assert.Nil(t, err)
+	expected := NewSMT(emptyHash, h)
+	err = expected.Generate(data[:5], 8)
+	assert.Nil(t, err)
+	assert.Equal(t, expected.fullNodes, tree.fullNodes)
+	assert.Equal(t, 5, tree.countOfNonEmptyLeaves)
+
+	_, err = tree.AppendLeaf(nil)
+	assert.Equal(t, "leaves should not be nil", err.Error())
+	_, err = NewSMT(emptyHash, h).AppendLeaf(data[0])
+	assert.Equal(t, "SMT tree is not filled", err.Error())
+}
+
+func TestNewSMTWithHashConstructor(t *testing.T) {
+	h := md5.New()
+	data := createDummyTreeData(5, h.Size(), true)
+	expected := NewSMT(emptyHash, h)
+	err := expected.Generate(data, 8)
+	assert.Nil(t, err)
+
+	trees := make([]*SMT, 4)
+	errs := make([]error, len(trees))
+	var wg sync.WaitGroup
+	for i := range trees {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			trees[i] = NewSMTWithHashConstructor(2, md5.New, emptyHash)
+			errs[i] = trees[i].Generate(data, 8)
+		}(i)
+	}
+	wg.Wait()
+	for i, tree := range trees {
+		assert.Nil(t, errs[i])
+		assert.Equal(t, expected.RootHash(), tree.RootHash())
+	}
+
+	tree := trees[0]
+	results := make([]bool, 8)
+	verifyErrs := make([]error, len(results))
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			leaf, _ := tree.GetLeafHash(uint(i))
+			proof, _ := tree.GetMerkleProof(uint(i))
+			results[i], verifyErrs[i] = tree.VerifyProof(uint(i), leaf, proof)
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, make([]error, len(results)), verifyErrs)
+	assert.Equal(t, []bool{true, true, true, true, true, true, true, true}, results)
+
+	// one instance per operation, not per hash
+	created := 0
+	counted := NewSMTWithHashConstructor(2, func() hash.Hash {
+		created++
+		return md5.New()
+	}, emptyHash)
+	created = 0
+	err = counted.Generate(data, 8)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, created)
+	err = counted.UpdateLeaves(map[uint][]byte{0: data[1], 3: data[2]})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, created)
+}
+
+func TestSMTReset(t *testing.T) {
+	h := md5.New()
+	tree := NewSMT(emptyHash, h)
+	err := tree.Generate(createDummyTreeData(6, h.Size(), true), 8)
+	assert.Nil(t, err)
+	leaves := &tree.fullNodes[0][0]
+	cache := tree.emptyTreeRootHash
+
+	tree.Reset()
+	assert.Nil(t, tree.RootHash())
+	_, err = tree.GetMerkleProof(0)
+	assert.Equal(t, "SMT tree is not filled", err.Error())
+
+	data := createDummyTreeData(3, h.Size(), false)
+	err = tree.Generate(data, 8)
+	assert.Nil(t, err)
+	expected := NewSMT(emptyHash, h)
+	err = expected.Generate(data, 8)
+	assert.Nil(t, err)
+	assert.Equal(t, expected.fullNodes, tree.fullNodes)
+	assert.Equal(t, expected.RootHash(), tree.RootHash())
+	// the memory of the levels and the empty subtree hashes are reused
+	assert.True(t, leaves == &tree.fullNodes[0][0])
+	assert.Equal(t, cache, tree.emptyTreeRootHash[:len(cache)])
+
+	tree.Reset()
+	err = tree.Generate(nil, 16)
+	assert.Nil(t, err)
+	expected = NewSMT(emptyHash, h)
+	err = expected.Generate(nil, 16)
+	assert.Nil(t, err)
+	assert.Equal(t, expected.RootHash(), tree.RootHash())
+}
+
+func TestSMTGenerateLeaves(t *testing.T) {
+	h := md5.New()
+	leaves := []Leaf{
+		{Data: []byte("raw field"), PreHashed: false},
+		{Data: testHashes[1], PreHashed: true},
+		{Data: []byte{}, PreHashed: false},
+	}
+	tree := NewSMT(emptyHash, h)
+	err := tree.GenerateLeaves(leaves, 4)
+	assert.Nil(t, err)
+	expected := NewSMT(emptyHash, h)
+	err = expected.Generate([][]byte{hashValue([]byte("raw field"), h), testHashes[1], hashValue([]byte{}, h)}, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, expected.RootHash(), tree.RootHash())
+
+	err = NewSMT(emptyHash, h).GenerateLeaves([]Leaf{{PreHashed: true}}, 4)
+	assert.Equal(t, "leaves should not be nil", err.Error())
+	failing := NewSMT(emptyHash, NewFailingHash())
+	err = failing.GenerateLeaves(leaves, 4)
+	assert.NotNil(t, err)
+	assert.Nil(t, failing.RootHash())
+}
+
+func TestSMTClone(t *testing.T) {
+	h := md5.New()
+	data := createDummyTreeData(5, h.Size(), true)
+	tree := NewSMT(emptyHash, h)
+	err := tree.Generate(data, 8)
+	assert.Nil(t, err)
+	err = tree.EnableHistory()
+	assert.Nil(t, err)
+	err = tree.UpdateLeaf(0, testHashes[8])
+	assert.Nil(t, err)
+
+	clone := tree.Clone()
+	assert.Equal(t, tree, clone)
+	root := clone.RootHash()
+	proof, err := clone.GetMerkleProof(1)
+	assert.Nil(t, err)
+
+	err = tree.UpdateLeaf(1, testHashes[9])
+	assert.Nil(t, err)
+	_, err = tree.AppendLeaf(testHashes[10])
+	assert.Nil(t, err)
+	err = tree.DeleteLeaf(0)
+	assert.Nil(t, err)
+	assert.NotEqual(t, root, tree.RootHash())
+
+	// the clone still serves the proofs of its state
+	assert.Equal(t, root, clone.RootHash())
+	ok, err := clone.VerifyProof(1, data[1], proof)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	version, err := clone.Version()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), version)
+
+	err = clone.Rollback(0)
+	assert.Nil(t, err)
+	expected := NewSMT(emptyHash, h)
+	err = expected.Generate(data, 8)
+	assert.Nil(t, err)
+	assert.Equal(t, expected.RootHash(), clone.RootHash())
+	rootAt, err := tree.RootAt(1)
+	assert.Nil(t, err)
+	assert.Equal(t, root, rootAt)
+
+	withConstructor := NewSMTWithHashConstructor(2, md5.New, emptyHash)
+	assert.True(t, withConstructor.hashFunc != withConstructor.Clone().hashFunc)
+}
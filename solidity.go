@@ -0,0 +1,29 @@
+package merkle
+
+import (
+	"errors"
+	"math/big"
+)
+
+// SolidityProof returns the proof of the leaf at index as bytes32 hashes and a
+// direction bitmap, ready to be ABI encoded for on-chain verification. Bit i
+// of directions is set when the i-th proof node is a left sibling. The tree
+// hash function must produce 32 bytes.
+func (self *Tree) SolidityProof(index uint) (hashes [][32]byte, directions *big.Int, err error) {
+	proof, err := self.GetMerkleProof(index)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes = make([][32]byte, len(proof))
+	directions = new(big.Int)
+	for i, n := range proof {
+		if len(n.Hash) != 32 {
+			return nil, nil, errors.New("proof hashes should be 32 bytes")
+		}
+		copy(hashes[i][:], n.Hash)
+		if n.Left {
+			directions.SetBit(directions, i, 1)
+		}
+	}
+	return hashes, directions, nil
+}
@@ -0,0 +1,54 @@
+package merkle
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSolidityProof(t *testing.T) {
+	h := sha256.New()
+	data := createDummyTreeData(11, h.Size(), true)
+	tree := NewTree(h)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+
+	for i := range data {
+		hashes, directions, err := tree.SolidityProof(uint(i))
+		assert.Nil(t, err)
+		proof, err := tree.GetMerkleProof(uint(i))
+		assert.Nil(t, err)
+		assert.Len(t, hashes, len(proof))
+		assert.True(t, directions.BitLen() <= len(proof))
+
+		// rebuild the proof from the calldata and check it
+		decoded := make([]ProofNode, len(hashes))
+		for j := range hashes {
+			assert.Equal(t, proof[j].Hash, hashes[j][:])
+			assert.Equal(t, proof[j].Left, directions.Bit(j) == 1)
+			decoded[j] = ProofNode{Hash: hashes[j][:], Left: directions.Bit(j) == 1}
+		}
+		ok, err := VerifyProof(data[i], decoded, tree.RootHash(), h, TreeOptions{})
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	}
+
+	// leaf 10 is promoted at the leaves and at the third level, its two
+	// siblings are on the left
+	_, directions, err := tree.SolidityProof(10)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), directions.Int64())
+
+	_, _, err = tree.SolidityProof(11)
+	assert.Equal(t, "node index is too big for node count", err.Error())
+}
+
+func TestSolidityProofHashSize(t *testing.T) {
+	tree := NewTree(md5.New())
+	err := tree.Generate(createDummyTreeData(4, md5.Size, true), 0)
+	assert.Nil(t, err)
+	_, _, err = tree.SolidityProof(0)
+	assert.Equal(t, "proof hashes should be 32 bytes", err.Error())
+}
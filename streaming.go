@@ -0,0 +1,168 @@
+package merkle
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+)
+
+// StreamingRoot returns the root of the tree configured with opts whose leaf
+// blocks are the successive blocks of blockSize bytes read from r, the last
+// one being possibly shorter.
+// Only the frontier of complete subtrees is kept, one hash per level, so the
+// memory used grows with the height of the tree and not with its size.
+// Only binary trees which promote lone nodes and are not padded are supported.
+func StreamingRoot(r io.Reader, blockSize int, h hash.Hash, opts TreeOptions) ([]byte, error) {
+	err := checkFrontierOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if blockSize <= 0 {
+		return nil, errors.New("block size should be positive")
+	}
+	// frontier[i] is the root of the complete subtree of 2^i leaves, if the
+	// number of leaves read so far has bit i set
+	frontier := [][]byte{}
+	size := uint64(0)
+	buf := make([]byte, blockSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+
+		carry, err := leafOf(append([]byte{}, buf[:n]...), h, opts)
+		if err != nil {
+			return nil, err
+		}
+		level := 0
+		for s := size; s&1 == 1; s >>= 1 {
+			node, err := NewNode(h, concatHashes(frontier[level], carry, opts.EnableHashSorting))
+			if err != nil {
+				return nil, err
+			}
+			carry = node.Hash
+			frontier[level] = nil
+			level++
+		}
+		if level == len(frontier) {
+			frontier = append(frontier, nil)
+		}
+		frontier[level] = carry
+		size++
+
+		if err == io.ErrUnexpectedEOF {
+			break
+		}
+	}
+	if size == 0 {
+		return nil, errors.New("Empty tree")
+	}
+
+	// Fold the frontier from the smallest subtree up
+	var root []byte
+	for _, hash := range frontier {
+		if hash == nil {
+			continue
+		}
+		if root == nil {
+			root = hash
+			continue
+		}
+		node, err := NewNode(h, concatHashes(hash, root, opts.EnableHashSorting))
+		if err != nil {
+			return nil, err
+		}
+		root = node.Hash
+	}
+	return root, nil
+}
+
+// VerifyProofStream reads a proof encoded by Proof.MarshalBinary from r and
+// folds it onto the leaf node by node, so only one hash is held at a time. The
+// Left flags read first are limited to 256 nodes, which bounds the memory
+// used. The proof must match the path of its leaf index in a tree of its
+// leaf count. The leaf data is stored the way a Tree configured with opts
+// stores it. Only binary trees which promote lone nodes are supported.
+func VerifyProofStream(r io.Reader, leafData, root []byte, h hash.Hash, opts TreeOptions) (bool, error) {
+	err := checkBinaryPromoteOptions(opts)
+	if err != nil {
+		return false, err
+	}
+	var header [proofHeaderSize]byte
+	_, err = io.ReadFull(r, header[:1])
+	if err != nil {
+		return false, errors.New("proof encoding is too short")
+	}
+	if header[0] != proofEncodingVersion {
+		return false, errors.New("unsupported proof encoding version")
+	}
+	_, err = io.ReadFull(r, header[1:])
+	if err != nil {
+		return false, errors.New("proof encoding is too short")
+	}
+	hashSize := int(header[1])
+	index := binary.BigEndian.Uint64(header[2:10])
+	leafCount := binary.BigEndian.Uint64(header[10:18])
+	count := int(binary.BigEndian.Uint32(header[19:23]))
+	if leafCount == 0 {
+		return false, errors.New("Empty tree")
+	}
+	if index >= leafCount {
+		return false, errors.New("node index is too big for node count")
+	}
+	if calculateTreeHeight(leafCount) != uint64(header[18]) {
+		return false, errors.New("tree height does not match the leaf count")
+	}
+	if count > 256 {
+		return false, errors.New("proof has too many nodes")
+	}
+
+	var bitmap [32]byte
+	_, err = io.ReadFull(r, bitmap[:(count+7)/8])
+	if err != nil {
+		return false, errors.New("proof encoding does not match its node count")
+	}
+	current, err := leafOf(leafData, h, opts)
+	if err != nil {
+		return false, err
+	}
+	sibling := make([]byte, hashSize)
+	last := leafCount - 1
+	for i := 0; i < count; i++ {
+		_, err = io.ReadFull(r, sibling)
+		if err != nil {
+			return false, errors.New("proof encoding does not match its node count")
+		}
+		// Skip the levels where the node is promoted without a sibling
+		for last > 0 && index == last && index%2 == 0 {
+			index, last = index/2, last/2
+		}
+		left := bitmap[i/8]&(1<<uint(i%8)) != 0
+		if last == 0 || left != (index%2 == 1) {
+			return false, nil
+		}
+		data := concatHashes(current, sibling, opts.EnableHashSorting)
+		if left {
+			data = concatHashes(sibling, current, opts.EnableHashSorting)
+		}
+		node, err := NewNode(h, data)
+		if err != nil {
+			return false, err
+		}
+		current = node.Hash
+		index, last = index/2, last/2
+	}
+	for last > 0 && index == last && index%2 == 0 {
+		index, last = index/2, last/2
+	}
+	if last != 0 {
+		return false, nil
+	}
+	return bytes.Equal(current, root), nil
+}
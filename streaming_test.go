@@ -0,0 +1,127 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamingRoot(t *testing.T) {
+	h := sha256.New()
+	for _, sorting := range []bool{false, true} {
+		opts := TreeOptions{EnableHashSorting: sorting}
+		for _, size := range []int{1, 2, 31, 32, 33, 100, 1000} {
+			stream := make([]byte, size*h.Size()+7)
+			for i := range stream {
+				stream[i] = byte(i * 31)
+			}
+			blocks := [][]byte{}
+			for i := 0; i < len(stream); i += h.Size() {
+				end := i + h.Size()
+				if end > len(stream) {
+					end = len(stream)
+				}
+				blocks = append(blocks, stream[i:end])
+			}
+			tree := NewTreeWithOpts(h, opts)
+			err := tree.Generate(blocks, 0)
+			assert.Nil(t, err)
+
+			root, err := StreamingRoot(bytes.NewReader(stream), h.Size(), h, opts)
+			assert.Nil(t, err)
+			assert.Equal(t, tree.RootHash(), root)
+		}
+	}
+
+	_, err := StreamingRoot(bytes.NewReader(nil), 32, h, TreeOptions{})
+	assert.Equal(t, "Empty tree", err.Error())
+	_, err = StreamingRoot(bytes.NewReader(nil), 0, h, TreeOptions{})
+	assert.Equal(t, "block size should be positive", err.Error())
+	_, err = StreamingRoot(bytes.NewReader(make([]byte, 64)), 32, NewFailingHash(), TreeOptions{})
+	assert.Equal(t, "Failed to write hash", err.Error())
+
+	// the frontier only folds binary trees which promote lone nodes
+	_, err = StreamingRoot(bytes.NewReader(make([]byte, 96)), 32, h, TreeOptions{OddNodeStrategy: OddNodeDuplicate})
+	assert.Equal(t, "proof needs the OddNodePromote strategy", err.Error())
+	_, err = StreamingRoot(bytes.NewReader(make([]byte, 96)), 32, h, TreeOptions{Arity: 4})
+	assert.Equal(t, "tree options should have an arity of 2", err.Error())
+	_, err = StreamingRoot(bytes.NewReader(make([]byte, 96)), 32, h, TreeOptions{PadToPowerOfTwo: true})
+	assert.Equal(t, "PadToPowerOfTwo is not supported", err.Error())
+}
+
+type patternReader struct{}
+
+func (patternReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(i)
+	}
+	return len(p), nil
+}
+
+func BenchmarkStreamingRoot_1GB_4KB_SHA256(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		_, err := StreamingRoot(io.LimitReader(patternReader{}, 1<<30), 4096, sha256.New(), TreeOptions{})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestVerifyProofStream(t *testing.T) {
+	h := sha256.New()
+	data := createDummyTreeData(11, h.Size(), true)
+	tree := NewTree(h)
+	err := tree.Generate(data, 0)
+	assert.Nil(t, err)
+
+	for i := range data {
+		proof, err := tree.GetProof(uint(i))
+		assert.Nil(t, err)
+		encoded, err := proof.MarshalBinary()
+		assert.Nil(t, err)
+		ok, err := VerifyProofStream(bytes.NewReader(encoded), data[i], tree.RootHash(), h, tree.opts)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+
+		// another leaf, or the proof of another index, does not verify
+		ok, err = VerifyProofStream(bytes.NewReader(encoded), data[(i+1)%11], tree.RootHash(), h, tree.opts)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+		proof.LeafIndex = uint((i + 1) % 11)
+		encoded, err = proof.MarshalBinary()
+		assert.Nil(t, err)
+		ok, err = VerifyProofStream(bytes.NewReader(encoded), data[i], tree.RootHash(), h, tree.opts)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	}
+
+	// a truncated proof
+	proof, err := tree.GetProof(4)
+	assert.Nil(t, err)
+	shorter := *proof
+	shorter.Nodes = proof.Nodes[:len(proof.Nodes)-1]
+	encoded, err := shorter.MarshalBinary()
+	assert.Nil(t, err)
+	ok, err := VerifyProofStream(bytes.NewReader(encoded), data[4], tree.RootHash(), h, tree.opts)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	encoded, err = proof.MarshalBinary()
+	assert.Nil(t, err)
+	_, err = VerifyProofStream(bytes.NewReader(encoded[:len(encoded)-1]), data[4], tree.RootHash(), h, tree.opts)
+	assert.Equal(t, "proof encoding does not match its node count", err.Error())
+	_, err = VerifyProofStream(bytes.NewReader(encoded[:10]), data[4], tree.RootHash(), h, tree.opts)
+	assert.Equal(t, "proof encoding is too short", err.Error())
+	proof.TreeHeight = 4
+	encoded, err = proof.MarshalBinary()
+	assert.Nil(t, err)
+	_, err = VerifyProofStream(bytes.NewReader(encoded), data[4], tree.RootHash(), h, tree.opts)
+	assert.Equal(t, "tree height does not match the leaf count", err.Error())
+	_, err = VerifyProofStream(bytes.NewReader(encoded), data[4], tree.RootHash(), h, TreeOptions{OddNodeStrategy: OddNodeDuplicate})
+	assert.Equal(t, "proof needs the OddNodePromote strategy", err.Error())
+	_, err = VerifyProofStream(bytes.NewReader(encoded), data[4], tree.RootHash(), h, TreeOptions{Arity: 4})
+	assert.Equal(t, "tree options should have an arity of 2", err.Error())
+}
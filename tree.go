@@ -12,6 +12,38 @@ type ProofNode struct {
 	Hash []byte
 }
 
+// Proof is a self-describing proof: along with its nodes it records the
+// position of the leaf and the size of the tree it was generated from
+type Proof struct {
+	LeafIndex  uint        `json:"leafIndex"`
+	LeafCount  uint64      `json:"leafCount"`
+	TreeHeight uint64      `json:"treeHeight"`
+	Nodes      []ProofNode `json:"nodes"`
+}
+
+// SortedProof is a proof of a tree with hash sorting enabled. The order of
+// each pair of hashes is given by their values, so the sides of the siblings
+// are not recorded.
+type SortedProof [][]byte
+
+// ProofNodeMarked is a proof node that also records the levels where the
+// node on the path was carried up without a sibling. Promoted nodes have no
+// hash and are skipped by the verifier.
+type ProofNodeMarked struct {
+	Hash     []byte
+	Left     bool
+	Promoted bool
+}
+
+// ProofNodeAnnotated is a proof node that also records the position of the
+// sibling in the tree, to tell which node differs when a proof does not
+// verify
+type ProofNodeAnnotated struct {
+	Hash     []byte
+	Left     bool
+	Position HashPosition
+}
+
 type MerkleTree interface {
 	Generate(leaves [][]byte, totalLeavesSize int) error
 	RootHash() []byte
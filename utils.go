@@ -1,11 +1,38 @@
 package merkle
 
+import (
+	"errors"
+	"hash"
+	"math"
+)
+
 // Returns true if n is a power of 2
 func isPowerOfTwo(n uint64) bool {
 	// http://graphics.stanford.edu/~seander/bithacks.html#DetermineIfPowerOf2
 	return n != 0 && (n&(n-1)) == 0
 }
 
+// Returns true if n is a power of base
+func isPowerOf(n, base uint64) bool {
+	if n == 0 || base < 2 {
+		return false
+	}
+	for n%base == 0 {
+		n /= base
+	}
+	return n == 1
+}
+
+// Returns log_base(n) assuming n is a power of base
+func logBase(n, base uint64) uint64 {
+	ct := uint64(0)
+	for n >= base {
+		n /= base
+		ct += 1
+	}
+	return ct
+}
+
 // Returns the next highest power of 2 above n, if n is not already a
 // power of 2
 func nextPowerOfTwo(n uint64) uint64 {
@@ -24,6 +51,22 @@ func nextPowerOfTwo(n uint64) uint64 {
 	return n
 }
 
+// Returns the smallest power of base that is not below n, or an error if
+// that power does not fit in 64 bits
+func nextPowerOf(n, base uint64) (uint64, error) {
+	if base < 2 {
+		return 0, errors.New("base should be at least 2")
+	}
+	power := uint64(1)
+	for power < n {
+		if power > math.MaxUint64/base {
+			return 0, errors.New("next power overflows")
+		}
+		power *= base
+	}
+	return power, nil
+}
+
 // Lookup table for integer log2 implementation
 var log2lookup []uint64 = []uint64{
 	0xFFFFFFFF00000000,
@@ -69,3 +112,25 @@ func ceilLogBaseTwo(x uint64) uint64 {
 
 	return y
 }
+
+// AreSiblings returns true if the leaves at a and b share a parent
+func AreSiblings(a, b uint) bool {
+	return a != b && a/2 == b/2
+}
+
+// SiblingIndex returns the index of the node sharing a parent with i
+func SiblingIndex(i uint) uint {
+	return i ^ 1
+}
+
+// Returns the hash of the concatenation of parts
+func hashConcat(hashFunc hash.Hash, parts ...[]byte) ([]byte, error) {
+	defer hashFunc.Reset()
+	for _, part := range parts {
+		_, err := hashFunc.Write(part)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return hashFunc.Sum(nil), nil
+}
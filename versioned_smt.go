@@ -0,0 +1,172 @@
+package merkle
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+)
+
+// VersionedSMT keeps one SMT per committed version, so roots and proofs of
+// past versions stay available after newer versions are committed. A version
+// shares with the previous one the nodes whose subtrees did not change, and
+// all versions share the hashes of the empty subtrees.
+type VersionedSMT struct {
+	emptyHash Hash
+	hashFunc  hash.Hash
+	totalSize int
+	versions  []uint64
+	trees     map[uint64]*SMT
+	// Hashes of the empty subtrees up to the root, computed by the first
+	// commit
+	emptyTreeRootHash []Hash
+}
+
+// NewVersionedSMT creates a versioned tree whose versions all have totalSize
+// leaves
+func NewVersionedSMT(emptyHash Hash, hashFunc hash.Hash, totalSize int) *VersionedSMT {
+	return &VersionedSMT{emptyHash: emptyHash, hashFunc: hashFunc, totalSize: totalSize, versions: []uint64{}, trees: map[uint64]*SMT{}}
+}
+
+// Commit generates the tree of leaves as the given version, which must be
+// greater than every committed version. Only the nodes above the leaves which
+// differ from the latest version are hashed.
+func (self *VersionedSMT) Commit(version uint64, leaves [][]byte) error {
+	if len(self.versions) > 0 && version <= self.versions[len(self.versions)-1] {
+		return errors.New("Version should be greater than the latest version")
+	}
+	var tree *SMT
+	var err error
+	if len(self.versions) == 0 {
+		tree, err = self.generateFirst(leaves)
+	} else {
+		tree, err = self.generateFrom(self.trees[self.versions[len(self.versions)-1]], leaves)
+	}
+	if err != nil {
+		return err
+	}
+	self.versions = append(self.versions, version)
+	self.trees[version] = tree
+	return nil
+}
+
+// Versions returns the committed versions in increasing order
+func (self *VersionedSMT) Versions() []uint64 {
+	versions := make([]uint64, len(self.versions))
+	copy(versions, self.versions)
+	return versions
+}
+
+// RootAtVersion returns the root hash of the given version
+func (self *VersionedSMT) RootAtVersion(version uint64) ([]byte, error) {
+	tree, ok := self.trees[version]
+	if !ok {
+		return nil, errors.New("Version not found")
+	}
+	return tree.RootHash(), nil
+}
+
+// GetMerkleProofAtVersion returns the proof of a leaf against the root of the
+// given version
+func (self *VersionedSMT) GetMerkleProofAtVersion(version uint64, leafNo uint) ([]ProofNode, error) {
+	tree, ok := self.trees[version]
+	if !ok {
+		return nil, errors.New("Version not found")
+	}
+	return tree.GetMerkleProof(leafNo)
+}
+
+// Following are non public
+
+// Generates the tree of the first version and the hashes of the empty
+// subtrees that the later versions share
+func (self *VersionedSMT) generateFirst(leaves [][]byte) (*SMT, error) {
+	tree := NewSMT(self.emptyHash, self.hashFunc)
+	err := tree.Generate(leaves, self.totalSize)
+	if err != nil {
+		return nil, err
+	}
+	err = tree.computeEmptyLeavesSubTreeHash(tree.hasher(), tree.treeHeight)
+	if err != nil {
+		return nil, err
+	}
+	self.emptyTreeRootHash = tree.emptyTreeRootHash
+	return tree, nil
+}
+
+// Generates the tree of leaves from the tree of the previous version. A node
+// whose children are the same in both versions keeps the hash of the previous
+// version, and a level without change is shared as a whole.
+func (self *VersionedSMT) generateFrom(previous *SMT, leaves [][]byte) (*SMT, error) {
+	if len(leaves) > self.totalSize {
+		return nil, errors.New("NonEmptyLeaves is bigger than totalSize")
+	}
+	tree := NewSMT(self.emptyHash, self.hashFunc)
+	tree.treeHeight = previous.treeHeight
+	tree.countOfNonEmptyLeaves = len(leaves)
+	tree.emptyTreeRootHash = self.emptyTreeRootHash
+	h := tree.hasher()
+
+	// changed[i] tells that node i of the level differs from the previous
+	// version
+	level := make([]Hash, len(leaves))
+	changed := make([]bool, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = leaf
+		changed[i] = i >= len(previous.fullNodes[0]) || !bytes.Equal(leaf, previous.fullNodes[0][i])
+		if !changed[i] {
+			level[i] = previous.fullNodes[0][i]
+		}
+	}
+	tree.fullNodes = append(tree.fullNodes, shareLevel(level, changed, previous.fullNodes[0]))
+
+	for height := 0; height < tree.treeHeight-1; height++ {
+		below := level
+		previousBelow := previous.fullNodes[height]
+		previousLevel := previous.fullNodes[height+1]
+		width := (len(below) + tree.arity - 1) / tree.arity
+		level = make([]Hash, width)
+		belowChanged := changed
+		changed = make([]bool, width)
+		for parent := range level {
+			first := parent * tree.arity
+			same := parent < len(previousLevel)
+			children := make([]Hash, tree.arity)
+			for i := range children {
+				index := first + i
+				if index < len(below) {
+					children[i] = below[index]
+					same = same && !belowChanged[index]
+				} else {
+					children[i] = tree.emptySubtree(height)
+					same = same && index >= len(previousBelow)
+				}
+			}
+			if same {
+				level[parent] = previousLevel[parent]
+				continue
+			}
+			hash, err := tree.parentHash(h, children...)
+			if err != nil {
+				return nil, err
+			}
+			level[parent] = hash
+			changed[parent] = true
+		}
+		tree.fullNodes = append(tree.fullNodes, shareLevel(level, changed, previousLevel))
+	}
+	return tree, nil
+}
+
+// Returns the level of the previous version if no node changed, level
+// otherwise
+func shareLevel(level []Hash, changed []bool, previous []Hash) []Hash {
+	if len(level) != len(previous) {
+		return level
+	}
+	for _, c := range changed {
+		if c {
+			return level
+		}
+	}
+	return previous
+}
@@ -0,0 +1,125 @@
+package merkle
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersionedSMT(t *testing.T) {
+	hash := hashFunc
+	tree := NewVersionedSMT(emptyHash, hash, 16)
+	commits := map[uint64][][]byte{
+		1: testHashes[:3],
+		2: testHashes[:5],
+		5: testHashes[2:11],
+	}
+	for _, v := range []uint64{1, 2, 5} {
+		err := tree.Commit(v, commits[v])
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, []uint64{1, 2, 5}, tree.Versions())
+
+	for v, leaves := range commits {
+		expected := NewSMT(emptyHash, hash)
+		err := expected.Generate(leaves, 16)
+		assert.Nil(t, err)
+		root, err := tree.RootAtVersion(v)
+		assert.Nil(t, err)
+		assert.Equal(t, expected.RootHash(), root)
+
+		for i, leaf := range leaves {
+			proof, err := tree.GetMerkleProofAtVersion(v, uint(i))
+			assert.Nil(t, err)
+			ok, err := VerifyProof(leaf, proof, root, hash, TreeOptions{})
+			assert.Nil(t, err)
+			assert.True(t, ok)
+		}
+	}
+
+	// a leaf of version 5 is not part of version 1
+	proof, err := tree.GetMerkleProofAtVersion(5, 0)
+	assert.Nil(t, err)
+	root, err := tree.RootAtVersion(1)
+	assert.Nil(t, err)
+	ok, err := VerifyProof(testHashes[2], proof, root, hash, TreeOptions{})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestVersionedSMTInvalidArgument(t *testing.T) {
+	tree := NewVersionedSMT(emptyHash, hashFunc, 8)
+	err := tree.Commit(3, testHashes[:2])
+	assert.Nil(t, err)
+
+	err = tree.Commit(3, testHashes[:4])
+	assert.Equal(t, "Version should be greater than the latest version", err.Error())
+	err = tree.Commit(4, testHashes)
+	assert.Equal(t, "NonEmptyLeaves is bigger than totalSize", err.Error())
+	assert.Equal(t, []uint64{3}, tree.Versions())
+
+	_, err = tree.RootAtVersion(4)
+	assert.Equal(t, "Version not found", err.Error())
+	_, err = tree.GetMerkleProofAtVersion(4, 0)
+	assert.Equal(t, "Version not found", err.Error())
+}
+
+func TestVersionedSMTSharesUnchangedNodes(t *testing.T) {
+	count := 0
+	hash := NewHashCountDecorator(hashFunc, &count)
+	tree := NewVersionedSMT(emptyHash, hash, 16)
+	leaves := append([][]byte{}, testHashes[:6]...)
+	err := tree.Commit(1, leaves)
+	assert.Nil(t, err)
+
+	// changing one leaf hashes its path to the root only
+	changed := append([][]byte{}, leaves...)
+	changed[4] = testHashes[10]
+	count = 0
+	err = tree.Commit(2, changed)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, count)
+
+	// committing the same leaves hashes nothing and shares every level
+	count = 0
+	err = tree.Commit(3, changed)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+
+	first, second, third := tree.trees[1], tree.trees[2], tree.trees[3]
+	assert.Equal(t, &first.emptyTreeRootHash[0], &second.emptyTreeRootHash[0])
+	assert.Equal(t, &first.emptyTreeRootHash[0], &third.emptyTreeRootHash[0])
+	assert.Equal(t, &first.fullNodes[0][0][0], &second.fullNodes[0][0][0])
+	assert.Equal(t, &first.fullNodes[1][0][0], &second.fullNodes[1][0][0])
+	for level := range second.fullNodes {
+		assert.Equal(t, &second.fullNodes[level][0], &third.fullNodes[level][0])
+	}
+
+	// growing and shrinking the leaves give the roots of a full generation
+	commits := map[uint64][][]byte{
+		4: testHashes[:9],
+		5: testHashes[:2],
+		6: {},
+		7: testHashes[3:16],
+	}
+	for _, v := range []uint64{4, 5, 6, 7} {
+		err = tree.Commit(v, commits[v])
+		assert.Nil(t, err)
+	}
+	commits[1], commits[2], commits[3] = leaves, changed, changed
+	for v, leaves := range commits {
+		expected := NewSMT(emptyHash, hashFunc)
+		err = expected.Generate(leaves, 16)
+		assert.Nil(t, err)
+		root, err := tree.RootAtVersion(v)
+		assert.Nil(t, err)
+		assert.Equal(t, expected.RootHash(), root)
+		for i, leaf := range leaves {
+			proof, err := tree.GetMerkleProofAtVersion(v, uint(i))
+			assert.Nil(t, err)
+			ok, err := VerifyProof(leaf, proof, root, hashFunc, TreeOptions{})
+			assert.Nil(t, err)
+			assert.True(t, ok)
+		}
+	}
+}